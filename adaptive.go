@@ -0,0 +1,93 @@
+package bypass
+
+import (
+	"sort"
+	"time"
+)
+
+// SetAdaptiveOrder enables the adaptive ordering of the rules: every interval, the rules are sorted
+// by descending recent hits so that the hot rules are evaluated first.
+// The recent hits of a rule decay by half at each interval.
+// The rules are ORed and the negated rules take precedence whatever their position,
+// so the order does not change the decisions, only the rule reported as matched
+// when several rules match an address.
+// The ordering stops when the bypass is stopped. A non-positive interval disables it.
+func (bp *bypasser) SetAdaptiveOrder(interval time.Duration) {
+	bp.mux.Lock()
+	defer bp.mux.Unlock()
+
+	if bp.reorderStop != nil {
+		close(bp.reorderStop)
+		bp.reorderStop = nil
+	}
+	if interval <= 0 {
+		return
+	}
+
+	stop := make(chan struct{})
+	bp.reorderStop = stop
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		var state orderState
+		for {
+			select {
+			case <-ticker.C:
+				bp.reorder(&state)
+			case <-stop:
+				return
+			case <-bp.stopped:
+				return
+			}
+		}
+	}()
+}
+
+// orderState is the state of the adaptive ordering of the rules.
+type orderState struct {
+	matchers []Matcher // the rules as ordered by the last reorder
+	last     []uint64  // the hits of the rules at the last reorder
+	scores   []uint64  // the decaying recent hits of the rules
+}
+
+// reorder updates the recent hits of the rules since the last call and sorts the rules by them.
+func (bp *bypasser) reorder(state *orderState) {
+	bp.mux.Lock()
+	defer bp.mux.Unlock()
+
+	n := len(bp.matchers)
+	if n == 0 || len(bp.hits) != n || bp.specificityOrder {
+		return
+	}
+	// the rules have been changed since the last reorder
+	if len(state.matchers) != n || &state.matchers[0] != &bp.matchers[0] {
+		state.last = make([]uint64, n)
+		state.scores = make([]uint64, n)
+	}
+
+	idx := make([]int, n)
+	for i := range idx {
+		hits := bp.hits[i]
+		state.scores[i] = state.scores[i]/2 + (hits - state.last[i])
+		state.last[i] = hits
+		idx[i] = i
+	}
+	sort.SliceStable(idx, func(a, b int) bool {
+		return state.scores[idx[a]] > state.scores[idx[b]]
+	})
+
+	matchers := make([]Matcher, n)
+	hits := make([]uint64, n)
+	last := make([]uint64, n)
+	scores := make([]uint64, n)
+	for i, j := range idx {
+		matchers[i] = bp.matchers[j]
+		hits[i] = bp.hits[j]
+		last[i] = state.last[j]
+		scores[i] = state.scores[j]
+	}
+	bp.matchers, bp.hits = matchers, hits
+	bp.index = newRuleIndex(matchers)
+	state.matchers, state.last, state.scores = matchers, last, scores
+}
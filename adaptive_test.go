@@ -0,0 +1,121 @@
+package bypass
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestReorder(t *testing.T) {
+	bp := NewBypasserPatterns(false, "10.0.0.0/8", "*.example.com", "192.168.1.1", ".example.org").(*bypasser)
+
+	addrs := []string{"10.1.2.3", "www.example.com", "192.168.1.1", "example.org", "172.16.0.1"}
+	want := make([]bool, len(addrs))
+	for i, addr := range addrs {
+		want[i] = bp.Bypass(addr)
+	}
+
+	var state orderState
+	bp.reorder(&state)
+	for i := 0; i < 10; i++ {
+		bp.Bypass("www.example.org")
+	}
+	for i := 0; i < 5; i++ {
+		bp.Bypass("192.168.1.1")
+	}
+	bp.reorder(&state)
+
+	var order []string
+	for _, m := range bp.matchers {
+		order = append(order, m.String())
+	}
+	if s, want := fmt.Sprint(order), "[domain .example.org ip 192.168.1.1 cidr 10.0.0.0/8 domain *.example.com]"; s != want {
+		t.Errorf("expected the order %s, got %s", want, s)
+	}
+	if bp.hits[0] != 11 || bp.hits[1] != 6 || bp.hits[2] != 1 {
+		t.Errorf("the hits should follow the rules, got %v", bp.hits)
+	}
+
+	for i, addr := range addrs {
+		if bp.Bypass(addr) != want[i] {
+			t.Errorf("the order should not change the decision for %s", addr)
+		}
+	}
+
+	// the recent hits decay
+	for i := 0; i < 30; i++ {
+		bp.Bypass("10.1.2.3")
+	}
+	bp.reorder(&state)
+	if s := bp.matchers[0].String(); s != "cidr 10.0.0.0/8" {
+		t.Errorf("the hot rule should be first, got %s", s)
+	}
+}
+
+func TestSetAdaptiveOrder(t *testing.T) {
+	bp := NewBypasserPatterns(false, "10.0.0.0/8", "*.example.com").(*bypasser)
+	defer bp.Stop()
+
+	bp.SetAdaptiveOrder(10 * time.Millisecond)
+	for i := 0; i < 10; i++ {
+		bp.Bypass("www.example.com")
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		bp.mux.RLock()
+		first := bp.matchers[0].String()
+		bp.mux.RUnlock()
+		if first == "domain *.example.com" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("the rules should be reordered")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	bp.SetAdaptiveOrder(0)
+}
+
+// matchPosition returns the average position of the first matching rule for the addresses.
+func matchPosition(bp *bypasser, addrs []string) float64 {
+	var sum int
+	for _, addr := range addrs {
+		sum += bp.match(newTarget(addr), kindAny) + 1
+	}
+	return float64(sum) / float64(len(addrs))
+}
+
+func BenchmarkReorderSkewed(b *testing.B) {
+	// regex rules, on the linear scan path, the indexed domain rules do not depend on their order
+	var patterns []string
+	for i := 0; i < 100; i++ {
+		patterns = append(patterns, fmt.Sprintf(`/^www\.example%d\.com$/`, i))
+	}
+	// most of the traffic hits the last rules
+	var addrs []string
+	for i := 0; i < 100; i++ {
+		addrs = append(addrs, fmt.Sprintf("www.example%d.com", 99-i%5))
+	}
+	addrs = append(addrs, "www.example0.com")
+
+	for _, reorder := range []bool{false, true} {
+		b.Run(fmt.Sprintf("reorder=%v", reorder), func(b *testing.B) {
+			bp := NewBypasserPatterns(false, patterns...).(*bypasser)
+			if reorder {
+				for _, addr := range addrs {
+					bp.Bypass(addr)
+				}
+				bp.reorder(&orderState{})
+			}
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				bp.Bypass(addrs[i%len(addrs)])
+			}
+			// after the loop, ResetTimer drops the metrics reported before it
+			b.ReportMetric(matchPosition(bp, addrs), "pos/op")
+		})
+	}
+}
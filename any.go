@@ -0,0 +1,34 @@
+package bypass
+
+type anyMatcher struct {
+	raw string // the pattern, if compiled from the config
+}
+
+// AnyMatcher creates a Matcher for any address, an IP address, a CIDR, a domain or an URL alike,
+// such as the catch-all pattern '*' in the config.
+func AnyMatcher() Matcher {
+	return &anyMatcher{}
+}
+
+func (m *anyMatcher) Match(string) bool {
+	return m != nil
+}
+
+func (m *anyMatcher) String() string {
+	return "any"
+}
+
+type noneMatcher struct{}
+
+// NoneMatcher creates a Matcher for no address, such as a placeholder for a rule to be set at runtime.
+func NoneMatcher() Matcher {
+	return &noneMatcher{}
+}
+
+func (m *noneMatcher) Match(string) bool {
+	return false
+}
+
+func (m *noneMatcher) String() string {
+	return "none"
+}
@@ -0,0 +1,85 @@
+package bypass
+
+import (
+	"strings"
+	"testing"
+)
+
+var anyInputs = []string{
+	"192.168.1.1",
+	"192.168.0.0/16",
+	"::1",
+	"2001:db8::1",
+	"fe80::1%eth0",
+	"[::1]:80",
+	"example.com",
+	"example.com:80",
+	"http://example.com",
+	"例子.测试",
+}
+
+func TestAnyMatcher(t *testing.T) {
+	for _, m := range []Matcher{AnyMatcher(), NewMatcher("*"), mustCompile(t, &Compiler{Wildcard: '%'}, "%")} {
+		if _, ok := m.(*anyMatcher); !ok {
+			t.Fatalf("expected an any matcher, got %v", m)
+		}
+		for _, s := range anyInputs {
+			if !m.Match(s) {
+				t.Errorf("%v should match %q", m, s)
+			}
+		}
+	}
+	if _, ok := mustCompile(t, &Compiler{Wildcard: '%'}, "*").(*domainMatcher); !ok {
+		t.Errorf("'*' should be a domain pattern with another wildcard")
+	}
+	if _, ok := NewMatcher("*.example.com").(*domainMatcher); !ok {
+		t.Errorf("'*.example.com' should be a domain pattern")
+	}
+
+	m := NoneMatcher()
+	for _, s := range anyInputs {
+		if m.Match(s) {
+			t.Errorf("%v should not match %q", m, s)
+		}
+	}
+}
+
+func TestAnyMatcherBypass(t *testing.T) {
+	for _, reversed := range []bool{false, true} {
+		bp := NewBypasserPatterns(reversed, "*")
+		for _, addr := range anyInputs {
+			if bp.Bypass(addr) == reversed {
+				t.Errorf("reversed %v, %s: expected %v", reversed, addr, !reversed)
+			}
+		}
+	}
+
+	// the catch-all applies to the IP addresses as well as the domains whatever the reverse-domains option
+	bp := NewBypasser(false).(*bypasser)
+	if err := bp.Reload(strings.NewReader("reverse-domains false\n!secure.example.com\n*\n")); err != nil {
+		t.Fatal(err)
+	}
+	if !bp.Bypass("192.168.1.1") || !bp.Bypass("[::1]:80") || !bp.Bypass("www.example.com") || bp.Bypass("secure.example.com") {
+		t.Errorf("the catch-all should bypass any address but the exception")
+	}
+
+	var sb strings.Builder
+	if err := bp.WriteConfig(&sb); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(sb.String(), "\n!secure.example.com\n*\n") {
+		t.Errorf("the catch-all should be written as '*', got %q", sb.String())
+	}
+	if _, err := NewBypasser(false, NoneMatcher()).(*bypasser).MarshalJSON(); err == nil {
+		t.Errorf("the none matcher has no pattern")
+	}
+}
+
+func mustCompile(t *testing.T, c *Compiler, pattern string) Matcher {
+	t.Helper()
+	m, err := c.Compile(pattern)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
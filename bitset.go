@@ -0,0 +1,58 @@
+package bypass
+
+// BypassBitset evaluates the addresses addrs against a single snapshot of the rules,
+// reloads are not seen in the middle of the evaluation.
+// It returns a bitset with bit i set when Bypass(addrs[i]) is true,
+// bit i is bit i%64 of the word i/64.
+// Each address is counted in Stats and evaluated against the shadow, as by Bypass.
+func (bp *bypasser) BypassBitset(addrs []string) []uint64 {
+	bits := make([]uint64, (len(addrs)+63)/64)
+	for i, bypassed := range bp.BypassAll(addrs) {
+		if bypassed {
+			bits[i/64] |= 1 << uint(i%64)
+		}
+	}
+	return bits
+}
+
+// BypassAll evaluates the addresses addrs against a single snapshot of the rules, as BypassBitset does,
+// taking the lock once for the whole batch.
+// It returns a slice with the element i set to Bypass(addrs[i]).
+// Each address is counted in Stats and evaluated against the shadow, as by Bypass.
+func (bp *bypasser) BypassAll(addrs []string) []bool {
+	bypassed := make([]bool, len(addrs))
+	if bp == nil {
+		return bypassed
+	}
+
+	targets := bp.prefetchAll(addrs)
+
+	bp.mux.RLock()
+	for i, addr := range addrs {
+		if addr == "" {
+			continue
+		}
+		bypassed[i], _ = bp.decide(targets[i])
+	}
+	shadow := bp.shadow
+	bp.mux.RUnlock()
+
+	for i, addr := range addrs {
+		if addr != "" {
+			bp.record(shadow, targets[i], bypassed[i])
+		}
+	}
+	return bypassed
+}
+
+// prefetchAll parses the addresses addrs and does the lookups of their targets, see prefetch.
+func (bp *bypasser) prefetchAll(addrs []string) []target {
+	targets := make([]target, len(addrs))
+	for i, addr := range addrs {
+		if addr != "" {
+			targets[i] = newTarget(addr)
+			bp.prefetch(targets[i])
+		}
+	}
+	return targets
+}
@@ -0,0 +1,122 @@
+package bypass
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestBypassBitset(t *testing.T) {
+	bp := NewBypasserPatterns(false, "10.0.0.0/8", "*.example.com", "192.168.1.1").(*bypasser)
+
+	var addrs []string
+	for i := 0; i < 200; i++ {
+		switch i % 5 {
+		case 0:
+			addrs = append(addrs, fmt.Sprintf("10.0.0.%d", i))
+		case 1:
+			addrs = append(addrs, fmt.Sprintf("www%d.example.com:443", i))
+		case 2:
+			addrs = append(addrs, fmt.Sprintf("172.16.0.%d", i))
+		case 3:
+			addrs = append(addrs, "")
+		default:
+			addrs = append(addrs, fmt.Sprintf("www%d.example.org", i))
+		}
+	}
+
+	for _, reversed := range []bool{false, true} {
+		bp.reversed = reversed
+		bits := bp.BypassBitset(addrs)
+		if len(bits) != 4 {
+			t.Fatalf("expected 4 words, got %d", len(bits))
+		}
+		for i, addr := range addrs {
+			if set := bits[i/64]&(1<<uint(i%64)) != 0; set != bp.Bypass(addr) {
+				t.Errorf("bit %d (%q) should be %v, reversed %v", i, addr, !set, reversed)
+			}
+		}
+	}
+
+	if bits := bp.BypassBitset(nil); len(bits) != 0 {
+		t.Errorf("expected an empty bitset, got %v", bits)
+	}
+}
+
+func TestBypassAll(t *testing.T) {
+	bp := NewBypasser(false).(*bypasser)
+	if err := bp.Reload(strings.NewReader("strip-port-ip false\n10.0.0.0/8\n*.example.com\n192.168.1.1:80\n!secure.example.com\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	addrs := []string{
+		"10.1.2.3", "10.1.2.3:80", "www.example.com:443", "secure.example.com",
+		"192.168.1.1:80", "192.168.1.1:443", "example.org", "",
+	}
+	for _, reversed := range []bool{false, true} {
+		bp.reversed = reversed
+		bypassed := bp.BypassAll(addrs)
+		if len(bypassed) != len(addrs) {
+			t.Fatalf("expected %d results, got %d", len(addrs), len(bypassed))
+		}
+		for i, addr := range addrs {
+			if bypassed[i] != bp.Bypass(addr) {
+				t.Errorf("%q should be %v, reversed %v", addr, !bypassed[i], reversed)
+			}
+		}
+	}
+
+	if bypassed := bp.BypassAll(nil); len(bypassed) != 0 {
+		t.Errorf("expected no results, got %v", bypassed)
+	}
+}
+
+func TestBypassAllStats(t *testing.T) {
+	bp := NewBypasserPatterns(false, "10.0.0.0/8", "*.example.com").(*bypasser)
+	bp.SetShadow(NewBypasserPatterns(false, "10.0.0.0/8"))
+
+	addrs := []string{"10.1.2.3", "www.example.com", "example.org", ""}
+	bp.BypassAll(addrs)
+	bp.BypassBitset(addrs)
+
+	stats := bp.Stats()
+	if stats.Calls != 6 || stats.Bypassed != 4 {
+		t.Errorf("expected 6 calls and 4 bypassed, got %d and %d", stats.Calls, stats.Bypassed)
+	}
+	if n := stats.Hits["domain *.example.com"]; n != 2 {
+		t.Errorf("expected 2 hits of *.example.com, got %d", n)
+	}
+	if n := bp.ShadowDivergences(); n != 2 {
+		t.Errorf("expected 2 divergences, got %d", n)
+	}
+}
+
+func benchmarkAddrs(n int) []string {
+	addrs := make([]string, n)
+	for i := range addrs {
+		if i%2 == 0 {
+			addrs[i] = fmt.Sprintf("www%d.example.com:443", i)
+		} else {
+			addrs[i] = fmt.Sprintf("10.0.%d.%d", i/256%256, i%256)
+		}
+	}
+	return addrs
+}
+
+func BenchmarkBypassAll(b *testing.B) {
+	bp := NewBypasserPatterns(false, "192.168.0.0/16", "*.example.org", "*.example.com").(*bypasser)
+	addrs := benchmarkAddrs(1000)
+
+	b.Run("Loop", func(b *testing.B) {
+		for n := 0; n < b.N; n++ {
+			for _, addr := range addrs {
+				bp.Bypass(addr)
+			}
+		}
+	})
+	b.Run("BypassAll", func(b *testing.B) {
+		for n := 0; n < b.N; n++ {
+			bp.BypassAll(addrs)
+		}
+	})
+}
@@ -2,12 +2,21 @@ package bypass
 
 import (
 	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"io"
+	"log/slog"
 	"net"
+	"os"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+	"unicode"
 
 	glob "github.com/gobwas/glob"
 )
@@ -17,6 +26,23 @@ type Bypasser interface {
 	Bypass(addr string) bool
 }
 
+// ReloadableBypasser is a Bypasser whose rules can be live reloaded.
+// The Bypassers created by this package implement it.
+type ReloadableBypasser interface {
+	Bypasser
+	// Reload parses the config from r, then replaces the rules and options of the bypass.
+	Reload(r io.Reader) error
+	// Period returns the period of the live reloading set by the config,
+	// zero if none and negative once stopped.
+	Period() time.Duration
+	// Stop stops the live reloading.
+	Stop()
+	// Stopped reports whether the live reloading is stopped.
+	Stopped() bool
+}
+
+var _ ReloadableBypasser = (*bypasser)(nil)
+
 // Matcher is a generic pattern matcher,
 // it gives the match result of the given pattern for specific v.
 type Matcher interface {
@@ -28,22 +54,32 @@ type Matcher interface {
 // The acutal Matcher depends on the pattern:
 // IP Matcher if pattern is a valid IP address.
 // CIDR Matcher if pattern is a valid CIDR address.
-// Domain Matcher if both of the above are not.
+// Range Matcher if pattern is a range of IP addresses.
+// Regex Matcher if pattern is wrapped in slashes.
+// Port Matcher if pattern is a host:port pattern, see Compiler.Compile.
+// Any Matcher if pattern is the bare wildcard '*', matching the IP addresses as well as the domains.
+// Label Count Matcher if pattern is a label count such as 'labels:1'.
+// Prefix or Suffix Matcher if pattern is a token not looking like a domain with a wildcard at one end, such as 'svc-*'.
+// Domain Matcher if none of the above.
+// The pattern is compiled by DefaultCompiler, nil is returned if it can not be compiled.
 func NewMatcher(pattern string) Matcher {
-	if pattern == "" {
+	m, err := NewMatcherStrict(pattern)
+	if err != nil {
 		return nil
 	}
-	if ip := net.ParseIP(pattern); ip != nil {
-		return IPMatcher(ip)
-	}
-	if _, inet, err := net.ParseCIDR(pattern); err == nil {
-		return CIDRMatcher(inet)
-	}
-	return DomainMatcher(pattern)
+	return m
+}
+
+// NewMatcherStrict creates a Matcher for the given pattern as NewMatcher does,
+// but reports an error for a pattern which can not be compiled, such as a malformed glob.
+func NewMatcherStrict(pattern string) (Matcher, error) {
+	return DefaultCompiler.Compile(pattern)
 }
 
 type ipMatcher struct {
-	ip net.IP
+	ip            net.IP
+	zone          string // the IPv6 zone, if any
+	zoneSensitive bool
 }
 
 // IPMatcher creates a Matcher for a specific IP address.
@@ -57,15 +93,23 @@ func (m *ipMatcher) Match(ip string) bool {
 	if m == nil {
 		return false
 	}
-	return m.ip.Equal(net.ParseIP(ip))
+	addr, zone := parseIPZone(ip)
+	if m.zoneSensitive && m.zone != "" && zone != m.zone {
+		return false
+	}
+	return m.ip.Equal(addr)
 }
 
 func (m *ipMatcher) String() string {
+	if m.zone != "" {
+		return "ip " + m.ip.String() + "%" + m.zone
+	}
 	return "ip " + m.ip.String()
 }
 
 type cidrMatcher struct {
 	ipNet *net.IPNet
+	raw   string // the pattern as given, such as '192.168.1.5/24' for the network 192.168.1.0/24
 }
 
 // CIDRMatcher creates a Matcher for a specific CIDR notation IP address.
@@ -79,31 +123,92 @@ func (m *cidrMatcher) Match(ip string) bool {
 	if m == nil || m.ipNet == nil {
 		return false
 	}
-	return m.ipNet.Contains(net.ParseIP(ip))
+	addr, _ := parseIPZone(ip)
+	return m.ipNet.Contains(addr)
 }
 
+// String returns the CIDR as given in the pattern if any, with its host bits,
+// otherwise the network.
 func (m *cidrMatcher) String() string {
+	if m.raw != "" {
+		return "cidr " + m.raw
+	}
 	return "cidr " + m.ipNet.String()
 }
 
 type domainMatcher struct {
+	raw     string // the pattern as given
 	pattern string
+	dot     bool   // the special wildcard form '.example.com', pattern without the leading '.'
+	expr    string // the glob expression compiled from pattern
 	glob    glob.Glob
+	fold    bool // case-insensitive
+	idn     bool // punycode normalization
+	unicode bool // Unicode normalization instead of punycode
+
+	separated bool // the wildcards do not match across the separators
 }
 
 // DomainMatcher creates a Matcher for a specific domain pattern,
 // the pattern can be a plain domain such as 'example.com',
 // a wildcard such as '*.exmaple.com' or a special wildcard '.example.com'.
+// The special wildcard matches the domain and its subdomains on label boundaries:
+// '.example.com' matches 'example.com' and 'www.example.com' but not 'wwwexample.com'.
+// Character classes are supported as well, including the negated form,
+// e.g. '[!0-9]*.example.com' matches any sub-domain not starting with a digit.
+//
+// A pattern matches the whole domain, anchor tokens can make it explicit:
+// '^www.example.com$' is the same as 'www.example.com'.
+// With a single anchor the other end is left open:
+// '^www.example.' matches any domain starting with 'www.example.',
+// 'example.com$' matches any domain ending with 'example.com'.
+// The special wildcard '.example.com' does not apply to anchored patterns.
+//
+// The trailing dot of a fully qualified domain name is ignored, in the domain
+// as well as in the pattern unless anchored: 'www.example.com.' matches 'www.example.com' and vice versa.
+//
+// A malformed pattern, such as '[unterminated', gives a Matcher matching nothing,
+// see NewMatcherStrict to get the error instead.
 func DomainMatcher(pattern string) Matcher {
-	p := pattern
-	if strings.HasPrefix(pattern, ".") {
-		p = pattern[1:] // trim the prefix '.'
-		pattern = "*" + p
+	m, err := (&Compiler{}).compileDomain(pattern)
+	if err != nil {
+		return &domainMatcher{
+			raw:     pattern,
+			pattern: pattern,
+		}
+	}
+	return m
+}
+
+// DomainMatcherIDN creates a Matcher for a domain pattern as DomainMatcher does,
+// normalizing both the pattern and the matched domains to their ASCII (punycode) form,
+// so that a Unicode pattern such as '例え.テスト' matches the punycode 'xn--r8jz45g.xn--zckzah'
+// and vice versa. See Compiler.IDN for the details.
+func DomainMatcherIDN(pattern string) Matcher {
+	m, err := (&Compiler{IDN: true}).compileDomain(pattern)
+	if err != nil {
+		return &domainMatcher{
+			raw:     pattern,
+			pattern: pattern,
+		}
 	}
-	return &domainMatcher{
-		pattern: p,
-		glob:    glob.MustCompile(pattern),
+	return m
+}
+
+// DomainMatcherSep creates a Matcher for a domain pattern as DomainMatcher does,
+// with sep as the glob separator: a wildcard does not match across it.
+// With '.' as the separator, '*.example.com' matches 'www.example.com' but not 'a.b.example.com',
+// which '**.example.com' still matches. See Compiler.Separators for the details,
+// and DefaultCompiler to make it the default of NewMatcher and Reload.
+func DomainMatcherSep(pattern string, sep rune) Matcher {
+	m, err := (&Compiler{Separators: []rune{sep}}).compileDomain(pattern)
+	if err != nil {
+		return &domainMatcher{
+			raw:     pattern,
+			pattern: pattern,
+		}
 	}
+	return m
 }
 
 func (m *domainMatcher) Match(domain string) bool {
@@ -111,36 +216,89 @@ func (m *domainMatcher) Match(domain string) bool {
 		return false
 	}
 
+	domain = trimTrailingDot(m.normalize(domain))
 	if domain == m.pattern {
 		return true
 	}
 	return m.glob.Match(domain)
 }
 
+// String returns the pattern of the matcher, with the leading '.' of the special wildcard
+// so that 'example.com' and '.example.com' are told apart, see Equal.
 func (m *domainMatcher) String() string {
+	if m.dot {
+		return "domain ." + m.pattern
+	}
 	return "domain " + m.pattern
 }
 
 type bypasser struct {
 	reversed bool
 	matchers []Matcher
+	hits     []uint64      // the hit counters of the matchers
 	period   time.Duration // the period for live reloading
 	stopped  chan struct{}
+	compiler *Compiler // compiles the rules on reload, DefaultCompiler if nil
+	cache    *matchCache
+	results  *resultCache
 	mux      sync.RWMutex
+
+	negations int // the number of negated matchers, see NegateMatcher
+	denials   int // the number of deny matchers, see DenyMatcher
+	index     *ruleIndex
+
+	// the polarity of the domain rules, if splitReverse is true
+	domainsReversed bool
+	splitReverse    bool
+	// an empty reversed bypass bypasses all the addresses, see SetReverseEmpty
+	reverseEmpty bool
+	// the most specific matching rule decides, see SetSpecificityOrder
+	specificityOrder bool
+	// the port is kept in the addresses matched by the rules of these kinds
+	keepPortIP     bool
+	keepPortDomain bool
+
+	fingerprint string       // the fingerprint of the rules loaded by Reload
+	ruleErrs    []*RuleError // the errors of the rules loaded by Reload
+	sections    []string     // the section headers of the config loaded by Reload, see Sections
+	ruleOpts    ruleOptions  // the rule options of the config loaded by Reload
+
+	transform func(line string) string // preprocesses the config lines on reload
+	slogger   *slog.Logger
+	name      string // see SetName
+
+	shadow      Bypasser      // evaluated along with Bypass, see SetShadow
+	divergences atomic.Uint64 // the decisions of the shadow differing from the bypass
+
+	calls         atomic.Uint64 // the addresses decided by BypassMatch and the batch calls, see Stats
+	bypassedCalls atomic.Uint64 // the addresses of them bypassed
+
+	onReload []func(old, new []Matcher) // called when a reload changes the rules, see OnReload
+
+	reorderStop chan struct{} // stops the adaptive ordering, see SetAdaptiveOrder
+	sweepStop   chan struct{} // stops the pruning of the expired rules, see SetExpirySweep
+	expiry      time.Time     // the earliest expiry of the rules, see ExpiringMatcher
+	resultTTL   time.Duration // the TTL of the cached decisions, the shortest lookup TTL of the rules
+	reloaded    chan struct{} // closed on the next reload, see reloadPeriod
+
+	lookups []lookupMatcher // the rules doing lookups, see prefetch
 }
 
 // NewBypasser creates and initializes a new Bypasser using Matchers as its match rules.
 // The rules will be reversed if the reversed is true.
+// The returned Bypasser is a ReloadableBypasser.
 func NewBypasser(reversed bool, matchers ...Matcher) Bypasser {
-	return &bypasser{
-		matchers: matchers,
+	bp := &bypasser{
 		reversed: reversed,
 		stopped:  make(chan struct{}),
 	}
+	bp.setMatchers(matchers)
+	return bp
 }
 
 // NewBypasserPatterns creates and initializes a new Bypasser using match patterns as its match rules.
 // The rules will be reversed if the reverse is true.
+// The returned Bypasser is a ReloadableBypasser.
 func NewBypasserPatterns(reversed bool, patterns ...string) Bypasser {
 	var matchers []Matcher
 	for _, pattern := range patterns {
@@ -148,87 +306,875 @@ func NewBypasserPatterns(reversed bool, patterns ...string) Bypasser {
 			matchers = append(matchers, m)
 		}
 	}
+	if DefaultCompiler.Dedup {
+		matchers = dedupMatchers(matchers)
+	}
 	bp := NewBypasser(reversed, matchers...)
 	return bp
 }
 
+// NewAllowlist creates a Bypasser bypassing only the addresses matched by the patterns,
+// it is NewBypasserPatterns(false, patterns...).
+func NewAllowlist(patterns ...string) Bypasser {
+	return NewBypasserPatterns(false, patterns...)
+}
+
+// NewBlocklist creates a Bypasser bypassing all the addresses but the ones matched by the patterns,
+// it is NewBypasserPatterns(true, patterns...).
+func NewBlocklist(patterns ...string) Bypasser {
+	return NewBypasserPatterns(true, patterns...)
+}
+
 // Bypass reports whether the address addr should be bypassed.
 func (bp *bypasser) Bypass(addr string) bool {
+	bypassed, _ := bp.BypassMatch(addr)
+	return bypassed
+}
+
+// BypassMatch reports whether the address addr should be bypassed, as Bypass does,
+// along with the matcher of the rule matching addr.
+// The matcher is nil if no rule matches, which in reversed mode is when addr is bypassed.
+func (bp *bypasser) BypassMatch(addr string) (bool, Matcher) {
+	return bp.bypassMatch(addr, true)
+}
+
+// bypassMatch is BypassMatch, evaluating the shadow of the bypass if observe is true.
+func (bp *bypasser) bypassMatch(addr string, observe bool) (bool, Matcher) {
 	if bp == nil || addr == "" {
-		return false
+		return false, nil
 	}
+	return bp.bypassTarget(newTarget(addr), observe)
+}
 
-	// try to strip the port
-	if host, port, _ := net.SplitHostPort(addr); host != "" && port != "" {
-		if p, _ := strconv.Atoi(port); p > 0 { // port is valid
-			addr = host
+// bypassTarget decides on the target t, with the result cache if the protocol of t is unknown,
+// records the decision, see record, and evaluates the shadow of the bypass if observe is true.
+func (bp *bypasser) bypassTarget(t target, observe bool) (bool, Matcher) {
+	bp.prefetch(t)
+
+	bp.mux.RLock()
+	var bypassed bool
+	var matcher Matcher
+	results := bp.results
+	if results != nil && (bp.hasExpired() || t.proto != "") {
+		// the decisions cached before the expiry of a rule are out of date until it is pruned,
+		// and the cache is keyed by the address only
+		results = nil
+	}
+	if r, ok := results.get(t.addr); ok {
+		bypassed, matcher = r.bypassed, r.matcher
+	} else {
+		var i int
+		bypassed, i = bp.decide(t)
+		if i >= 0 {
+			matcher = bp.matchers[i]
 		}
+		results.put(t.addr, bypassed, matcher, bp.resultTTL)
+	}
+	var shadow Bypasser
+	if observe {
+		shadow = bp.shadow
 	}
+	bp.mux.RUnlock()
 
-	bp.mux.RLock()
-	defer bp.mux.RUnlock()
+	bp.record(shadow, t, bypassed)
+	return bypassed, matcher
+}
+
+// record counts the decision bypassed on the target t in the statistics of the bypass
+// and evaluates t against the shadow if not nil, see observeShadow.
+// The caller must not hold bp.mux.
+func (bp *bypasser) record(shadow Bypasser, t target, bypassed bool) {
+	bp.calls.Add(1)
+	if bypassed {
+		bp.bypassedCalls.Add(1)
+	}
+
+	if shadow != nil {
+		bp.observeShadow(shadow, t, bypassed)
+	}
+}
+
+// target is an address to decide on.
+type target struct {
+	addr  string // the address, with its port if any
+	host  string // the address without its port
+	port  int    // zero if unknown
+	proto string // empty if unknown
+}
+
+func newTarget(addr string) target {
+	host, port := splitHostPort(addr)
+	return target{
+		addr: addr,
+		host: trimTrailingDot(host),
+		port: port,
+	}
+}
+
+// decide reports whether the target t should be bypassed.
+// When the domain rules have their own polarity (the 'reverse-domains' option),
+// an IP address is decided by the IP rules and the 'reverse' option,
+// any other host by the domain rules and the 'reverse-domains' option.
+// The rules of other kinds apply to both.
+// A negated rule (see NegateMatcher) takes precedence over the other rules:
+// an address it matches is not bypassed, whatever the polarity.
+// A deny rule (see DenyMatcher) takes precedence over the negated rules:
+// an address it matches is bypassed, whatever the polarity.
+// It returns the index of the matching rule as well, -1 if none.
+// The caller must hold bp.mux.
+func (bp *bypasser) decide(t target) (bool, int) {
+	kind, reversed := kindAny, bp.reversed
+	if bp.splitReverse {
+		kind = hostKind(t.host)
+		if kind == kindDomain {
+			reversed = bp.domainsReversed
+		}
+	}
 
 	if len(bp.matchers) == 0 {
+		return reversed && bp.reverseEmpty, -1
+	}
+
+	var i int
+	if bp.specificityOrder {
+		i = bp.matchSpecific(t, kind)
+	} else {
+		i = bp.match(t, kind)
+	}
+	if i >= 0 {
+		atomic.AddUint64(&bp.hits[i], 1)
+	}
+
+	matched := i >= 0
+	bypassed := !reversed && matched ||
+		reversed && !matched
+	if matched {
+		switch matcherRank(bp.matchers[i]) {
+		case rankNegated:
+			bypassed = false
+		case rankDenied:
+			bypassed = true
+		}
+	}
+
+	bp.logDecision(t, bypassed, i)
+	return bypassed, i
+}
+
+// WouldFlip reports whether the address addr is matched by any rule.
+// A matched address is bypassed in normal mode and is not bypassed in reversed mode,
+// it is intended for tools visualizing the effect of the reversed flag on the rules.
+func (bp *bypasser) WouldFlip(addr string) bool {
+	if bp == nil || addr == "" {
 		return false
 	}
+	t := newTarget(addr)
+	bp.prefetch(t)
+
+	bp.mux.RLock()
+	defer bp.mux.RUnlock()
+
+	kind := kindAny
+	if bp.splitReverse {
+		kind = hostKind(t.host)
+	}
+	i := bp.match(t, kind)
+	return i >= 0 && matcherRank(bp.matchers[i]) == rankPlain
+}
 
-	var matched bool
-	for _, matcher := range bp.matchers {
+// match returns the index of the first matcher of the given kind matching the target t
+// among the ones of the highest rank (the deny rules, then the negated rules, then the others), or -1 if none matches.
+// The matchers are given the host without the port,
+// unless the port stripping is disabled for their kind (the 'strip-port-ip' and 'strip-port-domain' options).
+// The caller must hold bp.mux.
+func (bp *bypasser) match(t target, kind int) int {
+	matched, matchedRank, top := -1, -1, bp.maxRank()
+
+	// the indexed rules are looked up at once, only the other ones are scanned
+	indexed := bp.index != nil && !bp.keepPortIP && !bp.keepPortDomain
+	hit, n := -1, len(bp.matchers)
+	if indexed {
+		hit, n = bp.index.lookup(t.host, kind), len(bp.index.scan)
+	}
+
+	for j := 0; j < n; j++ {
+		i := j
+		if indexed {
+			i = bp.index.scan[j]
+		}
+		matcher := bp.matchers[i]
 		if matcher == nil {
 			continue
 		}
-		if matcher.Match(addr) {
-			matched = true
-			break
+		// once a rule matches, only the rules of higher ranks can change the decision
+		rank := matcherRank(matcher)
+		if rank <= matchedRank {
+			continue
+		}
+		// the indexed rule comes first
+		if rank == rankPlain && hit >= 0 && hit < i {
+			if top == rankPlain {
+				return hit
+			}
+			matched, matchedRank = hit, rankPlain
+			continue
 		}
+		if bp.matchRule(matcher, t, kind) {
+			if rank == top {
+				return i
+			}
+			matched, matchedRank = i, rank
+		}
+	}
+	if hit >= 0 && matchedRank < rankPlain {
+		return hit
 	}
-	return !bp.reversed && matched ||
-		bp.reversed && !matched
+	return matched
+}
+
+// matchRule reports whether the rule of the matcher applies to the addresses of the given kind and matches t,
+// with its port if the rule matches the host:port form.
+// The caller must hold bp.mux.
+func (bp *bypasser) matchRule(matcher Matcher, t target, kind int) bool {
+	k := matcherKind(matcher)
+	if kind != kindAny && k != kindAny && k != kind {
+		return false
+	}
+	host := t.host
+	if k == kindIP && bp.keepPortIP || k == kindDomain && bp.keepPortDomain || matchesAddr(matcher) {
+		host = t.addr
+	}
+	return matchQualifiers(matcher, t.port, t.proto) && bp.matchHost(matcher, host)
+}
+
+// the kinds of rules, by the kind of host they apply to.
+const (
+	kindAny = iota
+	kindIP
+	kindDomain
+)
+
+func matcherKind(matcher Matcher) int {
+	switch m := matcher.(type) {
+	case *ipMatcher, *cidrMatcher, *rangeMatcher, *resolveMatcher, *ptrMatcher:
+		return kindIP
+	case *domainMatcher, *domainGroupMatcher, *suffixTrieMatcher, *prefixMatcher, *suffixMatcher, *labelCountMatcher:
+		return kindDomain
+	case *qualifiedMatcher:
+		return matcherKind(m.Matcher)
+	case *expiringMatcher:
+		return matcherKind(m.Matcher)
+	case *portMatcher:
+		return matcherKind(m.Matcher)
+	case *negatedMatcher:
+		return matcherKind(m.Matcher)
+	case *deniedMatcher:
+		return matcherKind(m.Matcher)
+	default:
+		return kindAny
+	}
+}
+
+func hostKind(host string) int {
+	if ip, _ := parseIPZone(host); ip != nil {
+		return kindIP
+	}
+	return kindDomain
+}
+
+// ParseIP parses s as an IP address as the IP rules do, with an optional IPv6 zone, such as 'fe80::1%eth0',
+// in its canonical form: '192.168.001.001' is '192.168.1.1' and '2001:DB8::1' is '2001:db8::1'.
+// An IPv4 address is returned in its 4-byte form, the IP is nil if s is not a valid IP address.
+// It lets the Matchers of other packages parse the addresses as the ones of this package do.
+func ParseIP(s string) (ip net.IP, zone string) {
+	return parseIPZone(s)
+}
+
+// parseIPZone parses s as an IP address with an optional IPv6 zone, such as 'fe80::1%eth0'.
+// The returned IP is nil if s is not a valid IP address,
+// an IPv4 address is returned in its 4-byte form, whether it is given in IPv4-mapped IPv6 form or not.
+func parseIPZone(s string) (net.IP, string) {
+	var zone string
+	if n := strings.LastIndexByte(s, '%'); n > 0 {
+		s, zone = s[:n], s[n+1:]
+		if zone == "" {
+			return nil, ""
+		}
+	}
+	ip := parseIP(s)
+	if ip == nil || zone != "" && ip.To4() != nil {
+		return nil, ""
+	}
+	// an IPv4 address and its IPv4-mapped IPv6 form, such as '::ffff:192.168.1.1', are the same host
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	return ip, zone
+}
+
+// parseIP parses s as an IP address after canonicalizing it, so that its equivalent textual forms are the same address:
+// the hex digits of an IPv6 address are lowercased and the leading zeros of each octet of an IPv4 address are removed,
+// e.g. '192.168.001.001' is '192.168.1.1', the zero-padded octets are decimal, not octal.
+// It returns nil if s is not a valid IP address.
+func parseIP(s string) net.IP {
+	return net.ParseIP(canonicalIP(s))
+}
+
+// parseCIDR is net.ParseCIDR with the IP address of s canonicalized as by parseIP, such as '010.0.0.0/8'.
+func parseCIDR(s string) (net.IP, *net.IPNet, error) {
+	if ip, bits, ok := strings.Cut(s, "/"); ok {
+		s = canonicalIP(ip) + "/" + bits
+	}
+	return net.ParseCIDR(s)
+}
+
+// canonicalIP returns the canonical textual form of the IP address s, see parseIP,
+// s is returned as is if it is not an IP address.
+func canonicalIP(s string) string {
+	if strings.Contains(s, ":") {
+		// an IPv4-mapped IPv6 address, such as '::FFFF:192.168.001.001'
+		if i := strings.LastIndexByte(s, ':'); strings.Contains(s[i+1:], ".") {
+			return strings.ToLower(s[:i+1]) + canonicalIPv4(s[i+1:])
+		}
+		return strings.ToLower(s)
+	}
+	return canonicalIPv4(s)
+}
+
+// canonicalIPv4 removes the leading zeros of each octet of the IPv4 address s, such as '010.000.000.001'.
+// s is returned as is if it is not four dot-separated groups of one to three digits.
+func canonicalIPv4(s string) string {
+	octets := strings.Split(s, ".")
+	if len(octets) != net.IPv4len {
+		return s
+	}
+	padded := false
+	for i, octet := range octets {
+		if octet == "" || len(octet) > 3 || !isDigits(octet) {
+			return s
+		}
+		if len(octet) > 1 && octet[0] == '0' {
+			octets[i] = strings.TrimLeft(octet, "0")
+			if octets[i] == "" {
+				octets[i] = "0"
+			}
+			padded = true
+		}
+	}
+	if !padded {
+		return s
+	}
+	return strings.Join(octets, ".")
+}
+
+// splitHostPort splits the address addr into host and port if it has a valid port,
+// either numeric or a service name such as 'https',
+// otherwise the whole address is returned as the host with a zero port.
+// The brackets of an IPv6 address, such as '[::1]:80' or '[::1]', are removed in any case.
+func splitHostPort(addr string) (string, int) {
+	// a bracketed IPv6 address without port, such as '[::1]'
+	if strings.HasPrefix(addr, "[") && strings.HasSuffix(addr, "]") {
+		if host := addr[1 : len(addr)-1]; strings.Contains(host, ":") {
+			return host, 0
+		}
+	}
+	if host, port, _ := net.SplitHostPort(addr); host != "" && port != "" {
+		if p := parsePort(port); p > 0 { // port is valid
+			return host, p
+		}
+		// the brackets delimit the IPv6 host, whatever the port
+		if strings.HasPrefix(addr, "[") {
+			return host, 0
+		}
+	}
+	return addr, 0
+}
+
+// RuleError is the error of a pattern of the config which can not be compiled.
+type RuleError struct {
+	File    string // the included file of the pattern, empty for the config itself
+	Line    int    // the line number in the config
+	Pattern string
+	Err     error
+
+	index int // the index of the pattern in the config
+}
+
+func (e *RuleError) Error() string {
+	if e.File != "" {
+		return fmt.Sprintf("bypass: %s:%d: %s: %v", e.File, e.Line, e.Pattern, e.Err)
+	}
+	return fmt.Sprintf("bypass: line %d: %s: %v", e.Line, e.Pattern, e.Err)
+}
+
+func (e *RuleError) Unwrap() error {
+	return e.Err
 }
 
 // Reload parses config from r, then live reloads the bypass.
+// If the rules are the same as the ones loaded by the previous reload, only the options are updated,
+// the rules and their hit counters are left intact.
+// A pattern with a leading '!' is a negated rule, see NegateMatcher.
+// A pattern with the 'allow' or 'deny' prefix is an allow or deny rule, see AllowMatcher and DenyMatcher.
+// An 'include <path>' line parses the rules and options of the file at path in its place,
+// a relative path is resolved against the directory of the including file.
+// The ${VAR} and $VAR references in a line are expanded from the environment,
+// any other '$', such as an end anchor, is left as is.
+// The patterns which can not be compiled are skipped, the valid rules are loaded anyway
+// and the errors of the invalid ones are joined into the returned error, one RuleError per pattern.
+//
+// A reload is all-or-nothing otherwise: an error reading the config, such as a line too long,
+// or a panic parsing it, such as one of the line transform, rejects the whole config,
+// the rules and the options of the bypass are then left intact.
 func (bp *bypasser) Reload(r io.Reader) error {
-	var matchers []Matcher
-	var period time.Duration
-	var reversed bool
+	return bp.ReloadAll(r)
+}
+
+// ReloadAll parses the configs from readers as a single config, the rules are concatenated in order
+// and an option set by a later config overrides the earlier ones, then live reloads the bypass as Reload does.
+// An error reading a config or an included file rejects the whole config.
+func (bp *bypasser) ReloadAll(readers ...io.Reader) error {
+	return bp.reload(false, readers...)
+}
+
+// ReloadStrict parses config from r, then live reloads the bypass as Reload does,
+// also rejecting the patterns meant as an IP address, a CIDR or a range of IP addresses which are not valid ones,
+// such as '10.0.0.0/33' or '300.1.1.1', which Reload takes for domain patterns.
+// The rejected patterns and the ones which can not be compiled are skipped, the valid rules are loaded anyway,
+// and they are returned as warnings, one per pattern with its line. The returned error rejects the whole config.
+func (bp *bypasser) ReloadStrict(r io.Reader) (warnings []string, err error) {
+	var errs []error
+	if err := bp.reload(true, r); err != nil {
+		errs = []error{err}
+		if joined, ok := err.(interface{ Unwrap() []error }); ok {
+			errs = joined.Unwrap()
+		}
+	}
+	for _, err := range errs {
+		var re *RuleError
+		if !errors.As(err, &re) {
+			return warnings, err
+		}
+		warnings = append(warnings, re.Error())
+	}
+	return warnings, nil
+}
+
+// reload parses the configs from readers as a single config, then live reloads the bypass,
+// a strict reload rejects the malformed IP patterns as well.
+func (bp *bypasser) reload(strict bool, readers ...io.Reader) error {
+	if bp.Stopped() {
+		return nil
+	}
+
+	bp.mux.RLock()
+	transform := bp.transform
+	compiler := bp.compiler
+	bp.mux.RUnlock()
+	if compiler == nil {
+		compiler = DefaultCompiler
+	}
 
-	if r == nil || bp.Stopped() {
+	cfg := &reloadConfig{
+		stripPortIP:     true,
+		stripPortDomain: true,
+		transform:       transform,
+		visited:         make(map[string]bool),
+	}
+	cfg.opts.strict = strict
+	cfg.opts.dedup = compiler.Dedup
+	var parsed bool
+	err := catchPanic(func() error {
+		for _, r := range readers {
+			if r == nil {
+				continue
+			}
+			if err := bp.parseConfig(cfg, r, "", 0); err != nil {
+				return err
+			}
+			parsed = true
+		}
 		return nil
+	})
+	if err != nil {
+		return err
 	}
+	if !parsed {
+		return nil
+	}
+
+	// the default option takes precedence over the reverse option
+	reversed := cfg.reversed
+	switch cfg.decision {
+	case "bypass":
+		reversed = true
+	case "proxy":
+		reversed = false
+	case "":
+	default:
+		bp.logf("invalid default %q, expected bypass or proxy", cfg.decision)
+	}
+
+	fingerprint := rulesFingerprint(cfg.patterns, cfg.opts)
 
+	bp.mux.RLock()
+	unchanged := bp.fingerprint == fingerprint
+	bp.mux.RUnlock()
+
+	for {
+		var matchers []Matcher
+		var ruleErrs []*RuleError
+		if !unchanged {
+			err := catchPanic(func() (err error) {
+				matchers, ruleErrs, err = bp.compileRules(cfg.patterns, cfg.opts)
+				return err
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		bp.mux.Lock()
+		// the rules have been changed concurrently
+		if unchanged && bp.fingerprint != fingerprint {
+			bp.mux.Unlock()
+			unchanged = false
+			continue
+		}
+
+		var old []Matcher
+		if !unchanged {
+			old = bp.matchers
+			bp.setMatchers(matchers)
+			bp.fingerprint = fingerprint
+			bp.ruleErrs = ruleErrs
+		}
+		onReload := bp.onReload
+		// the errors of unchanged rules are reported at their current lines
+		var errs []error
+		for _, e := range bp.ruleErrs {
+			e := *e
+			e.File = cfg.files[e.index]
+			e.Line = cfg.lines[e.index]
+			errs = append(errs, &e)
+		}
+		bp.sections = cfg.sections
+		bp.ruleOpts = cfg.opts
+		bp.period = cfg.period
+		bp.reversed = reversed
+		bp.domainsReversed = cfg.domainsReversed
+		bp.splitReverse = cfg.splitReverse
+		bp.keepPortIP = !cfg.stripPortIP
+		bp.keepPortDomain = !cfg.stripPortDomain
+		bp.resetResults()
+		if bp.reloaded != nil {
+			close(bp.reloaded)
+			bp.reloaded = nil
+		}
+		bp.mux.Unlock()
+
+		if !unchanged && len(onReload) > 0 && !sameRules(old, matchers) {
+			for _, fn := range onReload {
+				fn(old, matchers)
+			}
+		}
+		return errors.Join(errs...)
+	}
+}
+
+// catchPanic calls fn, turning a panic into an error,
+// so that a reload rejects the config rather than leaving the bypass half-reloaded or crashing the reload loop.
+func catchPanic(fn func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("bypass: reload: %v", r)
+		}
+	}()
+	return fn()
+}
+
+// reloadConfig is the config parsed by ReloadAll.
+type reloadConfig struct {
+	patterns []string
+	lines    []int    // the line numbers of the patterns
+	files    []string // the included files of the patterns
+	sections []string
+
+	period          time.Duration
+	reversed        bool
+	decision        string
+	domainsReversed bool
+	splitReverse    bool
+	stripPortIP     bool
+	stripPortDomain bool
+	opts            ruleOptions
+
+	transform func(string) string
+	visited   map[string]bool // the files being included
+}
+
+// parseConfig parses the config from r into cfg,
+// file is the path of the included file read by r, empty for a top-level config.
+func (bp *bypasser) parseConfig(cfg *reloadConfig, r io.Reader, file string, depth int) error {
 	scanner := bufio.NewScanner(r)
-	for scanner.Scan() {
+	for n := 1; scanner.Scan(); n++ {
 		line := scanner.Text()
-		ss := splitLine(line)
+		if cfg.transform != nil {
+			if line = cfg.transform(line); line == "" {
+				continue
+			}
+		}
+		ss, quoted := splitFields(expandEnv(line))
 		if len(ss) == 0 {
 			continue
 		}
-		switch ss[0] {
+		// a quoted first token is a pattern, even if it is a keyword or looks like a section header
+		keyword := ss[0]
+		if quoted {
+			keyword = ""
+		} else if section, ok := sectionHeader(ss); ok {
+			if !slices.Contains(cfg.sections, section) {
+				cfg.sections = append(cfg.sections, section)
+			}
+			continue
+		}
+		switch keyword {
 		case "reload": // reload option
 			if len(ss) > 1 {
-				period, _ = time.ParseDuration(ss[1])
+				cfg.period, _ = time.ParseDuration(ss[1])
 			}
 		case "reverse": // reverse option
 			if len(ss) > 1 {
-				reversed, _ = strconv.ParseBool(ss[1])
+				cfg.reversed, _ = strconv.ParseBool(ss[1])
+			}
+		case "default": // the decision when no rule matches
+			if len(ss) > 1 {
+				cfg.decision = ss[1]
+			}
+		case "reverse-domains": // reverse option of the domain rules
+			if len(ss) > 1 {
+				cfg.domainsReversed, _ = strconv.ParseBool(ss[1])
+				cfg.splitReverse = true
+			}
+		case "strip-port-ip": // port stripping for the IP rules
+			if len(ss) > 1 {
+				cfg.stripPortIP, _ = strconv.ParseBool(ss[1])
+			}
+		case "strip-port-domain": // port stripping for the domain rules
+			if len(ss) > 1 {
+				cfg.stripPortDomain, _ = strconv.ParseBool(ss[1])
+			}
+		case "combine-domains": // combine all domain matchers into one glob
+			if len(ss) > 1 {
+				cfg.opts.combine, _ = strconv.ParseBool(ss[1])
+			}
+		case "index-suffixes": // index the '.suffix' domain rules in a trie
+			if len(ss) > 1 {
+				cfg.opts.indexSuffixes, _ = strconv.ParseBool(ss[1])
+			}
+		case "dedup": // drop the duplicate rules
+			if len(ss) > 1 {
+				cfg.opts.dedup, _ = strconv.ParseBool(ss[1])
+			}
+		case "validate-hostnames": // validate domain rules as RFC-1123 hostnames
+			if len(ss) > 1 {
+				cfg.opts.validate = ss[1]
+			}
+		case "allow", "deny": // the prefixes of the allow and deny rules
+			if len(ss) > 1 {
+				cfg.patterns = append(cfg.patterns, ss[0]+" "+ss[1])
+				cfg.lines = append(cfg.lines, n)
+				cfg.files = append(cfg.files, file)
+			}
+		case "include": // parse the rules of another file
+			if len(ss) > 1 {
+				if err := bp.include(cfg, ss[1], file, depth); err != nil {
+					return err
+				}
 			}
 		default:
-			matchers = append(matchers, NewMatcher(ss[0]))
+			cfg.patterns = append(cfg.patterns, ss[0])
+			cfg.lines = append(cfg.lines, n)
+			cfg.files = append(cfg.files, file)
 		}
 	}
 
-	if err := scanner.Err(); err != nil {
+	if err := scanner.Err(); err != nil && file != "" {
+		return fmt.Errorf("bypass: include %s: %w", file, err)
+	} else if err != nil {
 		return err
 	}
+	return nil
+}
 
+// ruleOptions are the options of Reload changing how the rules are compiled.
+type ruleOptions struct {
+	combine       bool   // combine-domains
+	indexSuffixes bool   // index-suffixes
+	validate      string // validate-hostnames
+	strict        bool   // see ReloadStrict
+	dedup         bool   // dedup
+}
+
+// compileRules compiles the patterns into the matchers of the bypass.
+// The patterns which can not be compiled are skipped and reported as RuleErrors,
+// the returned error rejects the whole config.
+func (bp *bypasser) compileRules(patterns []string, opts ruleOptions) ([]Matcher, []*RuleError, error) {
+	var matchers []Matcher
+	var errs []*RuleError
+	for i, pattern := range patterns {
+		m, err := bp.compile(pattern)
+		if err == nil && opts.strict {
+			err = checkIPPattern(m)
+		}
+		if err != nil {
+			errs = append(errs, &RuleError{
+				Pattern: pattern,
+				Err:     err,
+				index:   i,
+			})
+			continue
+		}
+		// no phantom rule, as NewBypasserPatterns skips the nil matchers
+		if m == nil {
+			continue
+		}
+		matchers = append(matchers, m)
+	}
+
+	if opts.dedup {
+		matchers = dedupMatchers(matchers)
+	}
+	if err := checkHostnames(matchers, opts.validate, bp.logf); err != nil {
+		return nil, nil, err
+	}
+
+	if opts.indexSuffixes {
+		matchers = indexSuffixMatchers(matchers)
+	}
+	if opts.combine {
+		matchers = combineDomainMatchers(matchers)
+	}
+	return matchers, errs, nil
+}
+
+// SetLineTransform sets the function applied by Reload to each raw config line before parsing it,
+// to adapt legacy or vendor-specific formats. Returning an empty string drops the line.
+// A nil fn disables the transformation.
+func (bp *bypasser) SetLineTransform(fn func(line string) string) {
 	bp.mux.Lock()
 	defer bp.mux.Unlock()
 
-	bp.matchers = matchers
-	bp.period = period
-	bp.reversed = reversed
+	bp.transform = fn
+}
 
-	return nil
+// OnReload registers fn to be called after a reload replacing the rules of the bypass with different ones,
+// with the rules before and after the reload. The reloads loading the same rules, by their String()
+// and whatever their order, do not call fn, nor do AddMatcher and RemoveMatcher.
+// fn is called outside the lock of the bypass, on the goroutine of the reload,
+// it may call the bypass but must not wait for another goroutine doing so while it runs a reload.
+func (bp *bypasser) OnReload(fn func(old, new []Matcher)) {
+	if fn == nil {
+		return
+	}
+
+	bp.mux.Lock()
+	defer bp.mux.Unlock()
+
+	bp.onReload = append(bp.onReload, fn)
+}
+
+// rulesFingerprint returns a fingerprint of the rules loaded from the patterns.
+func rulesFingerprint(patterns []string, opts ruleOptions) string {
+	h := sha256.New()
+	// all the options, so that a reload changing any of them recompiles the rules
+	fmt.Fprintf(h, "%+v\n", opts)
+	for _, pattern := range patterns {
+		io.WriteString(h, pattern)
+		io.WriteString(h, "\n")
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (bp *bypasser) compile(pattern string) (Matcher, error) {
+	if strings.HasPrefix(pattern, "!") {
+		m, err := bp.compile(pattern[1:])
+		if err != nil {
+			return nil, err
+		}
+		return NegateMatcher(m), nil
+	}
+	if p, ok := strings.CutPrefix(pattern, "allow "); ok {
+		m, err := bp.compile(p)
+		if err != nil {
+			return nil, err
+		}
+		return AllowMatcher(m), nil
+	}
+	if p, ok := strings.CutPrefix(pattern, "deny "); ok {
+		m, err := bp.compile(p)
+		if err != nil {
+			return nil, err
+		}
+		return DenyMatcher(m), nil
+	}
+	if bp.compiler != nil {
+		return bp.compiler.Compile(pattern)
+	}
+	return DefaultCompiler.Compile(pattern)
+}
+
+// Matchers returns a copy of the rules of the bypass, in their evaluation order.
+func (bp *bypasser) Matchers() []Matcher {
+	bp.mux.RLock()
+	defer bp.mux.RUnlock()
+
+	return append([]Matcher(nil), bp.matchers...)
+}
+
+// Reversed reports whether the rules of the bypass are reversed.
+func (bp *bypasser) Reversed() bool {
+	bp.mux.RLock()
+	defer bp.mux.RUnlock()
+
+	return bp.reversed
+}
+
+// String returns the reverse flag, the reload period and the rules of the bypass,
+// such as 'bypass(reversed=false, period=30s, rules=[ip 192.168.1.1, domain *.example.com])'.
+func (bp *bypasser) String() string {
+	bp.mux.RLock()
+	defer bp.mux.RUnlock()
+
+	rules := make([]string, 0, len(bp.matchers))
+	for _, m := range bp.matchers {
+		if m != nil {
+			rules = append(rules, m.String())
+		}
+	}
+	return fmt.Sprintf("bypass(reversed=%v, period=%v, rules=[%s])", bp.reversed, bp.period, strings.Join(rules, ", "))
+}
+
+// Sections returns the names of the '[section]' headers of the config loaded by Reload, in order of appearance.
+// The sections group the rules of large configs, they do not change how the rules are matched.
+func (bp *bypasser) Sections() []string {
+	bp.mux.RLock()
+	defer bp.mux.RUnlock()
+
+	return slices.Clone(bp.sections)
+}
+
+// SetReverseEmpty sets whether a reversed bypass without rules bypasses all the addresses,
+// as the reverse of matching nothing, rather than none of them.
+// It is disabled by default, so that an empty rule set, such as a config whose rules all failed to compile
+// or a list not loaded yet, does not silently turn a reversed bypass into bypassing all the traffic.
+// The option is kept on reload.
+func (bp *bypasser) SetReverseEmpty(enabled bool) {
+	bp.mux.Lock()
+	defer bp.mux.Unlock()
+
+	bp.reverseEmpty = enabled
+	bp.resetResults()
 }
 
 // Period returns the reload period.
@@ -252,6 +1198,12 @@ func (bp *bypasser) Stop() {
 	}
 }
 
+// Done returns a channel closed once the bypass is stopped,
+// so that the live reloaders can wait for Stop along with their own events.
+func (bp *bypasser) Done() <-chan struct{} {
+	return bp.stopped
+}
+
 // Stopped checks whether the reloader is stopped.
 func (bp *bypasser) Stopped() bool {
 	select {
@@ -262,22 +1214,125 @@ func (bp *bypasser) Stopped() bool {
 	}
 }
 
+// expandEnv replaces the ${VAR} and $VAR references in line by the values of the environment variables,
+// an undefined variable is replaced by an empty string.
+// A variable name is a letter or '_' followed by letters, digits and '_',
+// any other '$' is left as is, such as the end anchor of '^www.example.com$' or the one of the regex '/^a(b|c)$?/'.
+func expandEnv(line string) string {
+	if !strings.Contains(line, "$") {
+		return line
+	}
+
+	var sb strings.Builder
+	for i := 0; i < len(line); i++ {
+		if line[i] != '$' {
+			sb.WriteByte(line[i])
+			continue
+		}
+		rest := line[i+1:]
+		if name, ok := strings.CutPrefix(rest, "{"); ok {
+			if j := strings.IndexByte(name, '}'); j >= 0 && envNameLen(name[:j]) == j && j > 0 {
+				sb.WriteString(os.Getenv(name[:j]))
+				i += j + 2
+				continue
+			}
+		} else if n := envNameLen(rest); n > 0 {
+			sb.WriteString(os.Getenv(rest[:n]))
+			i += n
+			continue
+		}
+		sb.WriteByte('$')
+	}
+	return sb.String()
+}
+
+// envNameLen returns the length of the environment variable name at the start of s, zero if none.
+func envNameLen(s string) int {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || i > 0 && '0' <= c && c <= '9' {
+			continue
+		}
+		return i
+	}
+	return len(s)
+}
+
 // splitLine splits a line text by white space, mainly used by config parser.
+// The comment starting with '#', on its own line or after a pattern, is stripped.
+// A double-quoted token, such as '"my value"', is a single field without its quotes,
+// even if it contains white space or a '#'. An unterminated quote runs to the end of the line.
 func splitLine(line string) []string {
+	ss, _ := splitFields(line)
+	return ss
+}
+
+// splitFields splits a line text as splitLine does,
+// and reports whether the first field is quoted, to tell a quoted pattern from a keyword.
+func splitFields(line string) (ss []string, quoted bool) {
 	if line == "" {
-		return nil
-	}
-	if n := strings.IndexByte(line, '#'); n >= 0 {
-		line = line[:n]
+		return nil, false
 	}
-	line = strings.Replace(line, "\t", " ", -1)
-	line = strings.TrimSpace(line)
 
-	var ss []string
-	for _, s := range strings.Split(line, " ") {
-		if s = strings.TrimSpace(s); s != "" {
-			ss = append(ss, s)
+	var b strings.Builder
+	flush := func() {
+		if b.Len() > 0 {
+			ss = append(ss, b.String())
+			b.Reset()
 		}
 	}
-	return ss
+	inQuotes := false
+	for _, r := range line {
+		switch {
+		case r == '"':
+			inQuotes = !inQuotes
+			if len(ss) == 0 {
+				quoted = true
+			}
+		case inQuotes:
+			b.WriteRune(r)
+		case r == '#':
+			flush()
+			return ss, quoted && len(ss) > 0
+		case unicode.IsSpace(r):
+			flush()
+		default:
+			b.WriteRune(r)
+		}
+	}
+	flush()
+	return ss, quoted && len(ss) > 0
+}
+
+// configKeywords are the first tokens of the config lines which are not patterns, see parseConfig.
+var configKeywords = []string{
+	"reload", "reverse", "default", "reverse-domains", "strip-port-ip", "strip-port-domain",
+	"combine-domains", "index-suffixes", "dedup", "validate-hostnames", "allow", "deny", "include",
+}
+
+// quoteToken quotes the token s for a config line if it would not be read back as a single pattern:
+// if it contains white space or a '#', or if it is a keyword or a section header.
+func quoteToken(s string) string {
+	if strings.ContainsFunc(s, unicode.IsSpace) || strings.ContainsRune(s, '#') ||
+		slices.Contains(configKeywords, s) {
+		return `"` + s + `"`
+	}
+	if _, ok := sectionHeader([]string{s}); ok {
+		return `"` + s + `"`
+	}
+	return s
+}
+
+// sectionHeader returns the name of the section of the '[section]' header line split into ss.
+// A bracketed IP address, such as '[::1]', or a glob class, such as '[ab]*.example.com', is a pattern.
+func sectionHeader(ss []string) (string, bool) {
+	line := strings.Join(ss, " ")
+	if len(line) < 3 || line[0] != '[' || line[len(line)-1] != ']' {
+		return "", false
+	}
+	name := strings.TrimSpace(line[1 : len(line)-1])
+	if name == "" || strings.Contains(name, ":") || hasGlobMeta(name) || net.ParseIP(name) != nil {
+		return "", false
+	}
+	return name, true
 }
@@ -1,8 +1,12 @@
 package bypass
 
 import (
+	"errors"
 	"fmt"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 )
 
 var bypassContainTests = []struct {
@@ -11,7 +15,7 @@ var bypassContainTests = []struct {
 	addr     string
 	bypassed bool
 }{
-	// empty pattern
+	// empty pattern, an empty bypass bypasses nothing even if reversed, see SetReverseEmpty
 	{[]string{""}, false, "", false},
 	{[]string{""}, false, "192.168.1.1", false},
 	{[]string{""}, true, "", false},
@@ -25,6 +29,62 @@ var bypassContainTests = []struct {
 	{[]string{"0.0.0.0"}, false, "0.0.0.0", true},
 	{[]string{"0.0.0.0"}, true, "0.0.0.0", false},
 
+	// fully qualified domain names
+	{[]string{"www.example.com"}, false, "www.example.com.", true},
+	{[]string{"www.example.com"}, false, "www.example.com.:443", true},
+	{[]string{"www.example.com."}, false, "www.example.com", true},
+	{[]string{"www.example.com."}, false, "www.example.com.", true},
+	{[]string{"example.com"}, false, "example.com.", true},
+	{[]string{"*.example.com"}, false, "www.example.com.", true},
+	{[]string{"*.example.com"}, false, "example.com.", false},
+	{[]string{"*.example.com."}, false, "www.example.com", true},
+	{[]string{".example.com"}, false, "example.com.", true},
+	{[]string{".example.com"}, false, "www.example.com.", true},
+	{[]string{".example.com."}, false, "www.example.com", true},
+	{[]string{"^www.example.com$"}, false, "www.example.com.", true},
+	{[]string{"^www.example."}, false, "www.example.com", true},
+	{[]string{"^www.example."}, false, "www.examples.com", false},
+
+	// IPv4-mapped IPv6 addresses
+	{[]string{"192.168.1.1"}, false, "::ffff:192.168.1.1", true},
+	{[]string{"192.168.1.1"}, false, "[::ffff:192.168.1.1]:80", true},
+	{[]string{"192.168.1.1"}, false, "::ffff:192.168.1.2", false},
+	{[]string{"::ffff:192.168.1.1"}, false, "192.168.1.1", true},
+	{[]string{"192.168.1.0/24"}, false, "::ffff:192.168.1.1", true},
+	{[]string{"192.168.1.0/24"}, false, "::ffff:192.168.2.1", false},
+	{[]string{"::ffff:192.168.1.0/120"}, false, "192.168.1.1", true},
+	{[]string{"::ffff:192.168.1.0/120"}, false, "192.168.2.1", false},
+	{[]string{"192.168.1.10-192.168.1.50"}, false, "::ffff:192.168.1.20", true},
+
+	// IPv6 authority
+	{[]string{"::1"}, false, "::1", true},
+	{[]string{"::1"}, false, "[::1]", true},
+	{[]string{"::1"}, false, "[::1]:80", true},
+	{[]string{"::1"}, false, "[::1]:http", true},
+	{[]string{"::1"}, false, "[::1]:nosuchservice", true},
+	{[]string{"::1"}, true, "[::1]:80", false},
+	{[]string{"::1"}, false, "[::2]:80", false},
+	{[]string{"::/127"}, false, "[::1]", true},
+	{[]string{"fe80::1%eth0"}, false, "[fe80::1%eth0]:22", true},
+	{[]string{"fe80::1%eth0"}, false, "[fe80::1%eth0]", true},
+	{[]string{"2001:db8::1"}, false, "2001:db8::1", true},
+	{[]string{"2001:db8::1"}, false, "[2001:db8::1]:443", true},
+
+	// IPv6 zone
+	{[]string{"fe80::1"}, false, "fe80::1%eth0", true},
+	{[]string{"fe80::1"}, false, "[fe80::1%eth0]:22", true},
+	{[]string{"fe80::1%eth0"}, false, "fe80::1", true},
+	{[]string{"fe80::1%eth0"}, false, "fe80::1%eth0", true},
+	{[]string{"fe80::1%eth0"}, false, "fe80::1%eth1", true},
+	{[]string{"fe80::1%eth0"}, false, "fe80::2%eth0", false},
+	{[]string{"fe80::1%eth0"}, true, "fe80::2%eth0", true},
+	{[]string{"fe80::/10"}, false, "fe80::1%eth0", true},
+	{[]string{"fe80::/10"}, false, "[fe80::1%eth0]:22", true},
+	{[]string{"fe80::/10"}, false, "fe90::1%eth0", true},
+	{[]string{"fe80::/10"}, false, "fec0::1%eth0", false},
+	{[]string{"fe80::1"}, false, "fe80::1%", false},
+	{[]string{"192.168.1.1"}, false, "192.168.1.1%eth0", false},
+
 	// CIDR address
 	{[]string{"192.168.1.0/0"}, false, "1.2.3.4", true},
 	{[]string{"192.168.1.0/0"}, true, "1.2.3.4", false},
@@ -60,20 +120,38 @@ var bypassContainTests = []struct {
 	{[]string{"192.168.1.1"}, true, "192.168.1.1:80", false},
 	{[]string{"192.168.1.1:80"}, false, "192.168.1.1", false},
 	{[]string{"192.168.1.1:80"}, true, "192.168.1.1", true},
-	{[]string{"192.168.1.1:80"}, false, "192.168.1.1:80", false},
-	{[]string{"192.168.1.1:80"}, true, "192.168.1.1:80", true},
+	{[]string{"192.168.1.1:80"}, false, "192.168.1.1:80", true},
+	{[]string{"192.168.1.1:80"}, true, "192.168.1.1:80", false},
 	{[]string{"192.168.1.1:80"}, false, "192.168.1.1:8080", false},
 	{[]string{"192.168.1.1:80"}, true, "192.168.1.1:8080", true},
 
+	{[]string{"example.com"}, false, "example.com:http", true},
+	{[]string{"example.com"}, false, "example.com:https", true},
+	{[]string{"example.com"}, false, "example.com:nosuchservice", false},
+	{[]string{"192.168.1.1"}, false, "192.168.1.1:ssh", true},
 	{[]string{"example.com"}, false, "example.com:80", true},
 	{[]string{"example.com"}, true, "example.com:80", false},
 	{[]string{"example.com:80"}, false, "example.com", false},
 	{[]string{"example.com:80"}, true, "example.com", true},
-	{[]string{"example.com:80"}, false, "example.com:80", false},
-	{[]string{"example.com:80"}, true, "example.com:80", true},
+	{[]string{"example.com:80"}, false, "example.com:80", true},
+	{[]string{"example.com:80"}, true, "example.com:80", false},
 	{[]string{"example.com:80"}, false, "example.com:8080", false},
 	{[]string{"example.com:80"}, true, "example.com:8080", true},
 
+	// named and wildcard ports
+	{[]string{"example.com:http"}, false, "example.com:80", true},
+	{[]string{"example.com:http"}, false, "example.com:http", true},
+	{[]string{"example.com:https"}, false, "example.com:443", true},
+	{[]string{"example.com:https"}, false, "example.com:80", false},
+	{[]string{"*.example.com:443"}, false, "www.example.com:443", true},
+	{[]string{"*.example.com:443"}, false, "www.example.com", false},
+	{[]string{"192.168.0.0/16:22"}, false, "192.168.1.1:22", true},
+	{[]string{"192.168.0.0/16:22"}, false, "192.168.1.1:23", false},
+	{[]string{"[::1]:80"}, false, "[::1]:80", true},
+	{[]string{"[::1]:80"}, false, "[::1]:443", false},
+	{[]string{"[::1]:*"}, false, "[::1]:443", true},
+	{[]string{"[::1]:*"}, false, "::1", false},
+
 	// domain wildcard
 
 	{[]string{"*"}, false, "", false},
@@ -129,29 +207,81 @@ var bypassContainTests = []struct {
 	{[]string{"www.*example*.com"}, false, "www.abc.example.def.com", true},
 	{[]string{"www.*example*.com"}, false, "www.e-xample.com", false},
 
+	// negated character class
+	{[]string{"[!0-9]*.example.com"}, false, "www.example.com", true},
+	{[]string{"[!0-9]*.example.com"}, false, "a.example.com", true},
+	{[]string{"[!0-9]*.example.com"}, false, "abc.def.example.com", true},
+	{[]string{"[!0-9]*.example.com"}, false, "1www.example.com", false},
+	{[]string{"[!0-9]*.example.com"}, false, "9.example.com", false},
+	{[]string{"[!0-9]*.example.com"}, false, "example.com", false},
+	{[]string{"[!0-9]*.example.com"}, true, "1www.example.com", true},
+	{[]string{"www[!0-9].example.com"}, false, "wwwa.example.com", true},
+	{[]string{"www[!0-9].example.com"}, false, "www1.example.com", false},
+	{[]string{"www[!0-9].example.com"}, false, "www.example.com", false},
+	{[]string{"[!0-9]*.example.com:80"}, false, "www.example.com:80", true},
+	{[]string{"[!0-9]*.example.com:80"}, false, "1.example.com:80", false},
+
 	{[]string{"www.example.*"}, false, "www.example.com", true},
 	{[]string{"www.example.*"}, false, "www.example.io", true},
 	{[]string{"www.example.*"}, false, "www.example.com.cn", true},
 
+	// brace alternation
+	{[]string{"example.{com,net,org}"}, false, "example.com", true},
+	{[]string{"example.{com,net,org}"}, false, "example.net", true},
+	{[]string{"example.{com,net,org}"}, false, "example.org", true},
+	{[]string{"example.{com,net,org}"}, false, "example.io", false},
+	{[]string{"example.{com,net,org}"}, true, "example.io", true},
+	{[]string{"example.{com,net,org}"}, false, "example.com:443", true},
+	{[]string{"example.{com,net,org}"}, false, "example.io:443", false},
+	{[]string{"example.{com,net,org}"}, false, "www.example.com", false},
+	{[]string{"example.{com,net,org}"}, false, "example.com.cn", false},
+	{[]string{"*.example.{com,net,org}"}, false, "www.example.org", true},
+	{[]string{"*.example.{com,net,org}"}, false, "www.example.io", false},
+	{[]string{".example.{com,net,org}"}, false, "example.net", true},
+	{[]string{".example.{com,net,org}"}, false, "www.example.net", true},
+	{[]string{".example.{com,net,org}"}, false, "www.example.io", false},
+	{[]string{"{www,api}.example.com"}, false, "api.example.com", true},
+	{[]string{"{www,api}.example.com"}, false, "web.example.com", false},
+
 	{[]string{".example.com"}, false, "www.example.com", true},
 	{[]string{".example.com"}, false, "example.com", true},
 	{[]string{".example.com"}, false, "www.example.com.cn", false},
 
+	// anchors
+	{[]string{"^www.example.com$"}, false, "www.example.com", true},
+	{[]string{"^www.example.com$"}, false, "www.example.com:80", true},
+	{[]string{"^www.example.com$"}, false, "www.example.com.cn", false},
+	{[]string{"^www.example.com$"}, false, "abc.www.example.com", false},
+	{[]string{"^www.example.com$"}, true, "www.example.com", false},
+	{[]string{"^www.example."}, false, "www.example.com", true},
+	{[]string{"^www.example."}, false, "www.example.com.cn", true},
+	{[]string{"^www.example."}, false, "abc.www.example.com", false},
+	{[]string{"example.com$"}, false, "example.com", true},
+	{[]string{"example.com$"}, false, "www.example.com", true},
+	{[]string{"example.com$"}, false, "www.example.com.cn", false},
+	{[]string{"^*.example.com$"}, false, "www.example.com", true},
+	{[]string{"^*.example.com$"}, false, "example.com", false},
+	{[]string{"^.example.com$"}, false, "example.com", false},
+	{[]string{"^.example.com$"}, false, ".example.com", true},
+	{[]string{"^example"}, false, "example.com", true},
+	{[]string{"^example"}, false, "www.example.com", false},
+
 	{[]string{"example.com*"}, false, "example.com", true},
 	{[]string{"example.com:*"}, false, "example.com", false},
-	{[]string{"example.com:*"}, false, "example.com:80", false},
-	{[]string{"example.com:*"}, false, "example.com:8080", false},
+	{[]string{"example.com:*"}, false, "example.com:80", true},
+	{[]string{"example.com:*"}, false, "example.com:8080", true},
 	{[]string{"example.com:*"}, false, "example.com:http", true},
+	{[]string{"example.com:*"}, false, "example.com:nosuchservice", false},
 	{[]string{"example.com:*"}, false, "http://example.com:80", false},
 
 	{[]string{"*example.com*"}, false, "example.com:80", true},
-	{[]string{"*example.com:*"}, false, "example.com:80", false},
+	{[]string{"*example.com:*"}, false, "example.com:80", true},
 
 	{[]string{".example.com:*"}, false, "www.example.com", false},
 	{[]string{".example.com:*"}, false, "http://www.example.com", false},
-	{[]string{".example.com:*"}, false, "example.com:80", false},
-	{[]string{".example.com:*"}, false, "www.example.com:8080", false},
-	{[]string{".example.com:*"}, false, "http://www.example.com:80", true},
+	{[]string{".example.com:*"}, false, "example.com:80", true},
+	{[]string{".example.com:*"}, false, "www.example.com:8080", true},
+	{[]string{".example.com:*"}, false, "http://www.example.com:80", false},
 }
 
 func TestBypassContains(t *testing.T) {
@@ -165,3 +295,619 @@ func TestBypassContains(t *testing.T) {
 		})
 	}
 }
+
+func TestWouldFlip(t *testing.T) {
+	for i, tc := range []struct {
+		reversed bool
+		addr     string
+		flip     bool
+	}{
+		{false, "192.168.1.1", true},
+		{true, "192.168.1.1", true},
+		{false, "192.168.1.1:80", true},
+		{false, "www.example.com", true},
+		{true, "www.example.com", true},
+		{false, "192.168.2.1", false},
+		{true, "192.168.2.1", false},
+		{false, "example.org", false},
+		{false, "", false},
+	} {
+		bp := NewBypasserPatterns(tc.reversed, "192.168.1.0/24", "*.example.com").(*bypasser)
+		if bp.WouldFlip(tc.addr) != tc.flip {
+			t.Errorf("#%d test failed: %v, %s", i, tc.reversed, tc.addr)
+		}
+	}
+}
+
+func TestBypassMatch(t *testing.T) {
+	for i, tc := range []struct {
+		reversed bool
+		addr     string
+		bypassed bool
+		rule     string
+	}{
+		{false, "10.1.2.3:80", true, "cidr 10.0.0.0/8"},
+		{false, "www.example.com", true, "domain *.example.com"},
+		{false, "192.168.1.1", false, ""},
+		{true, "10.1.2.3:80", false, "cidr 10.0.0.0/8"},
+		{true, "www.example.com", false, "domain *.example.com"},
+		{true, "192.168.1.1", true, ""},
+		{true, "", false, ""},
+	} {
+		bp := NewBypasserPatterns(tc.reversed, "10.0.0.0/8", "*.example.com").(*bypasser)
+		bypassed, m := bp.BypassMatch(tc.addr)
+		if bypassed != tc.bypassed || bypassed != bp.Bypass(tc.addr) {
+			t.Errorf("#%d %s: expected bypassed %v, got %v", i, tc.addr, tc.bypassed, bypassed)
+		}
+		rule := ""
+		if m != nil {
+			rule = m.String()
+		}
+		if rule != tc.rule {
+			t.Errorf("#%d %s: expected the rule %q, got %q", i, tc.addr, tc.rule, rule)
+		}
+	}
+}
+
+func TestReverseDomains(t *testing.T) {
+	for i, tc := range []struct {
+		config   string
+		addr     string
+		bypassed bool
+	}{
+		// IP rules are a blacklist, domain rules a whitelist
+		{"reverse false\nreverse-domains true", "10.1.2.3", true},
+		{"reverse false\nreverse-domains true", "10.1.2.3:80", true},
+		{"reverse false\nreverse-domains true", "192.168.1.1", false},
+		{"reverse false\nreverse-domains true", "www.example.com", false},
+		{"reverse false\nreverse-domains true", "www.example.com:443", false},
+		{"reverse false\nreverse-domains true", "example.org", true},
+		{"reverse false\nreverse-domains true", "[::1]:80", false},
+
+		// and the other way around
+		{"reverse true\nreverse-domains false", "10.1.2.3", false},
+		{"reverse true\nreverse-domains false", "192.168.1.1", true},
+		{"reverse true\nreverse-domains false", "www.example.com", true},
+		{"reverse true\nreverse-domains false", "example.org", false},
+
+		// same polarity
+		{"reverse true\nreverse-domains true", "192.168.1.1", true},
+		{"reverse true\nreverse-domains true", "example.org", true},
+		{"reverse true\nreverse-domains true", "www.example.com", false},
+
+		// without reverse-domains, all the rules share the reverse option
+		{"reverse true", "example.org", true},
+		{"reverse true", "10.1.2.3", false},
+	} {
+		bp := NewBypasser(false).(*bypasser)
+		if err := bp.Reload(strings.NewReader(tc.config + "\n10.0.0.0/8\n*.example.com\n")); err != nil {
+			t.Fatal(err)
+		}
+		if bp.Bypass(tc.addr) != tc.bypassed {
+			t.Errorf("#%d test failed: %q, %s", i, tc.config, tc.addr)
+		}
+	}
+}
+
+func TestStripPort(t *testing.T) {
+	rules := "\n192.168.1.1\nexample.com:8080\n*.example.org\n"
+	for i, tc := range []struct {
+		config   string
+		addr     string
+		bypassed bool
+	}{
+		// by default the port is stripped for all the rules
+		{"", "192.168.1.1:80", true},
+		{"", "example.com:8080", true},
+		{"", "example.com:80", false},
+		{"", "www.example.org:443", true},
+
+		// domain rules match the address with its port
+		{"strip-port-domain false", "example.com:8080", true},
+		{"strip-port-domain false", "example.com:80", false},
+		{"strip-port-domain false", "example.com", false},
+		{"strip-port-domain false", "www.example.org", true},
+		{"strip-port-domain false", "www.example.org:443", false},
+		{"strip-port-domain false", "192.168.1.1:80", true},
+
+		// IP rules match the address with its port
+		{"strip-port-ip false", "192.168.1.1", true},
+		{"strip-port-ip false", "192.168.1.1:80", false},
+		{"strip-port-ip false", "www.example.org:443", true},
+
+		{"strip-port-ip true\nstrip-port-domain true", "192.168.1.1:80", true},
+
+		// host:port wildcards
+		{"strip-port-domain false\n*.example.net:80*", "www.example.net:8080", true},
+		{"strip-port-domain false\n*.example.net:80*", "www.example.net:443", false},
+		{"strip-port-domain false\ncombine-domains true\n*.example.net:80*", "www.example.net:8080", true},
+		{"strip-port-domain false\ncombine-domains true\n*.example.net:80*", "www.example.net:80:80", false},
+	} {
+		bp := NewBypasser(false).(*bypasser)
+		if err := bp.Reload(strings.NewReader(tc.config + rules)); err != nil {
+			t.Fatal(err)
+		}
+		if bp.Bypass(tc.addr) != tc.bypassed {
+			t.Errorf("#%d test failed: %q, %s", i, tc.config, tc.addr)
+		}
+	}
+}
+
+func TestAllowlistBlocklist(t *testing.T) {
+	patterns := []string{"192.168.0.0/16", "*.example.com"}
+	allow, block := NewAllowlist(patterns...), NewBlocklist(patterns...)
+	for addr, listed := range map[string]bool{
+		"192.168.1.1:80":      true,
+		"www.example.com:443": true,
+		"10.0.0.1":            false,
+		"example.org":         false,
+	} {
+		if allow.Bypass(addr) != listed {
+			t.Errorf("allowlist, %s: expected %v", addr, listed)
+		}
+		if block.Bypass(addr) != !listed {
+			t.Errorf("blocklist, %s: expected %v", addr, !listed)
+		}
+	}
+	if !block.(*bypasser).Reversed() || allow.(*bypasser).Reversed() {
+		t.Errorf("only the blocklist should be reversed")
+	}
+}
+
+func TestCanonicalIP(t *testing.T) {
+	bp := NewBypasserPatterns(false, "192.168.1.1", "10.0.0.0/8", "2001:db8::1", "2001:db8:1::/48", "172.16.0.1-172.16.0.9")
+	for addr, bypassed := range map[string]bool{
+		"192.168.001.001":          true,
+		"192.168.001.001:80":       true,
+		"::FFFF:192.168.001.001":   true,
+		"010.000.000.001":          true,
+		"011.000.000.001":          false, // decimal, not octal
+		"172.016.000.005":          true,
+		"2001:DB8::1":              true,
+		"[2001:Db8:0:0::1]:443":    true,
+		"2001:DB8:1:ABCD::1":       true,
+		"2001:DB8::2":              false,
+		"192.168.0001.1":           false,
+		"192.168.1.256":            false,
+		"0192.168.001.001.example": false,
+	} {
+		if bp.Bypass(addr) != bypassed {
+			t.Errorf("%s: expected %v", addr, bypassed)
+		}
+	}
+
+	for s, want := range map[string]string{
+		"192.168.001.001":   "192.168.1.1",
+		"::FFFF:10.000.0.1": "10.0.0.1",
+		"2001:DB8::1%eth0":  "2001:db8::1%eth0",
+		"192.168.001.001%x": "<nil>",
+		"www.example.com":   "<nil>",
+	} {
+		ip, zone := ParseIP(s)
+		if got := ip.String(); zone != "" {
+			if got += "%" + zone; got != want {
+				t.Errorf("ParseIP(%q): expected %s, got %s", s, want, got)
+			}
+		} else if got != want {
+			t.Errorf("ParseIP(%q): expected %s, got %s", s, want, got)
+		}
+	}
+
+	// the rules are canonicalized as well
+	bp = NewBypasserPatterns(false, "192.168.001.001", "010.0.0.0/8", "2001:DB8::1")
+	for _, addr := range []string{"192.168.1.1", "10.1.2.3", "2001:db8::1"} {
+		if !bp.Bypass(addr) {
+			t.Errorf("%s: expected to be bypassed", addr)
+		}
+	}
+}
+
+func TestSplitLine(t *testing.T) {
+	for line, expected := range map[string][]string{
+		"":                              nil,
+		"# comment":                     nil,
+		"reload 30s":                    {"reload", "30s"},
+		"\treload \t 30s  # every 30s":  {"reload", "30s"},
+		`reload "30s"`:                  {"reload", "30s"},
+		`"my value" *.example.com`:      {"my value", "*.example.com"},
+		`deny "a # b" c`:                {"deny", "a # b", "c"},
+		`"*.example.com"# comment`:      {"*.example.com"},
+		`pre"fix suf"fix`:               {"prefix suffix"},
+		`"" example.com`:                {"example.com"},
+		`"unterminated value`:           {"unterminated value"},
+		"  192.168.1.1\t\t10.0.0.0/8  ": {"192.168.1.1", "10.0.0.0/8"},
+	} {
+		if ss := splitLine(line); !slices.Equal(ss, expected) {
+			t.Errorf("%q: expected %q, got %q", line, expected, ss)
+		}
+	}
+
+	bp := NewBypasser(false).(*bypasser)
+	if err := bp.Reload(strings.NewReader("reload \"30s\"\n\"*.example.com\" # quoted\nexample.org\n")); err != nil {
+		t.Fatal(err)
+	}
+	if bp.Period() != 30*time.Second {
+		t.Errorf("expected a period of 30s, got %v", bp.Period())
+	}
+	if !bp.Bypass("www.example.com") || !bp.Bypass("example.org") || bp.Len() != 2 {
+		t.Errorf("the quoted and unquoted patterns should be loaded, got %v", bp)
+	}
+
+	// a quoted first token is a pattern, not a keyword or a section header
+	if err := bp.Reload(strings.NewReader("\"reload\" 30s\n\"include\" /etc/passwd\n\"[corp]\"\n")); err != nil {
+		t.Fatal(err)
+	}
+	if bp.Period() != 0 || len(bp.Sections()) != 0 || bp.Len() != 3 || !bp.Bypass("reload") || !bp.Bypass("include") {
+		t.Errorf("the quoted keywords should be loaded as patterns, got %v", bp)
+	}
+}
+
+func TestReloadDefault(t *testing.T) {
+	for i, tc := range []struct {
+		config   string
+		addr     string
+		bypassed bool
+	}{
+		{"default proxy", "www.example.com", true},
+		{"default proxy", "www.example.org", false},
+		{"default bypass", "www.example.com", false},
+		{"default bypass", "www.example.org", true},
+
+		// default takes precedence over reverse
+		{"reverse true\ndefault proxy", "www.example.org", false},
+		{"default proxy\nreverse true", "www.example.org", false},
+		{"reverse false\ndefault bypass", "www.example.org", true},
+		{"reverse true\ndefault bypass", "10.1.2.3", false},
+
+		// an invalid default is ignored
+		{"reverse true\ndefault nothing", "www.example.org", true},
+	} {
+		bp := NewBypasser(false).(*bypasser)
+		if err := bp.Reload(strings.NewReader(tc.config + "\n10.0.0.0/8\n*.example.com\n")); err != nil {
+			t.Fatal(err)
+		}
+		if bp.Bypass(tc.addr) != tc.bypassed {
+			t.Errorf("#%d test failed: %q, %s", i, tc.config, tc.addr)
+		}
+	}
+}
+
+func TestReloadRuleErrors(t *testing.T) {
+	bp := NewBypasser(false).(*bypasser)
+
+	config := "reverse false\n*.example.com\n[unterminated\n/api-(\\d+/\n192.168.1.1\n"
+	err := bp.Reload(strings.NewReader(config))
+	if err == nil {
+		t.Fatalf("expected an error for the broken lines")
+	}
+	var lines []int
+	for _, e := range err.(interface{ Unwrap() []error }).Unwrap() {
+		var re *RuleError
+		if !errors.As(e, &re) {
+			t.Fatalf("expected a RuleError, got %v", e)
+		}
+		lines = append(lines, re.Line)
+	}
+	if fmt.Sprint(lines) != "[3 4]" {
+		t.Errorf("expected errors at lines 3 and 4, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "line 3: [unterminated") {
+		t.Errorf("the error should report the pattern: %v", err)
+	}
+
+	if n := len(bp.matchers); n != 2 {
+		t.Errorf("the valid rules should be loaded, got %d matchers", n)
+	}
+	if !bp.Bypass("www.example.com") || !bp.Bypass("192.168.1.1") {
+		t.Errorf("the valid rules should be applied")
+	}
+
+	// the errors are reported again for unchanged rules, at their current lines
+	err = bp.Reload(strings.NewReader("# comment\n" + config))
+	if err == nil || !strings.Contains(err.Error(), "line 4: [unterminated") {
+		t.Errorf("expected the error at line 4, got %v", err)
+	}
+
+	if err := bp.Reload(strings.NewReader("*.example.com\n")); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestNewMatcherStrict(t *testing.T) {
+	for _, pattern := range []string{"[unterminated", "*.example.{com", "*.example.com}", "{a,{b}"} {
+		if _, err := NewMatcherStrict(pattern); err == nil {
+			t.Errorf("%s: expected an error for a malformed glob", pattern)
+		}
+	}
+	for _, pattern := range []string{`*.example.\{com`, "[{]*.example.com", "*.{com,net}"} {
+		if _, err := NewMatcherStrict(pattern); err != nil {
+			t.Errorf("%s: unexpected error: %v", pattern, err)
+		}
+	}
+	// the glob of the empty alternatives panics on the short domains
+	for _, pattern := range []string{"0{}", "0{,}", "*.{{},a}.com", ".{}"} {
+		if _, err := NewMatcherStrict(pattern); err == nil {
+			t.Errorf("%s: expected an error for the empty alternatives", pattern)
+		}
+	}
+	for _, pattern := range []string{"www{,-1}.example.com", "[{}]*.example.com", `0\{\}`} {
+		if _, err := NewMatcherStrict(pattern); err != nil {
+			t.Errorf("%s: unexpected error: %v", pattern, err)
+		}
+	}
+	if m := NewMatcher("0{}"); m != nil {
+		t.Errorf("the empty alternatives should not give a matcher, got %v", m)
+	}
+	if NewBypasserPatterns(false, "0{}").Bypass("0") {
+		t.Errorf("the empty alternatives should not match")
+	}
+	if m, err := NewMatcherStrict("*.example.com"); err != nil || !m.Match("www.example.com") {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	m := DomainMatcher("[unterminated")
+	if m == nil || m.Match("[unterminated") || m.Match("u") {
+		t.Errorf("a malformed domain pattern should match nothing")
+	}
+}
+
+func TestAccessors(t *testing.T) {
+	matchers := []Matcher{NewMatcher("10.0.0.0/8"), NewMatcher("*.example.com")}
+	bp := NewBypasser(true, matchers...).(*bypasser)
+
+	if !bp.Reversed() {
+		t.Errorf("the bypass should be reversed")
+	}
+	got := bp.Matchers()
+	if len(got) != 2 || got[0] != matchers[0] || got[1] != matchers[1] {
+		t.Errorf("expected the matchers %v, got %v", matchers, got)
+	}
+	got[0] = nil
+	if bp.matchers[0] == nil {
+		t.Errorf("the matchers should be a copy")
+	}
+
+	if err := bp.Reload(strings.NewReader("reverse false\n192.168.1.1\n")); err != nil {
+		t.Fatal(err)
+	}
+	if bp.Reversed() {
+		t.Errorf("the bypass should not be reversed after the reload")
+	}
+	if got := bp.Matchers(); len(got) != 1 || got[0].String() != "ip 192.168.1.1" {
+		t.Errorf("unexpected matchers after the reload: %v", got)
+	}
+}
+
+func TestBypasserString(t *testing.T) {
+	bp := NewBypasser(false).(*bypasser)
+	if s := bp.String(); s != "bypass(reversed=false, period=0s, rules=[])" {
+		t.Errorf("unexpected String %q", s)
+	}
+
+	if err := bp.Reload(strings.NewReader("reverse true\nreload 30s\n192.168.1.1\n10.0.0.0/8\n*.example.com\n!www.example.com\n")); err != nil {
+		t.Fatal(err)
+	}
+	expected := "bypass(reversed=true, period=30s, rules=[ip 192.168.1.1, cidr 10.0.0.0/8, domain *.example.com, !domain www.example.com])"
+	if s := fmt.Sprintf("%v", bp); s != expected {
+		t.Errorf("expected %q, got %q", expected, s)
+	}
+}
+
+func TestReloadLineTransform(t *testing.T) {
+	bp := NewBypasser(false).(*bypasser)
+
+	// legacy format: 'BYPASS <pattern>', 'REVERSE' and '--' comments.
+	bp.SetLineTransform(func(line string) string {
+		switch {
+		case strings.HasPrefix(line, "--"):
+			return ""
+		case line == "REVERSE":
+			return "reverse true"
+		default:
+			return strings.TrimPrefix(line, "BYPASS ")
+		}
+	})
+
+	config := "-- legacy config\nREVERSE\nBYPASS *.example.com\nBYPASS 192.168.0.0/16\n-- 10.0.0.0/8\n"
+	if err := bp.Reload(strings.NewReader(config)); err != nil {
+		t.Fatal(err)
+	}
+
+	if n := len(bp.matchers); n != 2 {
+		t.Errorf("expected 2 rules, got %d", n)
+	}
+	for i, tc := range []struct {
+		addr     string
+		bypassed bool
+	}{
+		{"www.example.com", false},
+		{"192.168.1.1", false},
+		{"10.0.0.1", true},
+		{"example.org", true},
+	} {
+		if bp.Bypass(tc.addr) != tc.bypassed {
+			t.Errorf("#%d test failed: %s", i, tc.addr)
+		}
+	}
+
+	bp.SetLineTransform(nil)
+	if err := bp.Reload(strings.NewReader(config)); err != nil {
+		t.Fatal(err)
+	}
+	if bp.Bypass("www.example.com") {
+		t.Errorf("legacy rules should not be understood without the transform")
+	}
+}
+
+func TestReloadEnv(t *testing.T) {
+	t.Setenv("BYPASS_ENV", "staging")
+	t.Setenv("BYPASS_PORT", "8443")
+
+	bp := NewBypasser(false).(*bypasser)
+	config := "api.${BYPASS_ENV}.example.com\n*.$BYPASS_ENV.example.org:$BYPASS_PORT\nweb${BYPASS_UNDEFINED}.example.net\n^www.example.com$\n" +
+		"/^a(b|c)$?/\n^example.com$\n/^api$$[0-9]$!$-${}.example$/\n"
+	if err := bp.Reload(strings.NewReader(config)); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"domain api.staging.example.com",
+		"domain *.staging.example.org port 8443",
+		"domain web.example.net",
+		"domain www.example.com",
+		"regex ^a(b|c)$?",
+		"domain example.com",
+		"regex ^api$$[0-9]$!$-${}.example$",
+	}
+	got := bp.Matchers()
+	if len(got) != len(want) {
+		t.Fatalf("expected %d rules, got %v", len(want), got)
+	}
+	for i, m := range got {
+		if m.String() != want[i] {
+			t.Errorf("#%d: expected %q, got %q", i, want[i], m.String())
+		}
+	}
+	if bp.Bypass("www.example.com.cn") {
+		t.Errorf("the end anchor '$' should be left as is")
+	}
+	if !bp.Bypass("ab") || bp.Bypass("xab") {
+		t.Errorf("the regex '$' should be left as is")
+	}
+}
+
+func TestReloadSections(t *testing.T) {
+	bp := NewBypasser(false).(*bypasser)
+
+	err := bp.Reload(strings.NewReader(`# the rules by team
+[ads]
+*.ads.example.com # legacy
+  # a comment-only line
+#
+[ private networks ]
+10.0.0.0/8	# RFC1918
+[ab]*.example.org
+[ads]
+example.com # legacy
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if sections := bp.Sections(); !slices.Equal(sections, []string{"ads", "private networks"}) {
+		t.Errorf("expected the sections ads and private networks, got %q", sections)
+	}
+	want := []string{
+		"domain *.ads.example.com",
+		"cidr 10.0.0.0/8",
+		"domain [ab]*.example.org",
+		"domain example.com",
+	}
+	got := bp.Matchers()
+	if len(got) != len(want) {
+		t.Fatalf("expected %d rules, got %v", len(want), got)
+	}
+	for i, m := range got {
+		if m.String() != want[i] {
+			t.Errorf("#%d: expected %q, got %q", i, want[i], m.String())
+		}
+	}
+	for _, addr := range []string{"example.com", "www.ads.example.com", "a.example.org", "10.1.1.1"} {
+		if !bp.Bypass(addr) {
+			t.Errorf("%s should match a rule", addr)
+		}
+	}
+
+	if err := bp.Reload(strings.NewReader("example.com\n")); err != nil {
+		t.Fatal(err)
+	}
+	if sections := bp.Sections(); len(sections) != 0 {
+		t.Errorf("the sections should be reset on reload, got %q", sections)
+	}
+}
+
+func TestReverseEmpty(t *testing.T) {
+	for _, tc := range []struct {
+		reversed     bool
+		reverseEmpty bool
+		bypassed     bool
+	}{
+		{false, false, false},
+		{true, false, false},
+		{false, true, false},
+		{true, true, true},
+	} {
+		bp := NewBypasserPatterns(tc.reversed, "").(*bypasser)
+		bp.SetReverseEmpty(tc.reverseEmpty)
+		for _, addr := range []string{"192.168.1.1", "example.com:443"} {
+			if bypassed := bp.Bypass(addr); bypassed != tc.bypassed {
+				t.Errorf("reversed %v, reverse empty %v, %s: expected %v, got %v",
+					tc.reversed, tc.reverseEmpty, addr, tc.bypassed, bypassed)
+			}
+		}
+		if bp.Bypass("") {
+			t.Errorf("an empty address should never be bypassed")
+		}
+	}
+
+	// the reverse-domains option applies to the domains of an empty bypass
+	bp := NewBypasser(false).(*bypasser)
+	bp.SetReverseEmpty(true)
+	if err := bp.Reload(strings.NewReader("reverse-domains true\n")); err != nil {
+		t.Fatal(err)
+	}
+	if bp.Bypass("192.168.1.1") || !bp.Bypass("example.com") {
+		t.Errorf("an empty bypass should follow the polarity of the kind of the address")
+	}
+
+	// the option only applies while the bypass has no rules
+	bp.AddMatcher(NewMatcher("*.example.com"))
+	if bp.Bypass("www.example.com") || !bp.Bypass("example.org") {
+		t.Errorf("a reversed bypass with rules should bypass the addresses matching no rule")
+	}
+}
+
+func TestReloadBlankLines(t *testing.T) {
+	t.Setenv("BYPASS_UNSET", "")
+
+	bp := NewBypasser(false).(*bypasser)
+	err := bp.Reload(strings.NewReader("reverse true\n   \n\t\n# comment\n  # indented comment\n$BYPASS_UNSET\n*.example.com\n\n!\n192.168.1.1\n\n"))
+	var re *RuleError
+	if !errors.As(err, &re) || re.Line != 9 || !errors.Is(err, ErrEmptyPattern) {
+		t.Errorf("expected an empty pattern error at line 9, got %v", err)
+	}
+
+	if bp.Len() != 2 || len(bp.Matchers()) != 2 {
+		t.Fatalf("expected 2 rules, got %v", bp.Matchers())
+	}
+	for _, m := range bp.Matchers() {
+		if m == nil {
+			t.Errorf("a blank line should not give a rule")
+		}
+	}
+	if !bp.Bypass("example.org") || bp.Bypass("www.example.com") {
+		t.Errorf("the blank lines should not change the reversed decisions")
+	}
+}
+
+// FuzzBypass checks that no pattern nor address makes a bypass panic.
+// The patterns of a rule set are separated by newlines.
+func FuzzBypass(f *testing.F) {
+	for _, tc := range bypassContainTests {
+		f.Add(strings.Join(tc.patterns, "\n"), tc.reversed, tc.addr)
+	}
+	f.Add("*.example.com\n[a-\n\x00", false, "www.example.com\x00:443")
+	f.Add("/(?i)^api\\./\n10.0.0.0-10.0.0.255", true, "[::ffff:10.0.0.1]:")
+	f.Add("tcp://*.example.com:80-90", false, "tcp://www.example.com:")
+	f.Add("*example.co{", false, "example.co")
+	f.Add("0{}", true, "0")
+
+	f.Fuzz(func(t *testing.T, patterns string, reversed bool, addr string) {
+		bp := NewBypasserPatterns(reversed, strings.Split(patterns, "\n")...)
+		bp.Bypass(addr)
+		for _, m := range bp.(*bypasser).matchers {
+			m.Match(addr)
+			_ = m.String()
+		}
+	})
+}
@@ -0,0 +1,141 @@
+package bypass
+
+import (
+	"container/list"
+	"reflect"
+	"sync"
+	"time"
+)
+
+type matchCacheKey struct {
+	m    Matcher
+	addr string
+}
+
+type matchCacheEntry struct {
+	key     matchCacheKey
+	matched bool
+	expires time.Time
+}
+
+// matchCache is a size-bounded LRU cache of Match results with a TTL, safe for concurrent use.
+type matchCache struct {
+	size    int
+	ttl     time.Duration
+	entries map[matchCacheKey]*list.Element
+	lru     *list.List // front is the most recently used
+	mux     sync.Mutex
+}
+
+func newMatchCache(size int, ttl time.Duration) *matchCache {
+	return &matchCache{
+		size:    size,
+		ttl:     ttl,
+		entries: make(map[matchCacheKey]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+func (c *matchCache) get(key matchCacheKey) (matched bool, ok bool) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		return false, false
+	}
+	entry := e.Value.(*matchCacheEntry)
+	if time.Now().After(entry.expires) {
+		c.lru.Remove(e)
+		delete(c.entries, key)
+		return false, false
+	}
+	c.lru.MoveToFront(e)
+	return entry.matched, true
+}
+
+func (c *matchCache) put(key matchCacheKey, matched bool) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	expires := time.Now().Add(c.ttl)
+	if e, ok := c.entries[key]; ok {
+		entry := e.Value.(*matchCacheEntry)
+		entry.matched, entry.expires = matched, expires
+		c.lru.MoveToFront(e)
+		return
+	}
+
+	c.entries[key] = c.lru.PushFront(&matchCacheEntry{
+		key:     key,
+		matched: matched,
+		expires: expires,
+	})
+	for c.lru.Len() > c.size {
+		e := c.lru.Back()
+		c.lru.Remove(e)
+		delete(c.entries, e.Value.(*matchCacheEntry).key)
+	}
+}
+
+// SetMatchCache enables a shared cache of the Match results of the expensive matchers
+// (e.g. the ones doing DNS or database lookups), holding up to size results for ttl.
+// The IP, CIDR and domain matchers are cheap and are never cached.
+// A non-positive size or ttl disables the cache.
+func (bp *bypasser) SetMatchCache(size int, ttl time.Duration) {
+	var cache *matchCache
+	if size > 0 && ttl > 0 {
+		cache = newMatchCache(size, ttl)
+	}
+
+	bp.mux.Lock()
+	defer bp.mux.Unlock()
+
+	bp.cache = cache
+}
+
+// matchHost reports whether the matcher m matches the host, through the match cache if m is expensive.
+// The caller must hold bp.mux.
+func (bp *bypasser) matchHost(m Matcher, host string) bool {
+	if bp.cache == nil || !isCacheable(m) {
+		return m.Match(host)
+	}
+
+	key := matchCacheKey{m: m, addr: host}
+	if matched, ok := bp.cache.get(key); ok {
+		return matched
+	}
+	matched := m.Match(host)
+	bp.cache.put(key, matched)
+	return matched
+}
+
+// isCacheable reports whether the Match results of the matcher m are worth caching.
+func isCacheable(matcher Matcher) bool {
+	switch m := matcher.(type) {
+	case *ipMatcher, *cidrMatcher, *domainMatcher, *domainGroupMatcher, *suffixTrieMatcher, *regexMatcher, *rangeMatcher,
+		*anyMatcher, *noneMatcher, *expiringMatcher, *prefixMatcher, *suffixMatcher,
+		*labelCountMatcher:
+		return false
+	case *qualifiedMatcher:
+		return isCacheable(m.Matcher)
+	case *portMatcher:
+		return isCacheable(m.Matcher)
+	case *negatedMatcher:
+		return isCacheable(m.Matcher)
+	case *deniedMatcher:
+		return isCacheable(m.Matcher)
+	case *schemeMatcher:
+		return isCacheable(m.Matcher)
+	case *compositeMatcher:
+		for _, matcher := range m.matchers {
+			if isCacheable(matcher) {
+				return true
+			}
+		}
+		return false
+	default:
+		// the matcher is used as a map key
+		return reflect.TypeOf(m).Comparable()
+	}
+}
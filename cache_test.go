@@ -0,0 +1,116 @@
+package bypass
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingMatcher is an expensive matcher counting its Match calls.
+type countingMatcher struct {
+	host  string
+	calls int32
+}
+
+func (m *countingMatcher) Match(host string) bool {
+	atomic.AddInt32(&m.calls, 1)
+	return host == m.host
+}
+
+func (m *countingMatcher) String() string {
+	return "counting " + m.host
+}
+
+func (m *countingMatcher) Calls() int {
+	return int(atomic.LoadInt32(&m.calls))
+}
+
+func TestMatchCache(t *testing.T) {
+	m := &countingMatcher{host: "example.com"}
+	bp := NewBypasser(false, m).(*bypasser)
+	bp.SetMatchCache(16, time.Minute)
+
+	for i := 0; i < 3; i++ {
+		if !bp.Bypass("example.com:443") {
+			t.Fatalf("example.com should be bypassed")
+		}
+		if bp.Bypass("example.org") {
+			t.Fatalf("example.org should not be bypassed")
+		}
+	}
+	if n := m.Calls(); n != 2 {
+		t.Errorf("expected 2 Match calls, got %d", n)
+	}
+
+	bp.SetMatchCache(0, 0)
+	bp.Bypass("example.com")
+	if n := m.Calls(); n != 3 {
+		t.Errorf("expected 3 Match calls with the cache disabled, got %d", n)
+	}
+}
+
+func TestMatchCacheTTL(t *testing.T) {
+	m := &countingMatcher{host: "example.com"}
+	bp := NewBypasser(false, m).(*bypasser)
+	bp.SetMatchCache(16, 10*time.Millisecond)
+
+	bp.Bypass("example.com")
+	bp.Bypass("example.com")
+	time.Sleep(20 * time.Millisecond)
+	bp.Bypass("example.com")
+	if n := m.Calls(); n != 2 {
+		t.Errorf("expected 2 Match calls, got %d", n)
+	}
+}
+
+func TestMatchCacheSize(t *testing.T) {
+	m := &countingMatcher{host: "example.com"}
+	bp := NewBypasser(false, m).(*bypasser)
+	bp.SetMatchCache(2, time.Minute)
+
+	bp.Bypass("a.example.com")
+	bp.Bypass("b.example.com")
+	bp.Bypass("c.example.com") // evicts a.example.com
+	bp.Bypass("c.example.com")
+	bp.Bypass("a.example.com")
+	if n := m.Calls(); n != 4 {
+		t.Errorf("expected 4 Match calls, got %d", n)
+	}
+}
+
+func TestMatchCacheCheapMatchers(t *testing.T) {
+	bp := NewBypasserPatterns(false, "192.168.1.1", "10.0.0.0/8", "*.example.com").(*bypasser)
+	bp.SetMatchCache(16, time.Minute)
+
+	for _, addr := range []string{"192.168.1.1", "10.1.1.1", "www.example.com"} {
+		if !bp.Bypass(addr) {
+			t.Errorf("%s should be bypassed", addr)
+		}
+	}
+	if n := len(bp.cache.entries); n != 0 {
+		t.Errorf("cheap matchers should not be cached, got %d entries", n)
+	}
+}
+
+func TestMatchCacheConcurrent(t *testing.T) {
+	m := &countingMatcher{host: "example.com"}
+	bp := NewBypasser(false, m).(*bypasser)
+	bp.SetMatchCache(4, time.Minute)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				for _, addr := range []string{"example.com", "a.example.com", "b.example.com", "c.example.com", "d.example.com"} {
+					if bp.Bypass(addr) != (addr == "example.com") {
+						t.Errorf("wrong result for %s", addr)
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,115 @@
+package bypass
+
+import (
+	"math/big"
+	"net"
+	"sort"
+)
+
+// ipRange is an inclusive range of IP addresses of the same family.
+type ipRange struct {
+	start, end *big.Int
+}
+
+// AggregateCIDRs returns the minimal set of CIDRs covering all the IP and CIDR rules of the bypass,
+// IPv4 networks first. Adjacent and overlapping networks are merged, e.g. two adjacent /25 make a /24.
+// The rules of the bypass are left untouched.
+func (bp *bypasser) AggregateCIDRs() []string {
+	var v4, v6 []ipRange
+
+	bp.mux.RLock()
+	for _, matcher := range bp.matchers {
+		var inet *net.IPNet
+		switch m := matcher.(type) {
+		case *ipMatcher:
+			if ip := m.ip.To4(); ip != nil {
+				inet = &net.IPNet{IP: ip, Mask: net.CIDRMask(32, 32)}
+			} else if m.ip != nil {
+				inet = &net.IPNet{IP: m.ip, Mask: net.CIDRMask(128, 128)}
+			}
+		case *cidrMatcher:
+			inet = m.ipNet
+		}
+		if inet == nil {
+			continue
+		}
+		if len(inet.IP) == net.IPv4len {
+			v4 = append(v4, netRange(inet))
+		} else {
+			v6 = append(v6, netRange(inet))
+		}
+	}
+	bp.mux.RUnlock()
+
+	var cidrs []string
+	for _, inet := range aggregateRanges(v4, 32) {
+		cidrs = append(cidrs, inet.String())
+	}
+	for _, inet := range aggregateRanges(v6, 128) {
+		cidrs = append(cidrs, inet.String())
+	}
+	return cidrs
+}
+
+func netRange(inet *net.IPNet) ipRange {
+	ones, bits := inet.Mask.Size()
+	start := new(big.Int).SetBytes(inet.IP.Mask(inet.Mask))
+	size := new(big.Int).Lsh(big.NewInt(1), uint(bits-ones))
+	end := new(big.Int).Add(start, size)
+	return ipRange{
+		start: start,
+		end:   end.Sub(end, big.NewInt(1)),
+	}
+}
+
+// aggregateRanges merges the ranges of IP addresses of bits length
+// and splits the result into the minimal list of networks.
+func aggregateRanges(ranges []ipRange, bits int) []*net.IPNet {
+	if len(ranges) == 0 {
+		return nil
+	}
+
+	sort.Slice(ranges, func(i, j int) bool {
+		return ranges[i].start.Cmp(ranges[j].start) < 0
+	})
+
+	one := big.NewInt(1)
+	merged := []ipRange{ranges[0]}
+	for _, r := range ranges[1:] {
+		last := &merged[len(merged)-1]
+		next := new(big.Int).Add(last.end, one)
+		if r.start.Cmp(next) <= 0 {
+			if r.end.Cmp(last.end) > 0 {
+				last.end = r.end
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+
+	var nets []*net.IPNet
+	for _, r := range merged {
+		start := new(big.Int).Set(r.start)
+		for start.Cmp(r.end) <= 0 {
+			// the largest block aligned on start and within the range.
+			size := bits
+			if n := int(start.TrailingZeroBits()); n < size && start.Sign() != 0 {
+				size = n
+			}
+			for ; size > 0; size-- {
+				last := new(big.Int).Lsh(one, uint(size))
+				last.Add(last, start).Sub(last, one)
+				if last.Cmp(r.end) <= 0 {
+					break
+				}
+			}
+
+			ip := make(net.IP, bits/8)
+			start.FillBytes(ip)
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits-size, bits)})
+
+			start.Add(start, new(big.Int).Lsh(one, uint(size)))
+		}
+	}
+	return nets
+}
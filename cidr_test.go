@@ -0,0 +1,82 @@
+package bypass
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+var aggregateCIDRsTests = []struct {
+	patterns []string
+	cidrs    []string
+}{
+	{nil, nil},
+	{[]string{"example.com"}, nil},
+	{[]string{"192.168.1.1"}, []string{"192.168.1.1/32"}},
+	{[]string{"192.168.1.0/24"}, []string{"192.168.1.0/24"}},
+
+	// adjacent
+	{[]string{"192.168.1.0/25", "192.168.1.128/25"}, []string{"192.168.1.0/24"}},
+	{[]string{"192.168.1.128/25", "192.168.1.0/25"}, []string{"192.168.1.0/24"}},
+	{[]string{"192.168.0.0/24", "192.168.1.0/24", "192.168.2.0/24", "192.168.3.0/24"}, []string{"192.168.0.0/22"}},
+	{[]string{"192.168.1.0", "192.168.1.1", "192.168.1.2", "192.168.1.3"}, []string{"192.168.1.0/30"}},
+	{[]string{"192.168.1.1", "192.168.1.2"}, []string{"192.168.1.1/32", "192.168.1.2/32"}},
+	{[]string{"192.168.1.0/24", "192.168.2.0/24"}, []string{"192.168.1.0/24", "192.168.2.0/24"}},
+	{[]string{"192.168.1.128/25", "192.168.2.0/25"}, []string{"192.168.1.128/25", "192.168.2.0/25"}},
+
+	// overlapping
+	{[]string{"10.0.0.0/8", "10.1.0.0/16", "10.1.2.3"}, []string{"10.0.0.0/8"}},
+	{[]string{"10.1.0.0/16", "10.0.0.0/8"}, []string{"10.0.0.0/8"}},
+	{[]string{"192.168.1.0/24", "192.168.1.0/24"}, []string{"192.168.1.0/24"}},
+	{[]string{"0.0.0.0/0", "192.168.1.0/24"}, []string{"0.0.0.0/0"}},
+
+	// unaligned
+	{[]string{"192.168.1.0/25", "192.168.1.128/26"}, []string{"192.168.1.0/25", "192.168.1.128/26"}},
+	{[]string{"192.168.1.1", "192.168.1.2/31"}, []string{"192.168.1.1/32", "192.168.1.2/31"}},
+
+	// IPv6 and mixed
+	{[]string{"2001:db8::/33", "2001:db8:8000::/33"}, []string{"2001:db8::/32"}},
+	{[]string{"2001:db8::1", "192.168.1.1", "*.example.com"}, []string{"192.168.1.1/32", "2001:db8::1/128"}},
+}
+
+func TestAggregateCIDRs(t *testing.T) {
+	for i, tc := range aggregateCIDRsTests {
+		tc := tc
+		t.Run(fmt.Sprintf("#%d", i), func(t *testing.T) {
+			bp := NewBypasserPatterns(false, tc.patterns...).(*bypasser)
+			if cidrs := bp.AggregateCIDRs(); !reflect.DeepEqual(cidrs, tc.cidrs) {
+				t.Errorf("#%d test failed: %v, got %v", i, tc.patterns, cidrs)
+			}
+			if n := len(bp.matchers); n != len(tc.patterns) {
+				t.Errorf("#%d the rules should not change", i)
+			}
+		})
+	}
+}
+
+func TestCIDRMatcherString(t *testing.T) {
+	for _, tc := range []struct {
+		pattern string
+		s       string
+	}{
+		{"192.168.1.5/24", "cidr 192.168.1.5/24"},
+		{"192.168.1.0/24", "cidr 192.168.1.0/24"},
+		{"2001:db8::1/32", "cidr 2001:db8::1/32"},
+	} {
+		m := NewMatcher(tc.pattern)
+		if s := m.String(); s != tc.s {
+			t.Errorf("expected %q, got %q", tc.s, s)
+		}
+		// the matching uses the network
+		if !m.Match(m.(*cidrMatcher).ipNet.IP.String()) {
+			t.Errorf("%s should match its network address", tc.pattern)
+		}
+	}
+
+	if !NewMatcher("192.168.1.5/24").Match("192.168.1.200") {
+		t.Errorf("the CIDR should match the whole network")
+	}
+	if s := NewBypasserPatterns(false, "192.168.1.5/24").(*bypasser).PrunedConfig(0); s != "reverse false\ndefault proxy\n192.168.1.5/24\n" {
+		t.Errorf("the config should keep the pattern, got %q", s)
+	}
+}
@@ -0,0 +1,68 @@
+package bypass
+
+import (
+	"strings"
+
+	glob "github.com/gobwas/glob"
+)
+
+// domainGroupMatcher matches a set of domain patterns with a single glob alternation.
+// It can not tell which of the patterns matched.
+type domainGroupMatcher struct {
+	exprs []string
+	raws  []string // the patterns the exprs are compiled from
+	glob  glob.Glob
+}
+
+func (m *domainGroupMatcher) Match(domain string) bool {
+	if m == nil || m.glob == nil {
+		return false
+	}
+	return m.glob.Match(domain)
+}
+
+func (m *domainGroupMatcher) String() string {
+	return "domain {" + strings.Join(m.exprs, ",") + "}"
+}
+
+// combineDomainMatchers replaces the domain matchers in matchers
+// with a single matcher compiled from the alternation of their patterns.
+// Patterns that can not be safely placed in an alternation (containing a top-level comma)
+// and host:port patterns, compiled with ':' as a separator, are kept as they are.
+// So are the matchers which do not match the raw domain against their glob, see combinable,
+// so that combining the rules does not change what they match.
+func combineDomainMatchers(matchers []Matcher) []Matcher {
+	var exprs, raws []string
+	var others []Matcher
+	for _, matcher := range matchers {
+		if m, ok := matcher.(*domainMatcher); ok && m.combinable() {
+			exprs = append(exprs, m.expr)
+			raws = append(raws, m.raw)
+			continue
+		}
+		others = append(others, matcher)
+	}
+	if len(exprs) < 2 {
+		return matchers
+	}
+
+	g, err := glob.Compile("{" + strings.Join(exprs, ",") + "}")
+	if err != nil {
+		return matchers
+	}
+	return append(others, &domainGroupMatcher{
+		exprs: exprs,
+		raws:  raws,
+		glob:  g,
+	})
+}
+
+// combinable reports whether the domain matcher can be part of the alternation of a domainGroupMatcher,
+// which matches the raw domain without separators: a matcher with separators, such as in the single-label mode,
+// or normalizing the domain, case-insensitive or IDN, is not.
+func (m *domainMatcher) combinable() bool {
+	if m.glob == nil || m.separated || m.fold || m.idn || m.unicode {
+		return false
+	}
+	return topLevelComma(m.expr) < 0 && !strings.Contains(m.expr, ":")
+}
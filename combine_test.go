@@ -0,0 +1,97 @@
+package bypass
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestCombineDomains(t *testing.T) {
+	patterns := []string{
+		"192.168.1.1",
+		"*.example.com",
+		".example.org",
+		"www.*.net",
+		"a,b.example.io",
+	}
+	config := "combine-domains true\n" + strings.Join(patterns, "\n")
+
+	bp := NewBypasser(false).(*bypasser)
+	if err := bp.Reload(strings.NewReader(config)); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(bp.matchers); n != 3 {
+		t.Errorf("expected 3 matchers after combining, got %d", n)
+	}
+
+	plain := NewBypasserPatterns(false, patterns...)
+	for i, addr := range []string{
+		"192.168.1.1",
+		"192.168.1.2",
+		"www.example.com",
+		"example.com",
+		"example.org",
+		"www.example.org",
+		"www.example.net",
+		"example.net",
+		"a,b.example.io",
+		"a.example.io",
+	} {
+		if bp.Bypass(addr) != plain.Bypass(addr) {
+			t.Errorf("#%d test failed: %s", i, addr)
+		}
+	}
+}
+
+func TestCombineDomainsNormalized(t *testing.T) {
+	for _, tc := range []struct {
+		compiler *Compiler
+		addr     string
+	}{
+		{&Compiler{StrictLabelWildcards: true}, "x.y.a.com"},
+		{&Compiler{StrictLabelWildcards: true}, "x.a.com"},
+		{&Compiler{IgnoreCase: true}, "WWW.B.COM"},
+		{&Compiler{IDN: true}, "www.xn--mnchen-3ya.de"},
+		{&Compiler{IDN: true}, "www.münchen.de"},
+	} {
+		rules := "*.a.com\n*.B.com\n*.münchen.de\n"
+		plain, combined := tc.compiler.NewBypasser(false).(*bypasser), tc.compiler.NewBypasser(false).(*bypasser)
+		if err := plain.Reload(strings.NewReader(rules)); err != nil {
+			t.Fatal(err)
+		}
+		if err := combined.Reload(strings.NewReader("combine-domains true\n" + rules)); err != nil {
+			t.Fatal(err)
+		}
+		if plain.Bypass(tc.addr) != combined.Bypass(tc.addr) {
+			t.Errorf("%+v, %s: combining the rules should not change the decision", *tc.compiler, tc.addr)
+		}
+	}
+}
+
+func benchmarkDomainPatterns(n int) string {
+	var sb strings.Builder
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&sb, "*.example%d.com\n", i)
+	}
+	return sb.String()
+}
+
+func BenchmarkDomainMatchers(b *testing.B) {
+	bp := NewBypasser(false).(*bypasser)
+	bp.Reload(strings.NewReader(benchmarkDomainPatterns(1000)))
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		bp.Bypass("www.example999.com")
+	}
+}
+
+func BenchmarkCombinedDomainMatchers(b *testing.B) {
+	bp := NewBypasser(false).(*bypasser)
+	bp.Reload(strings.NewReader("combine-domains true\n" + benchmarkDomainPatterns(1000)))
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		bp.Bypass("www.example999.com")
+	}
+}
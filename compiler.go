@@ -0,0 +1,385 @@
+package bypass
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	glob "github.com/gobwas/glob"
+	"golang.org/x/net/idna"
+)
+
+var (
+	// ErrEmptyPattern is returned when compiling an empty pattern.
+	ErrEmptyPattern = errors.New("bypass: empty pattern")
+)
+
+// errUnbalancedBraces is returned for a domain pattern whose alternatives are not closed, such as '*.example.{com',
+// which the glob package would compile without an error.
+var errUnbalancedBraces = errors.New("unbalanced braces")
+
+// errEmptyAlternatives is returned for a domain pattern with only empty alternatives, such as '0{}' or '0{,}',
+// which the glob package would compile into a glob panicking on a short domain.
+var errEmptyAlternatives = errors.New("empty alternatives")
+
+// DefaultCompiler is the Compiler used by NewMatcher, NewBypasserPatterns and Reload.
+// Its fields are the package-level toggles of the matching options,
+// e.g. setting DefaultCompiler.IDN enables the IDN normalization of the domain patterns,
+// and setting DefaultCompiler.StrictLabelWildcards makes the wildcards match a single label.
+// They should be set before any pattern is compiled.
+var DefaultCompiler = &Compiler{}
+
+// Compiler creates Matchers from patterns with a consistent set of normalization options,
+// so that all the matchers of a bypass share the same options.
+// The zero value compiles patterns the same way as NewMatcher does by default.
+type Compiler struct {
+	// IgnoreCase makes domain matchers case-insensitive.
+	IgnoreCase bool
+	// IDN converts internationalized domain names to their ASCII (punycode) form
+	// for both the pattern and the matched value, so that either form matches the other.
+	// The conversion is done label by label and leaves the wildcards intact,
+	// e.g. '*.münchen.de' matches both 'shop.münchen.de' and 'shop.xn--mnchen-3ya.de'.
+	// A pattern with a Unicode label containing wildcards, such as 'mün*.de', is compared in Unicode form instead.
+	IDN bool
+	// Separators are the glob separators for domain patterns, a wildcard does not match across them.
+	// The ':' of a host:port pattern, such as '*.example.com:80*', is always a separator.
+	// With '.' as the separator, the single-label mode, '*' matches within one label only,
+	// e.g. 'host-*-prod.example.com' matches 'host-42-prod.example.com' but not 'host-a.b-prod.example.com',
+	// while '**' still matches across labels.
+	Separators []rune
+	// StrictLabelWildcards makes '*' match exactly one label of a domain and '**' any number of labels,
+	// e.g. '*.example.com' matches 'www.example.com' but not 'a.b.example.com', which '**.example.com' matches.
+	// It is the single-label mode of Separators, with '.' added to the separators.
+	// By default, '*.example.com' matches the subdomains at any depth.
+	StrictLabelWildcards bool
+	// Wildcard is the character used as the wildcard in domain patterns instead of '*'.
+	Wildcard rune
+	// ZoneSensitive makes an IP pattern with an IPv6 zone, such as 'fe80::1%eth0', match that zone only.
+	// By default the zones are ignored, an IP pattern with or without a zone matches the address in any zone.
+	ZoneSensitive bool
+	// ImplicitSubdomains makes a plain domain pattern, without wildcards, anchors or a leading '.',
+	// match the subdomains as well, as if it were the '.example.com' form:
+	// 'example.com' then matches 'www.example.com'. By default it matches 'example.com' only.
+	ImplicitSubdomains bool
+	// Dedup drops the duplicate rules of the bypasses created by NewBypasser or loaded by Reload,
+	// the rules whose String() is the one of an earlier rule, keeping the first ones in order,
+	// e.g. when config files are concatenated. It is the default of the 'dedup' option of the config.
+	Dedup bool
+}
+
+// Compile creates a Matcher for the given pattern, see NewMatcher for the pattern types.
+// The bare wildcard, '*' or Wildcard, gives an AnyMatcher.
+// A pair of IP addresses of the same family, such as '192.168.1.10-192.168.1.50', gives a RangeMatcher.
+// A pattern wrapped in slashes, such as '/^api-\d+\.example\.com$/', gives a RegexMatcher,
+// case-insensitive if IgnoreCase is set.
+// A host:port pattern, such as 'example.com:80', '192.168.1.1:https' or '[::1]:*',
+// gives a PortMatcher of the host pattern. A port which is not a number, a service name or '*'
+// is part of a domain pattern instead, such as in '*.example.com:80*'.
+// A URL pattern with a scheme, such as 'http://*.example.com', gives a SchemeMatcher of the host pattern.
+// A token which does not look like a domain, without a dot or with a slash, with a single wildcard at its end or its start,
+// such as 'svc-*' or '/var/run/*', gives a PrefixMatcher or a SuffixMatcher.
+// A label count pattern, such as 'labels:1' or 'labels:1-2', gives a LabelCountMatcher.
+// Unlike NewMatcher, it reports an error instead of returning a nil Matcher.
+func (c *Compiler) Compile(pattern string) (Matcher, error) {
+	if pattern == "" {
+		return nil, ErrEmptyPattern
+	}
+	if wildcard := c.Wildcard; pattern == "*" && (wildcard == 0 || wildcard == '*') || wildcard != 0 && pattern == string(wildcard) {
+		return &anyMatcher{raw: pattern}, nil
+	}
+	if isRegexPattern(pattern) {
+		return c.compileRegex(pattern)
+	}
+	if m, ok, err := parseLabelCount(pattern); ok {
+		if err != nil {
+			return nil, err
+		}
+		return m, nil
+	}
+	if scheme, host, ok := splitSchemePattern(pattern); ok {
+		m, err := c.Compile(host)
+		if err != nil {
+			return nil, err
+		}
+		return &schemeMatcher{
+			Matcher: m,
+			scheme:  strings.ToLower(scheme),
+			raw:     pattern,
+		}, nil
+	}
+	if ip, zone := parseIPZone(pattern); ip != nil {
+		return &ipMatcher{
+			ip:            ip,
+			zone:          zone,
+			zoneSensitive: c.ZoneSensitive,
+		}, nil
+	}
+	if _, inet, err := parseCIDR(pattern); err == nil {
+		return &cidrMatcher{
+			ipNet: inet,
+			raw:   pattern,
+		}, nil
+	}
+	if low, high, ok := parseRange(pattern); ok {
+		return newRangeMatcher(low, high)
+	}
+	if host, port, ok := splitPatternPort(pattern); ok {
+		ports, ranges, ok, err := parsePortPattern(port)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			m, err := c.Compile(host)
+			if err != nil {
+				return nil, err
+			}
+			return &portMatcher{
+				Matcher: m,
+				ports:   ports,
+				raw:     pattern,
+				ranges:  ranges,
+			}, nil
+		}
+	}
+	if m, ok := c.compileLiteral(pattern); ok {
+		return m, nil
+	}
+	return c.compileDomain(pattern)
+}
+
+// NewBypasser creates and initializes a new Bypasser using match patterns compiled by c as its match rules.
+// The rules will be reversed if the reversed is true. Later reloads of the bypass are compiled by c as well.
+func (c *Compiler) NewBypasser(reversed bool, patterns ...string) Bypasser {
+	var matchers []Matcher
+	for _, pattern := range patterns {
+		if m, _ := c.Compile(pattern); m != nil {
+			matchers = append(matchers, m)
+		}
+	}
+	if c.Dedup {
+		matchers = dedupMatchers(matchers)
+	}
+	bp := NewBypasser(reversed, matchers...).(*bypasser)
+	bp.compiler = c
+	return bp
+}
+
+func (c *Compiler) compileDomain(pattern string) (Matcher, error) {
+	raw := pattern
+	if c.Wildcard != 0 && c.Wildcard != '*' {
+		pattern = strings.Replace(pattern, "*", `\*`, -1)
+		pattern = strings.Replace(pattern, string(c.Wildcard), "*", -1)
+	}
+
+	m := &domainMatcher{
+		raw:  raw,
+		fold: c.IgnoreCase,
+		idn:  c.IDN,
+		// a label in Unicode with wildcards has no punycode form,
+		// so the pattern and the values are compared in Unicode form.
+		unicode: c.IDN && hasUnicodeWildcardLabel(pattern),
+	}
+	pattern = m.normalize(pattern)
+	if c.ImplicitSubdomains && isPlainDomain(pattern) {
+		pattern = "." + pattern
+	}
+
+	m.pattern = pattern
+	start, end := strings.HasPrefix(pattern, "^"), strings.HasSuffix(pattern, "$")
+	if !start && !end {
+		// the trailing dot of a fully qualified domain name
+		pattern = trimTrailingDot(pattern)
+		m.pattern = pattern
+	}
+	if start || end {
+		pattern = strings.TrimSuffix(strings.TrimPrefix(pattern, "^"), "$")
+		if !start {
+			pattern = "*" + pattern
+		}
+		if !end {
+			pattern += "*"
+		}
+		m.pattern = pattern
+	} else if strings.HasPrefix(pattern, ".") {
+		m.pattern = pattern[1:] // trim the prefix '.'
+		m.dot = true
+		pattern = suffixExpr(m.pattern)
+	}
+	seps := c.separators()
+	m.separated = len(seps) > 0
+	if strings.Contains(pattern, ":") {
+		// a host:port pattern, the wildcards do not match across the ':' between the host and the port
+		seps = append(seps[:len(seps):len(seps)], ':')
+	}
+	if !balancedBraces(pattern) {
+		return nil, errUnbalancedBraces
+	}
+	if emptyAlternatives(pattern) {
+		return nil, errEmptyAlternatives
+	}
+	g, err := glob.Compile(pattern, seps...)
+	if err != nil {
+		return nil, err
+	}
+	m.expr = pattern
+	m.glob = g
+
+	return m, nil
+}
+
+// suffixExpr returns the glob expression of the special wildcard '.suffix',
+// matching the suffix itself and its subdomains on label boundaries: '{suffix,**.suffix}'.
+// '**' matches across the separators as well.
+// The commas of the suffix outside of braces are escaped not to split the alternatives.
+func suffixExpr(suffix string) string {
+	var sb strings.Builder
+	for i := topLevelComma(suffix); i >= 0; i = topLevelComma(suffix) {
+		sb.WriteString(suffix[:i])
+		sb.WriteString(`\,`)
+		suffix = suffix[i+1:]
+	}
+	sb.WriteString(suffix)
+	return "{" + sb.String() + ",**." + sb.String() + "}"
+}
+
+// separators returns the glob separators of the domain patterns, see Separators and StrictLabelWildcards.
+func (c *Compiler) separators() []rune {
+	if c.StrictLabelWildcards && !slices.Contains(c.Separators, '.') {
+		return append(c.Separators[:len(c.Separators):len(c.Separators)], '.')
+	}
+	return c.Separators
+}
+
+// normalize applies the case and IDN normalization of the matcher to s.
+func (m *domainMatcher) normalize(s string) string {
+	if m.fold {
+		s = strings.ToLower(s)
+	}
+	if m.idn {
+		s = idnLabels(s, m.unicode)
+	}
+	return s
+}
+
+// idnLabels converts each label of the domain s to its ASCII (punycode) form,
+// or to its Unicode form if toUnicode is true.
+// The labels with glob wildcards are left untouched, as well as the ones which can not be converted.
+func idnLabels(s string, toUnicode bool) string {
+	labels := strings.Split(s, ".")
+	for i, label := range labels {
+		if hasGlobMeta(label) {
+			continue
+		}
+		var v string
+		var err error
+		if toUnicode {
+			v, err = idna.ToUnicode(label)
+		} else {
+			v, err = idna.ToASCII(label)
+		}
+		if err == nil {
+			labels[i] = v
+		}
+	}
+	return strings.Join(labels, ".")
+}
+
+// trimTrailingDot removes the trailing dot of a fully qualified domain name such as 'www.example.com.'.
+func trimTrailingDot(s string) string {
+	if len(s) > 1 && s[len(s)-1] == '.' {
+		return s[:len(s)-1]
+	}
+	return s
+}
+
+// hasUnicodeWildcardLabel reports whether a label of the domain pattern has both
+// non-ASCII characters and glob wildcards, such a label has no punycode form.
+func hasUnicodeWildcardLabel(pattern string) bool {
+	for _, label := range strings.Split(pattern, ".") {
+		if hasGlobMeta(label) && !isASCII(label) {
+			return true
+		}
+	}
+	return false
+}
+
+// isPlainDomain reports whether the domain pattern is a domain name only, such as 'example.com'.
+func isPlainDomain(pattern string) bool {
+	return pattern != "" && !hasGlobMeta(pattern) && !strings.ContainsAny(pattern, ":^$") && pattern[0] != '.'
+}
+
+// balancedBraces reports whether the braces of the glob expression s are balanced,
+// the escaped ones and the ones in a character class aside.
+func balancedBraces(s string) bool {
+	depth, class := 0, false
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\':
+			i++
+		case class:
+			class = c != ']'
+		case c == '[':
+			class = true
+		case c == '{':
+			depth++
+		case c == '}':
+			if depth--; depth < 0 {
+				return false
+			}
+		}
+	}
+	return depth == 0
+}
+
+// emptyAlternatives reports whether the balanced glob expression s has braces whose alternatives are all empty,
+// such as '{}' or '{,}', the escaped ones and the ones in a character class aside.
+func emptyAlternatives(s string) bool {
+	var groups []bool // whether each open brace has a non-empty alternative
+	class := false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case class:
+			class = c != ']'
+			continue
+		case c == '{':
+			if len(groups) > 0 {
+				groups[len(groups)-1] = true
+			}
+			groups = append(groups, false)
+			continue
+		case c == '}':
+			if len(groups) > 0 {
+				if !groups[len(groups)-1] {
+					return true
+				}
+				groups = groups[:len(groups)-1]
+			}
+			continue
+		case c == ',':
+			continue
+		case c == '\\':
+			i++
+		case c == '[':
+			class = true
+		}
+		if len(groups) > 0 {
+			groups[len(groups)-1] = true
+		}
+	}
+	return false
+}
+
+func hasGlobMeta(s string) bool {
+	return strings.ContainsAny(s, `*?[]{}\`)
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
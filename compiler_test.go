@@ -0,0 +1,333 @@
+package bypass
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+var compilerTests = []struct {
+	compiler *Compiler
+	pattern  string
+	addr     string
+	matched  bool
+}{
+	{&Compiler{}, "www.example.com", "WWW.Example.COM", false},
+	{&Compiler{IgnoreCase: true}, "www.example.com", "WWW.Example.COM", true},
+	{&Compiler{IgnoreCase: true}, "*.EXAMPLE.com", "www.example.COM", true},
+	{&Compiler{IgnoreCase: true}, ".Example.com", "example.COM", true},
+
+	{&Compiler{}, "münchen.de", "xn--mnchen-3ya.de", false},
+	{&Compiler{IDN: true}, "münchen.de", "xn--mnchen-3ya.de", true},
+	{&Compiler{IDN: true}, "xn--mnchen-3ya.de", "münchen.de", true},
+	{&Compiler{IDN: true}, "例え.テスト", "xn--r8jz45g.xn--zckzah", true},
+	{&Compiler{IDN: true, IgnoreCase: true}, "MÜNCHEN.de", "xn--mnchen-3ya.de", true},
+
+	// IDN wildcards
+	{&Compiler{IDN: true}, "*.münchen.de", "shop.münchen.de", true},
+	{&Compiler{IDN: true}, "*.münchen.de", "shop.xn--mnchen-3ya.de", true},
+	{&Compiler{IDN: true}, "*.xn--mnchen-3ya.de", "shop.münchen.de", true},
+	{&Compiler{IDN: true}, "*.münchen.de", "shop.köln.de", false},
+	{&Compiler{IDN: true}, ".münchen.de", "münchen.de", true},
+	{&Compiler{IDN: true}, ".münchen.de", "a.b.xn--mnchen-3ya.de", true},
+	{&Compiler{IDN: true}, "shop.*.münchen.de", "shop.city.xn--mnchen-3ya.de", true},
+	{&Compiler{IDN: true}, "*.例え.テスト", "www.xn--r8jz45g.xn--zckzah", true},
+	{&Compiler{IDN: true}, "*.例え.test", "www.xn--r8jz45g.test", true},
+	{&Compiler{IDN: true}, "*.例え.test", "www.例え.test", true},
+	{&Compiler{IDN: true}, "[!0-9]*.münchen.de", "1shop.münchen.de", false},
+	{&Compiler{IDN: true}, "[!0-9]*.münchen.de", "shop.xn--mnchen-3ya.de", true},
+	{&Compiler{IDN: true}, "mün*.de", "münchen.de", true},
+	{&Compiler{IDN: true}, "mün*.de", "xn--mnchen-3ya.de", true},
+	{&Compiler{IDN: true}, "mün*.de", "munchen.de", false},
+	{&Compiler{IDN: true}, "*.mün*.de", "shop.xn--mnchen-3ya.de", true},
+	{&Compiler{IDN: true, IgnoreCase: true}, "*.MÜNCHEN.de", "SHOP.xn--mnchen-3ya.DE", true},
+
+	{&Compiler{}, "*.example.com", "abc.def.example.com", true},
+	{&Compiler{Separators: []rune{'.'}}, "*.example.com", "www.example.com", true},
+	{&Compiler{Separators: []rune{'.'}}, "*.example.com", "abc.def.example.com", false},
+	{&Compiler{Separators: []rune{'.'}}, "**.example.com", "abc.def.example.com", true},
+
+	// brace alternation
+	{&Compiler{Separators: []rune{'.'}}, "example.{com,net,org}", "example.org", true},
+	{&Compiler{Separators: []rune{'.'}}, "example.{com,net,org}", "example.io", false},
+	{&Compiler{Separators: []rune{'.'}}, "*.example.{com,net,org}", "www.example.net", true},
+	{&Compiler{Separators: []rune{'.'}}, "*.example.{com,net,org}", "a.b.example.net", false},
+	{&Compiler{Separators: []rune{'.'}}, "*.example.{com,net,org}", "www.example.io", false},
+	{&Compiler{Separators: []rune{'.'}}, ".example.{com,net}", "example.com", true},
+	{&Compiler{Separators: []rune{'.'}}, ".example.{com,net}", "www.example.net", true},
+	{&Compiler{Separators: []rune{'.'}}, ".example.{com,net}", "a.b.example.net", true},
+	{&Compiler{Separators: []rune{'.'}}, ".example.{com,net}", "www.example.io", false},
+	{&Compiler{Separators: []rune{'.'}}, ".example.com", "www.example.com", true},
+
+	// a wildcard in the middle of a label stays within the label in single-label mode
+	{&Compiler{}, "host-*-prod.example.com", "host-42-prod.example.com", true},
+	{&Compiler{}, "host-*-prod.example.com", "host-a.b-prod.example.com", true},
+	{&Compiler{}, "host-*-prod.example.com", "host-a.b.c-prod.example.com", true},
+	{&Compiler{Separators: []rune{'.'}}, "host-*-prod.example.com", "host-42-prod.example.com", true},
+	{&Compiler{Separators: []rune{'.'}}, "host-*-prod.example.com", "host--prod.example.com", true},
+	{&Compiler{Separators: []rune{'.'}}, "host-*-prod.example.com", "host-a.b-prod.example.com", false},
+	{&Compiler{Separators: []rune{'.'}}, "host-*-prod.example.com", "host-a.b.c-prod.example.com", false},
+	{&Compiler{Separators: []rune{'.'}}, "host-*-prod.example.com", "host-42-prod.www.example.com", false},
+	{&Compiler{Separators: []rune{'.'}}, "host-**-prod.example.com", "host-a.b-prod.example.com", true},
+
+	// host:port wildcards are bounded by the ':'
+	{&Compiler{}, "*.example.com:80*", "www.example.com:80", true},
+	{&Compiler{}, "*.example.com:80*", "www.example.com:8080", true},
+	{&Compiler{}, "*.example.com:80*", "a.b.example.com:8080", true},
+	{&Compiler{}, "*.example.com:80*", "www.example.com:443", false},
+	{&Compiler{}, "*.example.com:80*", "www.example.com:80:443", false},
+	{&Compiler{}, "*.example.com:80*", "http://www.example.com:80", false},
+	{&Compiler{}, "*:80", "www.example.com:80", true},
+	{&Compiler{}, "*:80", "www.example.com:8080", false},
+	{&Compiler{}, "*:80", "http://www.example.com:80", false},
+	{&Compiler{}, "**:8*", "http://www.example.com:80", true},
+	{&Compiler{}, "example.com:*", "example.com:8080", true},
+	{&Compiler{}, "example.com:*", "example.com:80:80", false},
+	{&Compiler{}, "example.com:*", "example.com", false},
+	{&Compiler{}, "www.*:443", "www.example.com:443", true},
+	{&Compiler{}, "www.*:443", "www.example.com:8443", false},
+	{&Compiler{Separators: []rune{'.'}}, "*.example.com:80*", "www.example.com:8080", true},
+	{&Compiler{Separators: []rune{'.'}}, "*.example.com:80*", "a.b.example.com:8080", false},
+	{&Compiler{Separators: []rune{'.'}}, "**.example.com:80*", "a.b.example.com:8080", true},
+
+	// fully qualified domain names
+	{&Compiler{}, "www.example.com", "www.example.com.", true},
+	{&Compiler{}, "www.example.com.", "www.example.com", true},
+	{&Compiler{}, "*.example.com", "www.example.com.", true},
+	{&Compiler{}, ".example.com", "example.com.", true},
+	{&Compiler{Separators: []rune{'.'}}, "*.example.com", "www.example.com.", true},
+	{&Compiler{IDN: true}, "münchen.de.", "xn--mnchen-3ya.de", true},
+
+	{&Compiler{Wildcard: '%'}, "%.example.com", "www.example.com", true},
+	{&Compiler{Wildcard: '%'}, "*.example.com", "www.example.com", false},
+	{&Compiler{Wildcard: '%'}, "*.example.com", "*.example.com", true},
+
+	// IPv6 zones
+	{&Compiler{}, "fe80::1", "fe80::1%eth0", true},
+	{&Compiler{}, "fe80::1%eth0", "fe80::1", true},
+	{&Compiler{}, "fe80::1%eth0", "fe80::1%eth1", true},
+	{&Compiler{ZoneSensitive: true}, "fe80::1", "fe80::1%eth0", true},
+	{&Compiler{ZoneSensitive: true}, "fe80::1%eth0", "fe80::1%eth0", true},
+	{&Compiler{ZoneSensitive: true}, "fe80::1%eth0", "fe80::1%eth1", false},
+	{&Compiler{ZoneSensitive: true}, "fe80::1%eth0", "fe80::1", false},
+	{&Compiler{ZoneSensitive: true}, "fe80::1%eth0", "fe80::2%eth0", false},
+
+	{&Compiler{IgnoreCase: true}, "192.168.1.1", "192.168.1.1", true},
+	{&Compiler{IgnoreCase: true}, "192.168.1.0/24", "192.168.1.1", true},
+}
+
+func TestCompiler(t *testing.T) {
+	for i, tc := range compilerTests {
+		tc := tc
+		t.Run(fmt.Sprintf("#%d", i), func(t *testing.T) {
+			m, err := tc.compiler.Compile(tc.pattern)
+			if err != nil {
+				t.Fatalf("#%d compile failed: %v", i, err)
+			}
+			if m.Match(tc.addr) != tc.matched {
+				t.Errorf("#%d test failed: %+v, %s, %s", i, tc.compiler, tc.pattern, tc.addr)
+			}
+		})
+	}
+}
+
+func TestCompilerErrors(t *testing.T) {
+	c := &Compiler{}
+	if _, err := c.Compile(""); err != ErrEmptyPattern {
+		t.Errorf("expected %v, got %v", ErrEmptyPattern, err)
+	}
+	if _, err := c.Compile("[example.com"); err == nil {
+		t.Errorf("expected an error for a malformed glob")
+	}
+	if m := NewMatcher("[example.com"); m != nil {
+		t.Errorf("NewMatcher should return nil for a malformed glob")
+	}
+}
+
+func TestCompilerBypasser(t *testing.T) {
+	c := &Compiler{IgnoreCase: true}
+
+	bp := c.NewBypasser(false, "*.example.com")
+	if !bp.Bypass("WWW.EXAMPLE.COM") {
+		t.Errorf("patterns should be compiled by the compiler")
+	}
+
+	if err := bp.(*bypasser).Reload(strings.NewReader("*.example.org")); err != nil {
+		t.Fatal(err)
+	}
+	if !bp.Bypass("WWW.EXAMPLE.ORG") {
+		t.Errorf("reloaded patterns should be compiled by the compiler")
+	}
+}
+
+func TestCompilerZoneString(t *testing.T) {
+	m, err := (&Compiler{}).Compile("fe80::1%eth0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, want := m.String(), "ip fe80::1%eth0"; s != want {
+		t.Errorf("expected %q, got %q", want, s)
+	}
+}
+
+func TestDomainMatcherIDN(t *testing.T) {
+	for _, tc := range []struct {
+		pattern string
+		domain  string
+	}{
+		{"例え.テスト", "xn--r8jz45g.xn--zckzah"},
+		{"xn--r8jz45g.xn--zckzah", "例え.テスト"},
+		{"*.例え.テスト", "www.xn--r8jz45g.xn--zckzah"},
+		{".münchen.de", "shop.xn--mnchen-3ya.de"},
+	} {
+		if !DomainMatcherIDN(tc.pattern).Match(tc.domain) {
+			t.Errorf("%s should match %s", tc.pattern, tc.domain)
+		}
+		if DomainMatcher(tc.pattern).Match(tc.domain) {
+			t.Errorf("%s should not match %s without IDN", tc.pattern, tc.domain)
+		}
+	}
+}
+
+func TestDefaultCompilerIDN(t *testing.T) {
+	defer func(idn bool) { DefaultCompiler.IDN = idn }(DefaultCompiler.IDN)
+
+	if NewMatcher("例え.テスト").Match("xn--r8jz45g.xn--zckzah") {
+		t.Errorf("IDN should be disabled by default")
+	}
+	DefaultCompiler.IDN = true
+	if !NewMatcher("例え.テスト").Match("xn--r8jz45g.xn--zckzah") {
+		t.Errorf("NewMatcher should honor the IDN toggle")
+	}
+	if !NewBypasserPatterns(false, "xn--r8jz45g.xn--zckzah").Bypass("例え.テスト:443") {
+		t.Errorf("NewBypasserPatterns should honor the IDN toggle")
+	}
+}
+
+func TestDomainMatcherSep(t *testing.T) {
+	for _, tc := range []struct {
+		pattern   string
+		domain    string
+		separated bool
+		matched   bool
+	}{
+		{"*.example.com", "www.example.com", false, true},
+		{"*.example.com", "www.example.com", true, true},
+		{"*.example.com", "a.b.example.com", false, true},
+		{"*.example.com", "a.b.example.com", true, false},
+		{"**.example.com", "a.b.example.com", true, true},
+		{".example.com", "a.b.example.com", true, true},
+		{"www.*.com", "www.a.b.com", false, true},
+		{"www.*.com", "www.a.b.com", true, false},
+	} {
+		m := DomainMatcher(tc.pattern)
+		if tc.separated {
+			m = DomainMatcherSep(tc.pattern, '.')
+		}
+		if m.Match(tc.domain) != tc.matched {
+			t.Errorf("%s, %s, separated %v: expected %v", tc.pattern, tc.domain, tc.separated, tc.matched)
+		}
+	}
+	if DomainMatcherSep("[example.com", '.').Match("example.com") {
+		t.Errorf("a malformed pattern should match nothing")
+	}
+}
+
+func TestDefaultCompilerSeparators(t *testing.T) {
+	defer func(seps []rune) { DefaultCompiler.Separators = seps }(DefaultCompiler.Separators)
+
+	if !NewMatcher("*.example.com").Match("a.b.example.com") {
+		t.Errorf("the wildcards should match across the labels by default")
+	}
+	DefaultCompiler.Separators = []rune{'.'}
+	if NewMatcher("*.example.com").Match("a.b.example.com") {
+		t.Errorf("NewMatcher should honor the separators toggle")
+	}
+	if NewBypasserPatterns(false, "*.example.com").Bypass("a.b.example.com:443") {
+		t.Errorf("NewBypasserPatterns should honor the separators toggle")
+	}
+}
+
+func TestStrictLabelWildcards(t *testing.T) {
+	for _, tc := range []struct {
+		pattern string
+		addr    string
+		matched bool // by default
+		strict  bool // with StrictLabelWildcards
+	}{
+		{"*.example.com", "www.example.com", true, true},
+		{"*.example.com", "a.b.example.com", true, false},
+		{"*.example.com", "example.com", false, false},
+		{"**.example.com", "www.example.com", true, true},
+		{"**.example.com", "a.b.example.com", true, true},
+		{".example.com", "a.b.example.com", true, true},
+		{"www.*.com", "www.a.b.com", true, false},
+		{"*.example.com:80*", "a.b.example.com:8080", true, false},
+		{"svc-*", "svc-a.b", true, false},
+		{"svc-*", "svc-a", true, true},
+	} {
+		for _, c := range []*Compiler{{}, {StrictLabelWildcards: true}, {StrictLabelWildcards: true, Separators: []rune{'.'}}} {
+			m, err := c.Compile(tc.pattern)
+			if err != nil {
+				t.Fatalf("%s: %v", tc.pattern, err)
+			}
+			expected := tc.matched
+			if c.StrictLabelWildcards {
+				expected = tc.strict
+			}
+			if m.Match(tc.addr) != expected {
+				t.Errorf("%s, %s, strict %v: expected %v", tc.pattern, tc.addr, c.StrictLabelWildcards, expected)
+			}
+		}
+	}
+
+	bp := (&Compiler{StrictLabelWildcards: true}).NewBypasser(false, "*.example.com")
+	if !bp.Bypass("www.example.com:443") || bp.Bypass("a.b.example.com:443") {
+		t.Errorf("the bypass should match a single label")
+	}
+}
+
+func TestImplicitSubdomains(t *testing.T) {
+	for _, tc := range []struct {
+		pattern  string
+		domain   string
+		exact    bool // the default
+		implicit bool
+	}{
+		{"example.com", "example.com", true, true},
+		{"example.com", "www.example.com", false, true},
+		{"example.com", "a.b.example.com.", false, true},
+		{"example.com", "www.example.com.cn", false, false},
+		{"Example.COM.", "WWW.example.com", false, true},
+		{".example.com", "www.example.com", true, true},
+		{"*.example.com", "example.com", false, false},
+		{"^example.com$", "www.example.com", false, false},
+		{"example.com:443", "www.example.com:443", false, true},
+		{"10.0.0.1", "10.0.0.1", true, true},
+	} {
+		for _, c := range []*Compiler{{IgnoreCase: true}, {IgnoreCase: true, ImplicitSubdomains: true}} {
+			want := tc.exact
+			if c.ImplicitSubdomains {
+				want = tc.implicit
+			}
+			m, err := c.Compile(tc.pattern)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if matched := m.Match(tc.domain); matched != want {
+				t.Errorf("%s, implicit subdomains %v, %s: expected %v, got %v",
+					tc.pattern, c.ImplicitSubdomains, tc.domain, want, matched)
+			}
+		}
+	}
+
+	defer func() { DefaultCompiler.ImplicitSubdomains = false }()
+	DefaultCompiler.ImplicitSubdomains = true
+	bp := NewBypasser(false).(*bypasser)
+	if err := bp.Reload(strings.NewReader("example.com\n")); err != nil {
+		t.Fatal(err)
+	}
+	if !bp.Bypass("www.example.com:443") || !bp.Bypass("example.com") || bp.Bypass("example.org") {
+		t.Errorf("Reload should honor the implicit subdomains toggle")
+	}
+}
@@ -0,0 +1,65 @@
+package bypass
+
+import (
+	"strings"
+)
+
+type compositeMatcher struct {
+	op       string // and, or
+	matchers []Matcher
+}
+
+// AndMatcher creates a Matcher matching when all the matchers match, e.g. a host in a CIDR
+// and on a given port with PortMatcher. The nil matchers are skipped,
+// a Matcher without any matcher matches nothing.
+func AndMatcher(matchers ...Matcher) Matcher {
+	return newCompositeMatcher("and", matchers)
+}
+
+// OrMatcher creates a Matcher matching when any of the matchers matches.
+// The nil matchers are skipped, a Matcher without any matcher matches nothing.
+func OrMatcher(matchers ...Matcher) Matcher {
+	return newCompositeMatcher("or", matchers)
+}
+
+func newCompositeMatcher(op string, matchers []Matcher) *compositeMatcher {
+	m := &compositeMatcher{op: op}
+	for _, matcher := range matchers {
+		if matcher != nil {
+			m.matchers = append(m.matchers, matcher)
+		}
+	}
+	return m
+}
+
+// Match matches the address v, the matchers of the host are given v without its port.
+func (m *compositeMatcher) Match(v string) bool {
+	if m == nil || len(m.matchers) == 0 {
+		return false
+	}
+
+	host, _ := splitHostPort(v)
+	host = trimTrailingDot(host)
+	for _, matcher := range m.matchers {
+		s := host
+		if matchesAddr(matcher) {
+			s = v
+		}
+		matched := matcher.Match(s)
+		if m.op == "and" && !matched {
+			return false
+		}
+		if m.op == "or" && matched {
+			return true
+		}
+	}
+	return m.op == "and"
+}
+
+func (m *compositeMatcher) String() string {
+	var ss []string
+	for _, matcher := range m.matchers {
+		ss = append(ss, matcher.String())
+	}
+	return m.op + "(" + strings.Join(ss, ", ") + ")"
+}
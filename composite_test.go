@@ -0,0 +1,63 @@
+package bypass
+
+import (
+	"testing"
+)
+
+func TestCompositeMatcher(t *testing.T) {
+	tls := AndMatcher(NewMatcher("10.0.0.0/8"), NewMatcher("10.0.0.0/8:443"))
+	nested := OrMatcher(
+		tls,
+		AndMatcher(NewMatcher("*.example.com"), nil, OrMatcher(NewMatcher("www.*"), NewMatcher("api.*"))),
+	)
+
+	for _, tc := range []struct {
+		m       Matcher
+		addr    string
+		matched bool
+	}{
+		{tls, "10.1.2.3:443", true},
+		{tls, "10.1.2.3:80", false},
+		{tls, "10.1.2.3", false},
+		{tls, "192.168.1.1:443", false},
+		{nested, "10.1.2.3:443", true},
+		{nested, "www.example.com", true},
+		{nested, "api.example.com:8080", true},
+		{nested, "cdn.example.com", false},
+		{nested, "www.example.org", false},
+		{AndMatcher(), "www.example.com", false},
+		{OrMatcher(nil, nil), "www.example.com", false},
+		{OrMatcher(nil, NewMatcher("*.example.com")), "www.example.com", true},
+	} {
+		if tc.m.Match(tc.addr) != tc.matched {
+			t.Errorf("test failed: %s, %s", tc.m, tc.addr)
+		}
+	}
+
+	want := "or(and(cidr 10.0.0.0/8, cidr 10.0.0.0/8 port 443), " +
+		"and(domain *.example.com, or(domain www.*, domain api.*)))"
+	if s := nested.String(); s != want {
+		t.Errorf("expected %q, got %q", want, s)
+	}
+}
+
+func TestCompositeMatcherBypass(t *testing.T) {
+	bp := NewBypasser(false,
+		AndMatcher(NewMatcher("10.0.0.0/8"), PortMatcher(NewMatcher("*"), 443)),
+		OrMatcher(NewMatcher("*.example.com"), NewMatcher("*.example.org")),
+	)
+	for _, tc := range []struct {
+		addr     string
+		bypassed bool
+	}{
+		{"10.1.2.3:443", true},
+		{"10.1.2.3:80", false},
+		{"www.example.com:80", true},
+		{"www.example.org", true},
+		{"www.example.net", false},
+	} {
+		if bp.Bypass(tc.addr) != tc.bypassed {
+			t.Errorf("test failed: %s", tc.addr)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package bypass
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// WriteConfig writes the options and the rules of the bypass to w in the config format,
+// which can be loaded back by Reload to reproduce an equivalent bypass.
+// A rule without a pattern form, such as an AndMatcher, is written as a comment.
+func (bp *bypasser) WriteConfig(w io.Writer) error {
+	var sb strings.Builder
+
+	bp.mux.RLock()
+	bp.writeOptions(&sb)
+	for _, m := range bp.matchers {
+		if m != nil {
+			writeRule(&sb, m)
+		}
+	}
+	bp.mux.RUnlock()
+
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// writeOptions writes the options of the bypass in the config format.
+// The caller must hold bp.mux.
+func (bp *bypasser) writeOptions(w io.Writer) {
+	fmt.Fprintf(w, "reverse %v\n", bp.reversed)
+	// the same decision as the reverse option, for the readers of the config
+	if bp.reversed {
+		fmt.Fprintf(w, "default bypass\n")
+	} else {
+		fmt.Fprintf(w, "default proxy\n")
+	}
+	if bp.splitReverse {
+		fmt.Fprintf(w, "reverse-domains %v\n", bp.domainsReversed)
+	}
+	if bp.keepPortIP {
+		fmt.Fprintf(w, "strip-port-ip false\n")
+	}
+	if bp.keepPortDomain {
+		fmt.Fprintf(w, "strip-port-domain false\n")
+	}
+	if bp.period != 0 {
+		fmt.Fprintf(w, "reload %v\n", bp.period)
+	}
+	opts := bp.ruleOpts
+	if opts.combine {
+		fmt.Fprintf(w, "combine-domains true\n")
+	}
+	if opts.indexSuffixes {
+		fmt.Fprintf(w, "index-suffixes true\n")
+	}
+	if opts.dedup {
+		fmt.Fprintf(w, "dedup true\n")
+	}
+	if opts.validate != "" {
+		fmt.Fprintf(w, "validate-hostnames %s\n", opts.validate)
+	}
+}
+
+// writeRule writes the matcher m in the config format,
+// a matcher without a pattern form is written as a comment.
+func writeRule(w io.Writer, m Matcher) {
+	patterns, ok := matcherPatterns(m)
+	if !ok {
+		fmt.Fprintf(w, "# %s\n", m.String())
+		return
+	}
+	for _, pattern := range patterns {
+		fmt.Fprintln(w, configPattern(pattern))
+	}
+}
+
+// configPattern returns the pattern in the config format, quoted if needed, see quoteToken.
+// The pattern of an allow or a deny rule is quoted after its prefix.
+func configPattern(pattern string) string {
+	for _, prefix := range []string{"allow ", "deny "} {
+		if p, ok := strings.CutPrefix(pattern, prefix); ok {
+			return prefix + quoteToken(p)
+		}
+	}
+	return quoteToken(pattern)
+}
+
+// matcherPatterns returns the patterns the matcher m can be compiled back from,
+// false if m is not built from patterns.
+func matcherPatterns(matcher Matcher) ([]string, bool) {
+	switch m := matcher.(type) {
+	case *ipMatcher:
+		if m.zone != "" {
+			return []string{m.ip.String() + "%" + m.zone}, true
+		}
+		return []string{m.ip.String()}, true
+	case *cidrMatcher:
+		if m.raw != "" {
+			return []string{m.raw}, true
+		}
+		return []string{m.ipNet.String()}, true
+	case *domainMatcher:
+		return []string{m.raw}, true
+	case *domainGroupMatcher:
+		return m.raws, true
+	case *suffixTrieMatcher:
+		return m.exprs, true
+	case *rangeMatcher:
+		return []string{m.pattern()}, true
+	case *anyMatcher:
+		if m.raw != "" {
+			return []string{m.raw}, true
+		}
+		return []string{"*"}, true
+	case *regexMatcher:
+		return []string{"/" + m.raw + "/"}, true
+	case *prefixMatcher:
+		if m.raw != "" {
+			return []string{m.raw}, true
+		}
+		return []string{m.prefix + "*"}, true
+	case *suffixMatcher:
+		if m.raw != "" {
+			return []string{m.raw}, true
+		}
+		return []string{"*" + m.suffix}, true
+	case *labelCountMatcher:
+		return []string{labelsPrefix + m.count()}, true
+	case *negatedMatcher:
+		patterns, ok := matcherPatterns(m.Matcher)
+		negated := make([]string, len(patterns))
+		for i, pattern := range patterns {
+			negated[i] = "!" + pattern
+		}
+		return negated, ok
+	case *deniedMatcher:
+		patterns, ok := matcherPatterns(m.Matcher)
+		denied := make([]string, len(patterns))
+		for i, pattern := range patterns {
+			denied[i] = "deny " + pattern
+		}
+		return denied, ok
+	case *portMatcher:
+		if m.raw == "" {
+			return nil, false
+		}
+		return []string{m.raw}, true
+	case *schemeMatcher:
+		if m.raw != "" {
+			return []string{m.raw}, true
+		}
+		patterns, ok := matcherPatterns(m.Matcher)
+		if !ok || len(patterns) != 1 {
+			return nil, false
+		}
+		return []string{m.scheme + "://" + patterns[0]}, true
+	default:
+		return nil, false
+	}
+}
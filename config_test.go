@@ -0,0 +1,140 @@
+package bypass
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestWriteConfig(t *testing.T) {
+	config := `reload 30s
+reverse true
+strip-port-domain false
+192.168.1.1
+fe80::1%eth0
+10.0.0.0/8
+172.16.0.10-172.16.0.20
+*.example.com
+.example.org
+^www.example.net$
+/^api-\d+\.example\.io$/
+!secure.example.com
+example.com:443
+*.example.net:80*
+`
+	bp := NewBypasser(false).(*bypasser)
+	if err := bp.Reload(strings.NewReader(config)); err != nil {
+		t.Fatal(err)
+	}
+	composite := AndMatcher(NewMatcher("*.example.com"), NewMatcher("www.*"))
+	bp.AddMatcher(composite)
+
+	var sb strings.Builder
+	if err := bp.WriteConfig(&sb); err != nil {
+		t.Fatal(err)
+	}
+
+	clone := NewBypasser(false).(*bypasser)
+	if err := clone.Reload(strings.NewReader(sb.String())); err != nil {
+		t.Fatal(err)
+	}
+
+	if clone.Period() != bp.Period() || clone.Reversed() != bp.Reversed() || clone.keepPortDomain != bp.keepPortDomain {
+		t.Errorf("the options should round-trip:\n%s", sb.String())
+	}
+	if got, want := fmt.Sprint(clone.Matchers()), fmt.Sprint(bp.Matchers()[:bp.Len()-1]); got != want {
+		t.Errorf("the rules should round-trip, expected %s, got %s", want, got)
+	}
+	if !strings.Contains(sb.String(), "# and(") {
+		t.Errorf("a rule without a pattern form should be written as a comment:\n%s", sb.String())
+	}
+
+	bp.RemoveMatcher(composite.String())
+
+	for _, addr := range []string{
+		"192.168.1.1", "fe80::1", "10.1.2.3", "172.16.0.15", "172.16.0.21",
+		"www.example.com:80", "secure.example.com:80", "example.org", "www.example.net",
+		"api-42.example.io", "example.com:443", "example.com:80", "a.example.net:8080", "other.io",
+	} {
+		if clone.Bypass(addr) != bp.Bypass(addr) {
+			t.Errorf("%s: the decisions should round-trip", addr)
+		}
+	}
+}
+
+func TestWriteConfigQuoted(t *testing.T) {
+	bp := NewBypasser(false).(*bypasser)
+	if err := bp.Reload(strings.NewReader("\"my host\"\n\"reload\"\n\"a#b.example.com\"\ndeny \"ads example\"\n\"!no proxy\"\n\"[corp]\"\n")); err != nil {
+		t.Fatal(err)
+	}
+	if bp.Len() != 6 {
+		t.Fatalf("expected 6 rules, got %v", bp)
+	}
+
+	var sb strings.Builder
+	if err := bp.WriteConfig(&sb); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{`"my host"`, `"reload"`, `"a#b.example.com"`, `deny "ads example"`, `"!no proxy"`, `"[corp]"`} {
+		if !strings.Contains(sb.String(), line+"\n") {
+			t.Errorf("expected the line %s:\n%s", line, sb.String())
+		}
+	}
+
+	clone := NewBypasser(false).(*bypasser)
+	if err := clone.Reload(strings.NewReader(sb.String())); err != nil {
+		t.Fatal(err)
+	}
+	if !clone.Equal(bp) {
+		t.Errorf("the quoted rules should round-trip, expected %v, got %v", bp, clone)
+	}
+}
+
+func TestWriteConfigOptions(t *testing.T) {
+	bp := NewBypasser(false).(*bypasser)
+	config := "reverse true\nreverse-domains false\nstrip-port-ip false\ncombine-domains true\nindex-suffixes true\n" +
+		"dedup true\nvalidate-hostnames strict\n" +
+		"*.example.com\n.example.org\nexample.net\n*.example.net\n10.0.0.0/8\n"
+	if err := bp.Reload(strings.NewReader(config)); err != nil {
+		t.Fatal(err)
+	}
+
+	var sb strings.Builder
+	if err := bp.WriteConfig(&sb); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"default bypass", "combine-domains true", "index-suffixes true", "dedup true",
+		"validate-hostnames strict", "*.example.com", ".example.org"} {
+		if !strings.Contains(sb.String(), line+"\n") {
+			t.Errorf("expected the line %s:\n%s", line, sb.String())
+		}
+	}
+	if strings.Contains(sb.String(), "**") {
+		t.Errorf("the combined rules should be written as their patterns:\n%s", sb.String())
+	}
+
+	clone := NewBypasser(false).(*bypasser)
+	if err := clone.Reload(strings.NewReader(sb.String())); err != nil {
+		t.Fatal(err)
+	}
+	if !clone.Equal(bp) {
+		t.Errorf("the config should round-trip, expected %v, got %v", bp, clone)
+	}
+	if clone.ruleOpts != bp.ruleOpts {
+		t.Errorf("expected the rule options %+v, got %+v", bp.ruleOpts, clone.ruleOpts)
+	}
+}
+
+type errWriter struct{}
+
+func (errWriter) Write([]byte) (int, error) {
+	return 0, errors.New("write failed")
+}
+
+func TestWriteConfigError(t *testing.T) {
+	bp := NewBypasserPatterns(false, "*.example.com")
+	if err := bp.(*bypasser).WriteConfig(errWriter{}); err == nil {
+		t.Errorf("the write error should be returned")
+	}
+}
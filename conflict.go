@@ -0,0 +1,118 @@
+package bypass
+
+import (
+	"bytes"
+	"net"
+)
+
+// ConflictKind is the kind of a Conflict between two rules.
+type ConflictKind string
+
+const (
+	// ConflictRedundant is a rule whose network contains the one of another rule of the same kind,
+	// plain, negated or deny, the contained rule has no effect.
+	ConflictRedundant ConflictKind = "redundant"
+	// ConflictOverlap is a pair of rules of different kinds whose networks overlap,
+	// such as a plain and a negated rule, the one of the higher precedence decides on their common addresses.
+	ConflictOverlap ConflictKind = "overlap"
+)
+
+// Conflict is a pair of the IP rules of a bypass found by Conflicts.
+type Conflict struct {
+	Kind ConflictKind
+	// the rules, in their order in the bypass
+	Matcher Matcher
+	Other   Matcher
+}
+
+func (c Conflict) String() string {
+	return string(c.Kind) + ": " + c.Matcher.String() + " and " + c.Other.String()
+}
+
+// netInterval is the inclusive interval of the IP addresses matched by a rule, in their 16-byte form.
+type netInterval struct {
+	low, high net.IP
+	rank      int
+}
+
+// Conflicts reports the redundant and the overlapping IP rules of the bypass bp, its IP, CIDR and range rules,
+// e.g. '10.1.0.0/16' is redundant with '10.0.0.0/8' and it overlaps with '!10.1.2.3'.
+// The rules restricted to some ports or schemes are left aside. The conflicts are in the order of the rules.
+// It is a read-only analysis of the rules of bp, the Bypassers without a Matchers method have no conflict.
+func Conflicts(bp Bypasser) []Conflict {
+	b, ok := bp.(interface{ Matchers() []Matcher })
+	if !ok {
+		return nil
+	}
+	matchers := b.Matchers()
+
+	intervals := make([]*netInterval, len(matchers))
+	for i, m := range matchers {
+		intervals[i] = ruleInterval(m)
+	}
+
+	var conflicts []Conflict
+	for i, a := range intervals {
+		if a == nil {
+			continue
+		}
+		for j := i + 1; j < len(intervals); j++ {
+			b := intervals[j]
+			if b == nil || bytes.Compare(a.low, b.high) > 0 || bytes.Compare(b.low, a.high) > 0 {
+				continue
+			}
+			kind := ConflictOverlap
+			if a.rank == b.rank {
+				if !a.contains(b) && !b.contains(a) {
+					continue
+				}
+				kind = ConflictRedundant
+			}
+			conflicts = append(conflicts, Conflict{
+				Kind:    kind,
+				Matcher: matchers[i],
+				Other:   matchers[j],
+			})
+		}
+	}
+	return conflicts
+}
+
+func (r *netInterval) contains(other *netInterval) bool {
+	return bytes.Compare(r.low, other.low) <= 0 && bytes.Compare(other.high, r.high) <= 0
+}
+
+// ruleInterval returns the interval of the IP addresses matched by the rule of the matcher, nil if it is not an IP rule.
+func ruleInterval(matcher Matcher) *netInterval {
+	rank := matcherRank(matcher)
+	switch m := matcher.(type) {
+	case *negatedMatcher:
+		matcher = m.Matcher
+	case *deniedMatcher:
+		matcher = m.Matcher
+	}
+
+	r := &netInterval{rank: rank}
+	switch m := matcher.(type) {
+	case *ipMatcher:
+		if m.ip == nil {
+			return nil
+		}
+		r.low, r.high = m.ip.To16(), m.ip.To16()
+	case *cidrMatcher:
+		r.low, r.high = m.ipNet.IP.To16(), make(net.IP, net.IPv6len)
+		mask := m.ipNet.Mask
+		if len(mask) == net.IPv4len {
+			mask = append(net.CIDRMask(96, 128)[:12:12], mask...)
+		}
+		r.low = r.low.Mask(mask)
+		for i := range r.high {
+			r.high[i] = r.low[i] | ^mask[i]
+		}
+	case *rangeMatcher:
+		r.low, r.high = m.low.To16(), m.high.To16()
+	default:
+		return nil
+	}
+	return r
+}
@@ -0,0 +1,54 @@
+package bypass
+
+import (
+	"testing"
+)
+
+func TestConflicts(t *testing.T) {
+	bp := NewBypasser(false,
+		NewMatcher("10.0.0.0/8"),
+		NewMatcher("10.1.0.0/16"),
+		NegateMatcher(NewMatcher("10.1.2.3")),
+		NewMatcher("192.168.1.0/24"),
+		NewMatcher("192.168.0.0-192.168.1.10"),
+		DenyMatcher(NewMatcher("192.168.1.128/25")),
+		NewMatcher("172.16.0.0/12"),
+		NewMatcher("2001:db8::/32"),
+		NewMatcher("2001:db8:1::1"),
+		NewMatcher("::ffff:10.2.0.0/112"),
+		NewMatcher("10.1.2.3:443"),
+		NewMatcher("*.example.com"),
+	)
+
+	expected := []string{
+		"redundant: cidr 10.0.0.0/8 and cidr 10.1.0.0/16",
+		"overlap: cidr 10.0.0.0/8 and !ip 10.1.2.3",
+		"redundant: cidr 10.0.0.0/8 and cidr ::ffff:10.2.0.0/112",
+		"overlap: cidr 10.1.0.0/16 and !ip 10.1.2.3",
+		"overlap: cidr 192.168.1.0/24 and deny cidr 192.168.1.128/25",
+		"redundant: cidr 2001:db8::/32 and ip 2001:db8:1::1",
+	}
+	conflicts := Conflicts(bp)
+	var got []string
+	for _, c := range conflicts {
+		got = append(got, c.String())
+	}
+	if len(got) != len(expected) {
+		t.Fatalf("expected %d conflicts, got %d:\n%v", len(expected), len(got), got)
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Errorf("#%d: expected %q, got %q", i, expected[i], got[i])
+		}
+	}
+	if c := conflicts[0]; c.Kind != ConflictRedundant || c.Matcher != bp.(*bypasser).matchers[0] || c.Other != bp.(*bypasser).matchers[1] {
+		t.Errorf("the conflict should name the matchers of the rules, got %+v", c)
+	}
+
+	if c := Conflicts(NewBypasserPatterns(false, "10.0.0.0/24", "10.0.1.0/24", "*.example.com")); len(c) != 0 {
+		t.Errorf("expected no conflict, got %v", c)
+	}
+	if c := Conflicts(Merge(bp)); c != nil {
+		t.Errorf("a merged bypass has no rules to analyze, got %v", c)
+	}
+}
@@ -0,0 +1,143 @@
+package bypass
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"slices"
+)
+
+// DebugDump writes a human-readable representation of the internal matching structures of the bypass to w,
+// its options, its rules by kind, its rule index and its match cache,
+// to verify how a rule set was built. It is meant for diagnostics only,
+// it holds the read lock while writing and has no cost unless called.
+func (bp *bypasser) DebugDump(w io.Writer) {
+	bp.mux.RLock()
+	defer bp.mux.RUnlock()
+
+	fmt.Fprintf(w, "options:\n")
+	fmt.Fprintf(w, "  reverse %v\n", bp.reversed)
+	if bp.splitReverse {
+		fmt.Fprintf(w, "  reverse-domains %v\n", bp.domainsReversed)
+	}
+	fmt.Fprintf(w, "  strip-port-ip %v\n", !bp.keepPortIP)
+	fmt.Fprintf(w, "  strip-port-domain %v\n", !bp.keepPortDomain)
+	fmt.Fprintf(w, "  reload %v\n", bp.period)
+
+	buckets := map[int][]int{}
+	fmt.Fprintf(w, "rules: %d\n", len(bp.matchers))
+	for i, m := range bp.matchers {
+		if m == nil {
+			fmt.Fprintf(w, "  #%d <nil>\n", i)
+			continue
+		}
+		fmt.Fprintf(w, "  #%d %s\n", i, m.String())
+		kind := matcherKind(m)
+		buckets[kind] = append(buckets[kind], i)
+
+		if exprs := groupExprs(m); exprs != nil {
+			for _, expr := range exprs {
+				fmt.Fprintf(w, "    | %s\n", expr)
+			}
+		}
+	}
+
+	fmt.Fprintf(w, "buckets:\n")
+	for _, kind := range []int{kindIP, kindDomain, kindAny} {
+		fmt.Fprintf(w, "  %s: %v\n", kindNames[kind], buckets[kind])
+	}
+
+	bp.dumpIndex(w)
+
+	if bp.cache != nil {
+		bp.cache.mux.Lock()
+		fmt.Fprintf(w, "cache: %d/%d entries, ttl %v\n", bp.cache.lru.Len(), bp.cache.size, bp.cache.ttl)
+		bp.cache.mux.Unlock()
+	}
+}
+
+var kindNames = map[int]string{
+	kindAny:    "any",
+	kindIP:     "ip",
+	kindDomain: "domain",
+}
+
+// groupExprs returns the patterns of a matcher grouping several rules, nil for other matchers.
+func groupExprs(matcher Matcher) []string {
+	switch m := matcher.(type) {
+	case *domainGroupMatcher:
+		return m.exprs
+	case *suffixTrieMatcher:
+		return m.exprs
+	default:
+		return nil
+	}
+}
+
+// dumpIndex writes the rule index of the bypass: the prefixes of its IP trie, the domains of its label tries
+// and the rules scanned in order, with the index of the rule found at each node.
+// The caller must hold bp.mux.
+func (bp *bypasser) dumpIndex(w io.Writer) {
+	x := bp.index
+	if x == nil {
+		fmt.Fprintf(w, "index: none\n")
+		return
+	}
+
+	fmt.Fprintf(w, "index:\n")
+	if x.ip != nil {
+		fmt.Fprintf(w, "  ip trie:\n")
+		dumpIPNode(w, x.ip.v4, make(net.IP, net.IPv4len), 0)
+		dumpIPNode(w, x.ip.v6, make(net.IP, net.IPv6len), 0)
+	}
+	for _, trie := range x.domains {
+		fmt.Fprintf(w, "  domain trie, ignore-case %v, idn %v:\n", trie.norm.fold, trie.norm.idn)
+		dumpLabelNode(w, trie.root, "")
+	}
+	fmt.Fprintf(w, "  scan: %v\n", x.scan)
+}
+
+// dumpIPNode writes the prefixes of the IP trie under the node, whose prefix is the first ones bits of ip.
+func dumpIPNode(w io.Writer, node *ipNode, ip net.IP, ones int) {
+	if node == nil {
+		return
+	}
+	if node.rule >= 0 {
+		fmt.Fprintf(w, "    %s/%d: #%d\n", ip.Mask(net.CIDRMask(ones, len(ip)*8)), ones, node.rule)
+	}
+	for bit, child := range node.children {
+		if child == nil {
+			continue
+		}
+		next := append(net.IP(nil), ip...)
+		if bit == 1 {
+			next[ones/8] |= 1 << (7 - uint(ones%8))
+		}
+		dumpIPNode(w, child, next, ones+1)
+	}
+}
+
+// dumpLabelNode writes the domains of the label trie under the node, whose domain is domain.
+func dumpLabelNode(w io.Writer, node *labelNode, domain string) {
+	if node.rules != [3]int{-1, -1, -1} {
+		fmt.Fprintf(w, "    %s:", domain)
+		for match, name := range []string{"suffix", "subdomains", "exact"} {
+			if rule := node.rules[match]; rule >= 0 {
+				fmt.Fprintf(w, " %s #%d", name, rule)
+			}
+		}
+		fmt.Fprintf(w, "\n")
+	}
+	labels := make([]string, 0, len(node.children))
+	for label := range node.children {
+		labels = append(labels, label)
+	}
+	slices.Sort(labels)
+	for _, label := range labels {
+		child := label
+		if domain != "" {
+			child += "." + domain
+		}
+		dumpLabelNode(w, node.children[label], child)
+	}
+}
@@ -0,0 +1,87 @@
+package bypass
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDebugDump(t *testing.T) {
+	bp := NewBypasser(false).(*bypasser)
+	config := `
+reverse true
+reverse-domains false
+reload 10s
+combine-domains true
+192.168.1.1
+10.0.0.0/8
+*.example.com
+.example.org
+`
+	if err := bp.Reload(strings.NewReader(config)); err != nil {
+		t.Fatal(err)
+	}
+	bp.SetMatchCache(16, time.Minute)
+
+	var buf bytes.Buffer
+	bp.DebugDump(&buf)
+	dump := buf.String()
+
+	for _, s := range []string{
+		"  reverse true\n",
+		"  reverse-domains false\n",
+		"  reload 10s\n",
+		"rules: 3\n",
+		"  #0 ip 192.168.1.1\n",
+		"  #1 cidr 10.0.0.0/8\n",
+		"  #2 domain {*.example.com,{example.org,**.example.org}}\n",
+		"    | *.example.com\n",
+		"    | {example.org,**.example.org}\n",
+		"  ip: [0 1]\n",
+		"  domain: [2]\n",
+		"  any: []\n",
+		"cache: 0/16 entries, ttl 1m0s\n",
+	} {
+		if !strings.Contains(dump, s) {
+			t.Errorf("dump should contain %q:\n%s", s, dump)
+		}
+	}
+}
+
+func TestDebugDumpIndex(t *testing.T) {
+	var sb strings.Builder
+	for i := 0; i < indexMin; i++ {
+		fmt.Fprintf(&sb, "10.%d.0.0/16\n.example%d.com\n", i, i)
+	}
+	sb.WriteString("2001:db8::/32\nwww.example.net\n/^api/\n")
+	bp := NewBypasser(false).(*bypasser)
+	if err := bp.Reload(strings.NewReader(sb.String())); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	bp.DebugDump(&buf)
+	dump := buf.String()
+	for _, s := range []string{
+		"index:\n  ip trie:\n    10.0.0.0/16: #0\n",
+		"    10.15.0.0/16: #30\n",
+		"    2001:db8::/32: #32\n",
+		"  domain trie, ignore-case false, idn false:\n",
+		"    example0.com: suffix #1\n",
+		"    www.example.net: exact #33\n",
+		"  scan: [34]\n",
+	} {
+		if !strings.Contains(dump, s) {
+			t.Errorf("dump should contain %q:\n%s", s, dump)
+		}
+	}
+
+	bp = NewBypasserPatterns(false, "192.168.1.1").(*bypasser)
+	buf.Reset()
+	bp.DebugDump(&buf)
+	if !strings.Contains(buf.String(), "index: none\n") {
+		t.Errorf("dump should report no index:\n%s", buf.String())
+	}
+}
@@ -0,0 +1,70 @@
+package bypass
+
+type deniedMatcher struct {
+	Matcher
+}
+
+// DenyMatcher creates a Matcher for a deny rule:
+// an address matched by the Matcher m is bypassed, whatever the other rules and the reversed flag.
+// It takes precedence over the negated rules as well.
+// In the config, such a rule is a pattern with the 'deny' prefix, e.g. 'deny ads.example.com'.
+func DenyMatcher(m Matcher) Matcher {
+	return &deniedMatcher{
+		Matcher: m,
+	}
+}
+
+// AllowMatcher creates a Matcher for an allow rule:
+// an address matched by the Matcher m is not bypassed, unless a deny rule matches it.
+// It is the same as NegateMatcher. In the config, such a rule is a pattern with the 'allow' prefix,
+// e.g. 'allow www.example.com', or with a leading '!'.
+func AllowMatcher(m Matcher) Matcher {
+	return NegateMatcher(m)
+}
+
+func (m *deniedMatcher) Match(v string) bool {
+	if m == nil || m.Matcher == nil {
+		return false
+	}
+	return m.Matcher.Match(v)
+}
+
+func (m *deniedMatcher) String() string {
+	return "deny " + m.Matcher.String()
+}
+
+func isDenied(m Matcher) bool {
+	_, ok := m.(*deniedMatcher)
+	return ok
+}
+
+// the precedence of the rules, a matching rule of a higher rank decides over the ones of lower ranks.
+const (
+	rankPlain   = iota
+	rankNegated // the negated and allow rules
+	rankDenied  // the deny rules
+)
+
+func matcherRank(m Matcher) int {
+	switch {
+	case isDenied(m):
+		return rankDenied
+	case isNegated(m):
+		return rankNegated
+	default:
+		return rankPlain
+	}
+}
+
+// maxRank returns the highest rank of the rules of the bypass.
+// The caller must hold bp.mux.
+func (bp *bypasser) maxRank() int {
+	switch {
+	case bp.denials > 0:
+		return rankDenied
+	case bp.negations > 0:
+		return rankNegated
+	default:
+		return rankPlain
+	}
+}
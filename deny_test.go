@@ -0,0 +1,76 @@
+package bypass
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAllowDeny(t *testing.T) {
+	for i, tc := range []struct {
+		config   string
+		addr     string
+		bypassed bool
+	}{
+		// deny wins over allow, whatever their order
+		{"allow *.example.com\ndeny ads.example.com", "ads.example.com", true},
+		{"deny ads.example.com\nallow *.example.com", "ads.example.com", true},
+		{"allow *.example.com\ndeny ads.example.com", "www.example.com", false},
+		{"deny ads.example.com\n!ads.example.com", "ads.example.com", true},
+
+		// both take precedence over the other rules and are not reversed
+		{"*.example.com\nallow www.example.com", "www.example.com", false},
+		{"*.example.com\nallow www.example.com", "api.example.com", true},
+		{"reverse true\n*.example.com\ndeny ads.example.com", "ads.example.com", true},
+		{"reverse true\n*.example.com\ndeny ads.example.com", "www.example.com", false},
+		{"reverse true\n*.example.com\nallow www.example.org", "www.example.org", false},
+		{"reverse true\n*.example.com\nallow www.example.org", "api.example.org", true},
+
+		// the lines without a prefix keep their behavior
+		{"*.example.com\ndeny 10.0.0.0/8", "www.example.com", true},
+		{"*.example.com\ndeny 10.0.0.0/8", "10.1.2.3", true},
+		{"*.example.com\ndeny 10.0.0.0/8", "192.168.1.1", false},
+		{"deny *.example.com:443", "www.example.com:443", true},
+		{"deny *.example.com:443", "www.example.com:80", false},
+	} {
+		bp := NewBypasser(false).(*bypasser)
+		if err := bp.Reload(strings.NewReader(tc.config)); err != nil {
+			t.Fatal(err)
+		}
+		if bp.Bypass(tc.addr) != tc.bypassed {
+			t.Errorf("#%d test failed: %q, %s", i, tc.config, tc.addr)
+		}
+	}
+}
+
+func TestDenyMatcher(t *testing.T) {
+	deny := DenyMatcher(NewMatcher("ads.example.com"))
+	bp := NewBypasser(true, AllowMatcher(NewMatcher("*.example.com")), deny).(*bypasser)
+
+	bypassed, m := bp.BypassMatch("ads.example.com")
+	if !bypassed || m != deny {
+		t.Errorf("the deny rule should decide, got %v, %v", bypassed, m)
+	}
+	if s := deny.String(); s != "deny domain ads.example.com" {
+		t.Errorf("unexpected String %q", s)
+	}
+	if bp.WouldFlip("ads.example.com") {
+		t.Errorf("a deny rule is not flipped by the reversed flag")
+	}
+
+	if !bp.RemoveMatcher("deny ads.example.com") || bp.Bypass("ads.example.com") {
+		t.Errorf("the deny rule should be removed by its pattern")
+	}
+	if bp.denials != 0 {
+		t.Errorf("the deny rules should be counted")
+	}
+}
+
+func TestAllowDenyConfig(t *testing.T) {
+	bp := NewBypasser(false).(*bypasser)
+	if err := bp.Reload(strings.NewReader("allow *.example.com\ndeny ads.example.com\ndeny [bad\n")); err == nil {
+		t.Errorf("expected an error for the malformed deny rule")
+	}
+	if got, want := bp.PrunedConfig(0), "reverse false\ndefault proxy\n!*.example.com\ndeny ads.example.com\n"; got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
@@ -0,0 +1,154 @@
+package bypass
+
+import (
+	"time"
+)
+
+// timeNow returns the current time, it is replaced by tests to control the expiry of the rules.
+var timeNow = time.Now
+
+type expiringMatcher struct {
+	Matcher
+	expiry time.Time
+}
+
+// ExpiringMatcher creates a Matcher matching as the Matcher m does until the expiry time, and nothing afterwards,
+// such as a temporary rule pushed at runtime with AddMatcher. A zero expiry never expires.
+// The expired rules are removed from a bypass by PruneExpired, see SetExpirySweep to prune them periodically.
+//
+// To expire a negated or a deny rule, the expiring matcher is negated or denied:
+// NegateMatcher(ExpiringMatcher(m, expiry)) is a temporary exception to the rules.
+func ExpiringMatcher(m Matcher, expiry time.Time) Matcher {
+	if m == nil {
+		return nil
+	}
+	return &expiringMatcher{
+		Matcher: m,
+		expiry:  expiry,
+	}
+}
+
+func (m *expiringMatcher) Match(v string) bool {
+	if m == nil || m.expired(timeNow()) {
+		return false
+	}
+	return m.Matcher.Match(v)
+}
+
+func (m *expiringMatcher) expired(now time.Time) bool {
+	return !m.expiry.IsZero() && !now.Before(m.expiry)
+}
+
+func (m *expiringMatcher) String() string {
+	if m.expiry.IsZero() {
+		return m.Matcher.String()
+	}
+	return m.Matcher.String() + " (expires " + m.expiry.UTC().Format(time.RFC3339) + ")"
+}
+
+// matcherExpiry returns the expiry of the rule of the matcher m, zero if it does not expire.
+func matcherExpiry(matcher Matcher) time.Time {
+	switch m := matcher.(type) {
+	case *expiringMatcher:
+		return m.expiry
+	case *negatedMatcher:
+		return matcherExpiry(m.Matcher)
+	case *deniedMatcher:
+		return matcherExpiry(m.Matcher)
+	default:
+		return time.Time{}
+	}
+}
+
+// updateExpiry sets the earliest expiry of the rules of the bypass, its rules doing lookups
+// and the TTL of its cached decisions, see SetResultCache.
+// The caller must hold bp.mux.
+func (bp *bypasser) updateExpiry() {
+	bp.expiry = time.Time{}
+	bp.resultTTL = 0
+	bp.lookups = nil
+	for _, m := range bp.matchers {
+		if expiry := matcherExpiry(m); !expiry.IsZero() && (bp.expiry.IsZero() || expiry.Before(bp.expiry)) {
+			bp.expiry = expiry
+		}
+		for _, lookup := range lookupMatchers(m) {
+			if ttl := lookup.lookupTTL(); ttl > 0 && (bp.resultTTL == 0 || ttl < bp.resultTTL) {
+				bp.resultTTL = ttl
+			}
+			bp.lookups = append(bp.lookups, lookup)
+		}
+	}
+}
+
+// hasExpired reports whether a rule of the bypass has expired and is not pruned yet,
+// the decisions cached by SetResultCache may then be out of date.
+// The caller must hold bp.mux.
+func (bp *bypasser) hasExpired() bool {
+	return !bp.expiry.IsZero() && !timeNow().Before(bp.expiry)
+}
+
+// PruneExpired removes the expired rules of the bypass, see ExpiringMatcher, and returns the number of the removed rules.
+// As AddMatcher and RemoveMatcher do, it changes the rules at runtime until the next Reload.
+func (bp *bypasser) PruneExpired() int {
+	bp.mux.Lock()
+	defer bp.mux.Unlock()
+
+	if !bp.hasExpired() {
+		return 0
+	}
+
+	now := timeNow()
+	matchers := make([]Matcher, 0, len(bp.matchers))
+	hits := make([]uint64, 0, len(bp.hits))
+	for i, m := range bp.matchers {
+		if expiry := matcherExpiry(m); !expiry.IsZero() && !now.Before(expiry) {
+			bp.countRank(m, -1)
+			continue
+		}
+		matchers = append(matchers, m)
+		hits = append(hits, bp.hits[i])
+	}
+
+	n := len(bp.matchers) - len(matchers)
+	bp.matchers, bp.hits = matchers, hits
+	bp.index = newRuleIndex(bp.matchers)
+	bp.updateExpiry()
+	bp.resetResults()
+	bp.rulesChanged()
+	return n
+}
+
+// SetExpirySweep enables the periodic pruning of the expired rules: every interval, PruneExpired is called.
+// An expired rule matches nothing already, the sweep keeps the rules from piling up,
+// e.g. the temporary exceptions pushed by a control plane.
+// The sweep stops when the bypass is stopped. A non-positive interval disables it.
+func (bp *bypasser) SetExpirySweep(interval time.Duration) {
+	bp.mux.Lock()
+	defer bp.mux.Unlock()
+
+	if bp.sweepStop != nil {
+		close(bp.sweepStop)
+		bp.sweepStop = nil
+	}
+	if interval <= 0 {
+		return
+	}
+
+	stop := make(chan struct{})
+	bp.sweepStop = stop
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				bp.PruneExpired()
+			case <-stop:
+				return
+			case <-bp.stopped:
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,128 @@
+package bypass
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a clock set by the tests, replacing timeNow until the end of the test.
+type fakeClock struct {
+	now time.Time
+	mux sync.Mutex
+}
+
+func newFakeClock(t *testing.T) *fakeClock {
+	c := &fakeClock{now: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)}
+	timeNow = c.Now
+	t.Cleanup(func() { timeNow = time.Now })
+	return c
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func TestExpiringMatcher(t *testing.T) {
+	clock := newFakeClock(t)
+	m := ExpiringMatcher(NewMatcher("*.example.com"), clock.Now().Add(time.Minute))
+
+	if !m.Match("www.example.com") || m.Match("example.org") {
+		t.Errorf("an expiring matcher should match as its matcher does before its expiry")
+	}
+	if s := m.String(); s != "domain *.example.com (expires 2024-01-01T12:01:00Z)" {
+		t.Errorf("unexpected String %q", s)
+	}
+	clock.Advance(time.Minute)
+	if m.Match("www.example.com") {
+		t.Errorf("an expired matcher should match nothing")
+	}
+
+	forever := ExpiringMatcher(NewMatcher("*.example.com"), time.Time{})
+	clock.Advance(100 * 365 * 24 * time.Hour)
+	if !forever.Match("www.example.com") || forever.String() != "domain *.example.com" {
+		t.Errorf("a zero expiry should never expire")
+	}
+	if ExpiringMatcher(nil, time.Time{}) != nil {
+		t.Errorf("a nil matcher should give a nil matcher")
+	}
+}
+
+func TestPruneExpired(t *testing.T) {
+	clock := newFakeClock(t)
+	bp := NewBypasser(false, NewMatcher("*.example.com")).(*bypasser)
+	bp.SetResultCache(16)
+
+	ban := ExpiringMatcher(NewMatcher("192.0.2.1"), clock.Now().Add(time.Minute))
+	bp.AddMatcher(ban)
+	bp.AddMatcher(NegateMatcher(ExpiringMatcher(NewMatcher("www.example.com"), clock.Now().Add(2*time.Minute))))
+
+	for addr, bypassed := range map[string]bool{
+		"192.0.2.1:443":   true,
+		"www.example.com": false,
+		"api.example.com": true,
+	} {
+		if bp.Bypass(addr) != bypassed {
+			t.Errorf("%s: expected %v", addr, bypassed)
+		}
+	}
+	if n := bp.PruneExpired(); n != 0 {
+		t.Errorf("no rule should be pruned before its expiry, got %d", n)
+	}
+
+	// an expired rule does not apply, even to the decisions cached before its expiry
+	clock.Advance(time.Minute)
+	if bp.Bypass("192.0.2.1:443") {
+		t.Errorf("the expired rule should not apply")
+	}
+	if !bp.RemoveMatcher(ban.String()) {
+		t.Errorf("the expiring rule should be removed by its String")
+	}
+	bp.AddMatcher(ban)
+
+	if n := bp.PruneExpired(); n != 1 || bp.Len() != 2 {
+		t.Errorf("expected 1 rule pruned out of 3, got %d, %d left", n, bp.Len())
+	}
+	if bp.Bypass("www.example.com") {
+		t.Errorf("the negated rule should apply until its expiry")
+	}
+	clock.Advance(time.Minute)
+	if !bp.Bypass("www.example.com") {
+		t.Errorf("the expired negated rule should not apply")
+	}
+	if n := bp.PruneExpired(); n != 1 || bp.Len() != 1 || bp.negations != 0 {
+		t.Errorf("expected the negated rule pruned, got %d, %d left", n, bp.Len())
+	}
+	if !bp.expiry.IsZero() {
+		t.Errorf("no rule should expire any longer, got %v", bp.expiry)
+	}
+}
+
+func TestExpirySweep(t *testing.T) {
+	clock := newFakeClock(t)
+	bp := NewBypasser(false).(*bypasser)
+	defer bp.Stop()
+	bp.AddMatcher(ExpiringMatcher(NewMatcher("192.0.2.1"), clock.Now().Add(time.Minute)))
+	bp.SetExpirySweep(time.Millisecond)
+
+	time.Sleep(10 * time.Millisecond)
+	if bp.Len() != 1 {
+		t.Fatalf("the rule should not be pruned before its expiry")
+	}
+	clock.Advance(time.Minute)
+	for i := 0; i < 100 && bp.Len() > 0; i++ {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if bp.Len() != 0 {
+		t.Errorf("the expired rule should be pruned by the sweep")
+	}
+	bp.SetExpirySweep(0)
+}
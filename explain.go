@@ -0,0 +1,97 @@
+package bypass
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Explain returns a human-readable trace of the decision of the bypass on the address addr,
+// to troubleshoot why an address is bypassed or not: the address as matched by the rules,
+// whether each rule matches it, the rule deciding on it and how the reversed flag applies.
+// It is a dry run: the hit counters and the result cache are not updated, and the decision is not logged.
+func (bp *bypasser) Explain(addr string) string {
+	if bp == nil {
+		return ""
+	}
+	var b strings.Builder
+	if addr == "" {
+		b.WriteString("empty address: not bypassed\n")
+		return b.String()
+	}
+	t := newTarget(addr)
+	bp.prefetch(t)
+
+	bp.mux.RLock()
+	defer bp.mux.RUnlock()
+
+	fmt.Fprintf(&b, "address: %s\n", t.addr)
+	if t.port > 0 {
+		fmt.Fprintf(&b, "host: %s, port %d\n", t.host, t.port)
+	} else {
+		fmt.Fprintf(&b, "host: %s\n", t.host)
+	}
+
+	kind, reversed, flag := kindAny, bp.reversed, "reverse"
+	if bp.splitReverse {
+		kind = hostKind(t.host)
+		if kind == kindDomain {
+			reversed, flag = bp.domainsReversed, "reverse-domains"
+		}
+		fmt.Fprintf(&b, "kind: %s\n", kindNames[kind])
+	}
+
+	if len(bp.matchers) == 0 {
+		fmt.Fprintf(&b, "rules: none\n")
+		bypassed := reversed && bp.reverseEmpty
+		fmt.Fprintf(&b, "no rule, %s %v, reverse-empty %v: %s\n", flag, reversed, bp.reverseEmpty, bypassedText(bypassed))
+		return b.String()
+	}
+
+	fmt.Fprintf(&b, "rules: %d\n", len(bp.matchers))
+	for i, m := range bp.matchers {
+		if m == nil {
+			continue
+		}
+		result := "no match"
+		if k := matcherKind(m); kind != kindAny && k != kindAny && k != kind {
+			result = "skipped, not a " + kindNames[kind] + " rule"
+		} else if bp.matchRule(m, t, kind) {
+			result = "matched"
+		}
+		fmt.Fprintf(&b, "  #%d %s: %s\n", i, m.String(), result)
+	}
+
+	var i int
+	if bp.specificityOrder {
+		i = bp.matchSpecific(t, kind)
+	} else {
+		i = bp.match(t, kind)
+	}
+	if i < 0 {
+		fmt.Fprintf(&b, "no rule matched, %s %v: %s\n", flag, reversed, bypassedText(reversed))
+		return b.String()
+	}
+
+	m := bp.matchers[i]
+	fmt.Fprintf(&b, "decided by #%d %s", i, m.String())
+	if bp.specificityOrder {
+		fmt.Fprintf(&b, ", specificity %d", Specificity(m))
+	}
+	b.WriteString("\n")
+	switch matcherRank(m) {
+	case rankDenied:
+		fmt.Fprintf(&b, "deny rule, whatever %s %v: %s\n", flag, reversed, bypassedText(true))
+	case rankNegated:
+		fmt.Fprintf(&b, "negated rule, whatever %s %v: %s\n", flag, reversed, bypassedText(false))
+	default:
+		fmt.Fprintf(&b, "matched, %s %v: %s\n", flag, reversed, bypassedText(!reversed))
+	}
+	return b.String()
+}
+
+func bypassedText(bypassed bool) string {
+	if bypassed {
+		return "bypassed"
+	}
+	return "not bypassed"
+}
@@ -0,0 +1,83 @@
+package bypass
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestExplain(t *testing.T) {
+	bp := NewBypasser(false,
+		NewMatcher("10.0.0.0/8"),
+		NewMatcher("*.example.com"),
+		NegateMatcher(NewMatcher("secure.example.com")),
+	).(*bypasser)
+
+	for _, tc := range []struct {
+		addr     string
+		reversed bool
+		contains []string
+	}{
+		{"www.example.com:443", false, []string{
+			"address: www.example.com:443\n",
+			"host: www.example.com, port 443\n",
+			"  #0 cidr 10.0.0.0/8: no match\n",
+			"  #1 domain *.example.com: matched\n",
+			"decided by #1 domain *.example.com\n",
+			"matched, reverse false: bypassed\n",
+		}},
+		{"www.example.com:443", true, []string{
+			"decided by #1 domain *.example.com\n",
+			"matched, reverse true: not bypassed\n",
+		}},
+		{"secure.example.com", false, []string{
+			"  #1 domain *.example.com: matched\n",
+			"  #2 !domain secure.example.com: matched\n",
+			"decided by #2 !domain secure.example.com\n",
+			"negated rule, whatever reverse false: not bypassed\n",
+		}},
+		{"example.org", true, []string{
+			"host: example.org\n",
+			"no rule matched, reverse true: bypassed\n",
+		}},
+	} {
+		bp.reversed = tc.reversed
+		s := bp.Explain(tc.addr)
+		for _, want := range tc.contains {
+			if !strings.Contains(s, want) {
+				t.Errorf("%s, reversed %v: expected %q in the explanation:\n%s", tc.addr, tc.reversed, want, s)
+			}
+		}
+		if bypassed := bp.Bypass(tc.addr); !strings.HasSuffix(s, ": "+bypassedText(bypassed)+"\n") {
+			t.Errorf("%s, reversed %v: the explanation should end with the decision %v:\n%s", tc.addr, tc.reversed, bypassed, s)
+		}
+	}
+
+	// a dry run
+	hits := bp.hits[1]
+	bp.Explain("www.example.com")
+	if bp.hits[1] != hits {
+		t.Errorf("the hit counters should not be updated")
+	}
+
+	bp = NewBypasser(false, NewMatcher("10.0.0.0/8")).(*bypasser)
+	if err := bp.Reload(strings.NewReader("reverse-domains true\n10.0.0.0/8\n")); err != nil {
+		t.Fatal(err)
+	}
+	s := bp.Explain("example.org")
+	for _, want := range []string{
+		"kind: domain\n",
+		"  #0 cidr 10.0.0.0/8: skipped, not a domain rule\n",
+		"no rule matched, reverse-domains true: bypassed\n",
+	} {
+		if !strings.Contains(s, want) {
+			t.Errorf("expected %q in the explanation:\n%s", want, s)
+		}
+	}
+
+	if s := NewBypasser(true).(*bypasser).Explain("example.org"); !strings.Contains(s, "no rule, reverse true, reverse-empty false: not bypassed\n") {
+		t.Errorf("unexpected explanation of an empty bypass:\n%s", s)
+	}
+	if s := bp.Explain(""); s != "empty address: not bypassed\n" {
+		t.Errorf("unexpected explanation of an empty address: %q", s)
+	}
+}
@@ -0,0 +1,133 @@
+// Package fswatch live reloads a bypass from its config file whenever the file changes on disk,
+// it keeps the fsnotify dependency out of the bypass package.
+package fswatch
+
+import (
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/go-gost/bypass"
+)
+
+var (
+	// Debounce is the delay after the last of a burst of changes before the reload,
+	// editors often write a file in several steps.
+	Debounce = 100 * time.Millisecond
+	// PollInterval is the interval for checking the file when the file system can not be watched,
+	// and for checking whether the bypass is stopped.
+	PollInterval = 1 * time.Second
+)
+
+// WatchFile reloads the bypass from the config file at path each time the file is written, created or renamed,
+// until ctx is cancelled or the bypass is stopped.
+// The directory of the file is watched, so that the editors replacing the file are followed.
+// If the file system can not be watched, it falls back to polling the modification time and size of the file.
+// The errors of the reloads are logged and do not stop the watch.
+// The watch returns as soon as the bypass is stopped if it has a Done channel, as the ones of the bypass package,
+// otherwise it notices Stop within PollInterval.
+func WatchFile(ctx context.Context, bp bypass.ReloadableBypasser, path string) error {
+	path = filepath.Clean(path)
+
+	watcher, err := fsnotify.NewWatcher()
+	if err == nil {
+		if err = watcher.Add(filepath.Dir(path)); err != nil {
+			watcher.Close()
+		}
+	}
+	if err != nil {
+		log.Printf("fswatch: watch %s: %v, polling instead", path, err)
+		return poll(ctx, bp, path)
+	}
+	defer watcher.Close()
+
+	ticker := time.NewTicker(PollInterval)
+	defer ticker.Stop()
+
+	done := stopped(bp)
+	var debounce <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-done:
+			return nil
+		case <-ticker.C:
+			if bp.Stopped() {
+				return nil
+			}
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if filepath.Clean(event.Name) == path && event.Has(fsnotify.Write|fsnotify.Create|fsnotify.Rename) {
+				debounce = time.After(Debounce)
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			log.Printf("fswatch: watch %s: %v", path, err)
+		case <-debounce:
+			debounce = nil
+			reload(bp, path)
+		}
+	}
+}
+
+// poll reloads the bypass from the file at path each time its modification time or size changes.
+func poll(ctx context.Context, bp bypass.ReloadableBypasser, path string) error {
+	ticker := time.NewTicker(PollInterval)
+	defer ticker.Stop()
+
+	done := stopped(bp)
+	last, _ := os.Stat(path)
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-done:
+			return nil
+		case <-ticker.C:
+		}
+		if bp.Stopped() {
+			return nil
+		}
+
+		fi, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		if last == nil || !fi.ModTime().Equal(last.ModTime()) || fi.Size() != last.Size() {
+			reload(bp, path)
+		}
+		last = fi
+	}
+}
+
+// stopped returns the channel closed once the bypass is stopped, nil if it has none.
+func stopped(bp bypass.ReloadableBypasser) <-chan struct{} {
+	if d, ok := bp.(interface{ Done() <-chan struct{} }); ok {
+		return d.Done()
+	}
+	return nil
+}
+
+func reload(bp bypass.ReloadableBypasser, path string) {
+	if bp.Stopped() {
+		return
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		log.Printf("fswatch: reload %s: %v", path, err)
+		return
+	}
+	defer f.Close()
+
+	if err := bp.Reload(f); err != nil {
+		log.Printf("fswatch: reload %s: %v", path, err)
+	}
+}
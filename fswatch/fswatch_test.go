@@ -0,0 +1,119 @@
+package fswatch
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-gost/bypass"
+)
+
+func waitBypass(t *testing.T, bp bypass.Bypasser, addr string, bypassed bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for bp.Bypass(addr) != bypassed {
+		if time.Now().After(deadline) {
+			t.Fatalf("%s should be bypassed: %v", addr, bypassed)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func testWatch(t *testing.T, watch func(ctx context.Context, bp bypass.ReloadableBypasser, path string) error) {
+	defer func(d, p time.Duration) { Debounce, PollInterval = d, p }(Debounce, PollInterval)
+	Debounce, PollInterval = 10*time.Millisecond, 20*time.Millisecond
+
+	path := filepath.Join(t.TempDir(), "bypass.txt")
+	if err := os.WriteFile(path, []byte("*.example.com\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	bp := bypass.NewBypasserPatterns(false, "*.example.com").(bypass.ReloadableBypasser)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- watch(ctx, bp, path)
+	}()
+	// let the watch start
+	time.Sleep(50 * time.Millisecond)
+
+	if err := os.WriteFile(path, []byte("*.example.org\n192.168.0.0/16\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	waitBypass(t, bp, "www.example.org", true)
+	waitBypass(t, bp, "www.example.com", false)
+
+	// an editor replacing the file
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte("*.example.net\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		t.Fatal(err)
+	}
+	waitBypass(t, bp, "www.example.net", true)
+	waitBypass(t, bp, "www.example.org", false)
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != context.Canceled {
+			t.Errorf("expected %v, got %v", context.Canceled, err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the watch did not return after cancel")
+	}
+}
+
+func TestWatchFile(t *testing.T) {
+	testWatch(t, WatchFile)
+}
+
+func TestWatchFilePoll(t *testing.T) {
+	testWatch(t, poll)
+}
+
+func TestWatchFileStop(t *testing.T) {
+	defer func(p time.Duration) { PollInterval = p }(PollInterval)
+	PollInterval = 10 * time.Millisecond
+
+	path := filepath.Join(t.TempDir(), "bypass.txt")
+	if err := os.WriteFile(path, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	bp := bypass.NewBypasser(false).(bypass.ReloadableBypasser)
+	bp.Stop()
+	if err := WatchFile(context.Background(), bp, path); err != nil {
+		t.Errorf("expected no error once stopped, got %v", err)
+	}
+}
+
+func TestWatchFileStopPromptly(t *testing.T) {
+	defer func(p time.Duration) { PollInterval = p }(PollInterval)
+	PollInterval = time.Hour
+
+	path := filepath.Join(t.TempDir(), "bypass.txt")
+	if err := os.WriteFile(path, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, watch := range []func(ctx context.Context, bp bypass.ReloadableBypasser, path string) error{WatchFile, poll} {
+		bp := bypass.NewBypasser(false).(bypass.ReloadableBypasser)
+		done := make(chan error, 1)
+		go func() {
+			done <- watch(context.Background(), bp, path)
+		}()
+		time.Sleep(20 * time.Millisecond)
+
+		bp.Stop()
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Errorf("expected no error once stopped, got %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("the watch did not return after stop")
+		}
+	}
+}
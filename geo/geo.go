@@ -0,0 +1,104 @@
+// Package geo matches the IP addresses by their location or their autonomous system in a MaxMind database,
+// it keeps the geoip2 dependency out of the bypass package.
+package geo
+
+import (
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/go-gost/bypass"
+	"github.com/oschwald/geoip2-golang"
+)
+
+// The fields of the database matched by a GeoMatcher.
+const (
+	// FieldCountry is the ISO 3166-1 code of the country, such as 'DE', in a Country or City database.
+	FieldCountry = "country"
+	// FieldContinent is the code of the continent, such as 'EU', in a Country or City database.
+	FieldContinent = "continent"
+	// FieldASN is the autonomous system number, such as '13335' or 'AS13335', in an ASN database.
+	FieldASN = "asn"
+)
+
+// reader looks up the IP addresses in a database, as geoip2.Reader does.
+type reader interface {
+	Country(ip net.IP) (*geoip2.Country, error)
+	ASN(ip net.IP) (*geoip2.ASN, error)
+}
+
+type geoMatcher struct {
+	db    reader
+	field string
+	value string
+	asn   uint
+}
+
+// GeoMatcher creates a Matcher for the IP addresses whose field in the database db has the given value,
+// e.g. the field 'country' and the value 'DE' match the IP addresses located in Germany.
+// The country and continent codes are compared case-insensitively.
+// An address which is not found in the database, or a domain, is not matched.
+// It returns nil if db is nil, the field is unknown or the value of the asn field is not a valid ASN.
+func GeoMatcher(db *geoip2.Reader, field, value string) bypass.Matcher {
+	if db == nil {
+		return nil
+	}
+	m := newGeoMatcher(db, field, value)
+	if m == nil {
+		return nil
+	}
+	return m
+}
+
+func newGeoMatcher(db reader, field, value string) *geoMatcher {
+	m := &geoMatcher{
+		db:    db,
+		field: strings.ToLower(field),
+		value: value,
+	}
+	switch m.field {
+	case FieldCountry, FieldContinent:
+		m.value = strings.ToUpper(value)
+	case FieldASN:
+		n, err := strconv.ParseUint(strings.TrimPrefix(strings.ToUpper(value), "AS"), 10, 32)
+		if err != nil || n == 0 {
+			return nil
+		}
+		m.asn = uint(n)
+	default:
+		return nil
+	}
+	return m
+}
+
+func (m *geoMatcher) Match(ip string) bool {
+	if m == nil {
+		return false
+	}
+	// the same forms of the addresses as the IP rules, the zone of an IPv6 address does not change its location
+	addr, _ := bypass.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+
+	switch m.field {
+	case FieldCountry, FieldContinent:
+		record, err := m.db.Country(addr)
+		if err != nil {
+			return false
+		}
+		if m.field == FieldContinent {
+			return record.Continent.Code == m.value
+		}
+		return record.Country.IsoCode == m.value
+	case FieldASN:
+		record, err := m.db.ASN(addr)
+		return err == nil && record.AutonomousSystemNumber == m.asn
+	default:
+		return false
+	}
+}
+
+func (m *geoMatcher) String() string {
+	return "geo " + m.field + "=" + m.value
+}
@@ -0,0 +1,122 @@
+package geo
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/go-gost/bypass"
+	"github.com/oschwald/geoip2-golang"
+)
+
+// fakeReader locates the IP addresses of a few networks.
+type fakeReader struct{}
+
+var fakeNetworks = []struct {
+	cidr      string
+	country   string
+	continent string
+	asn       uint
+}{
+	{"192.0.2.0/24", "DE", "EU", 3320},
+	{"198.51.100.0/24", "US", "NA", 13335},
+	{"2001:db8::/32", "JP", "AS", 2497},
+}
+
+func (fakeReader) lookup(ip net.IP) (country, continent string, asn uint, err error) {
+	for _, n := range fakeNetworks {
+		if _, ipNet, _ := net.ParseCIDR(n.cidr); ipNet.Contains(ip) {
+			return n.country, n.continent, n.asn, nil
+		}
+	}
+	return "", "", 0, nil
+}
+
+func (r fakeReader) Country(ip net.IP) (*geoip2.Country, error) {
+	country, continent, _, err := r.lookup(ip)
+	var record geoip2.Country
+	record.Country.IsoCode = country
+	record.Continent.Code = continent
+	return &record, err
+}
+
+func (r fakeReader) ASN(ip net.IP) (*geoip2.ASN, error) {
+	_, _, asn, err := r.lookup(ip)
+	return &geoip2.ASN{AutonomousSystemNumber: asn}, err
+}
+
+type errReader struct{}
+
+func (errReader) Country(ip net.IP) (*geoip2.Country, error) {
+	return nil, errors.New("invalid database")
+}
+
+func (errReader) ASN(ip net.IP) (*geoip2.ASN, error) {
+	return nil, errors.New("invalid database")
+}
+
+func TestGeoMatcher(t *testing.T) {
+	for _, tc := range []struct {
+		field, value string
+		addr         string
+		matched      bool
+	}{
+		{"country", "DE", "192.0.2.1", true},
+		{"country", "de", "192.0.2.1", true},
+		{"country", "DE", "::ffff:192.0.2.1", true},
+		{"country", "DE", "198.51.100.1", false},
+		{"country", "JP", "2001:db8::1%eth0", true},
+		{"country", "DE", "192.000.002.001", true},
+		{"country", "DE", "::FFFF:192.0.2.1", true},
+		{"country", "JP", "2001:DB8::1%eth0", true},
+		{"country", "DE", "203.0.113.1", false},
+		{"country", "DE", "example.de", false},
+		{"continent", "EU", "192.0.2.1", true},
+		{"continent", "NA", "192.0.2.1", false},
+		{"asn", "13335", "198.51.100.1", true},
+		{"ASN", "AS13335", "198.51.100.1", true},
+		{"asn", "as2497", "2001:db8::1", true},
+		{"asn", "3320", "198.51.100.1", false},
+	} {
+		m := newGeoMatcher(fakeReader{}, tc.field, tc.value)
+		if m == nil {
+			t.Fatalf("%s=%s: expected a matcher", tc.field, tc.value)
+		}
+		if matched := m.Match(tc.addr); matched != tc.matched {
+			t.Errorf("%s=%s %s: expected %v, got %v", tc.field, tc.value, tc.addr, tc.matched, matched)
+		}
+	}
+
+	for _, tc := range []struct{ field, value string }{
+		{"city", "Berlin"},
+		{"asn", "cloudflare"},
+		{"asn", "0"},
+	} {
+		if m := newGeoMatcher(fakeReader{}, tc.field, tc.value); m != nil {
+			t.Errorf("%s=%s: expected no matcher, got %v", tc.field, tc.value, m)
+		}
+	}
+	if GeoMatcher(nil, "country", "DE") != nil {
+		t.Errorf("a nil database should give a nil matcher")
+	}
+	if newGeoMatcher(errReader{}, "country", "DE").Match("192.0.2.1") {
+		t.Errorf("a failed lookup should not match")
+	}
+
+	if s := newGeoMatcher(fakeReader{}, "Country", "de").String(); s != "geo country=DE" {
+		t.Errorf("unexpected String %q", s)
+	}
+}
+
+func TestGeoMatcherBypass(t *testing.T) {
+	bp := bypass.NewBypasser(false, newGeoMatcher(fakeReader{}, "country", "DE"), bypass.NewMatcher("*.example.com"))
+	for addr, bypassed := range map[string]bool{
+		"192.0.2.1:443":    true,
+		"198.51.100.1:443": false,
+		"www.example.com":  true,
+	} {
+		if bp.Bypass(addr) != bypassed {
+			t.Errorf("%s: expected %v", addr, bypassed)
+		}
+	}
+}
@@ -1,5 +1,27 @@
 module github.com/go-gost/bypass
 
-go 1.13
+go 1.21.0
 
-require github.com/gobwas/glob v0.2.3
+require (
+	github.com/fsnotify/fsnotify v1.9.0
+	github.com/gobwas/glob v0.2.3
+	github.com/oschwald/geoip2-golang v1.11.0
+	github.com/prometheus/client_golang v1.21.1
+	golang.org/x/net v0.35.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require (
+	github.com/beorn7/perks v1.0.1 // indirect
+	github.com/cespare/xxhash/v2 v2.3.0 // indirect
+	github.com/kr/text v0.2.0 // indirect
+	github.com/kylelemons/godebug v1.1.0 // indirect
+	github.com/munnerz/goautoneg v0.0.0-20191010083416-a7dc8b61c822 // indirect
+	github.com/oschwald/maxminddb-golang v1.13.0 // indirect
+	github.com/prometheus/client_model v0.6.1 // indirect
+	github.com/prometheus/common v0.63.0 // indirect
+	github.com/prometheus/procfs v0.16.0 // indirect
+	golang.org/x/sys v0.30.0 // indirect
+	golang.org/x/text v0.22.0 // indirect
+	google.golang.org/protobuf v1.36.5 // indirect
+)
@@ -0,0 +1,74 @@
+package bypass
+
+import (
+	"strings"
+	"sync/atomic"
+)
+
+// PrunedConfig returns a config containing the options of the bypass
+// and only the rules hit at least minHits times, which can be loaded by Reload.
+// It helps reducing a large rule set to the rules used in production.
+// The hits are counted since the rules were loaded or the last ResetStats.
+func (bp *bypasser) PrunedConfig(minHits uint64) string {
+	bp.mux.RLock()
+	defer bp.mux.RUnlock()
+
+	var sb strings.Builder
+	bp.writeOptions(&sb)
+	for i, m := range bp.matchers {
+		if m == nil || atomic.LoadUint64(&bp.hits[i]) < minHits {
+			continue
+		}
+		writeRule(&sb, m)
+	}
+	return sb.String()
+}
+
+// Stats are the matching statistics of a bypass.
+type Stats struct {
+	// Name is the name of the bypass, see SetName.
+	Name string
+	// Calls is the number of the addresses decided by Bypass, BypassMatch, BypassTuple and BypassAddr,
+	// and of the ones of the batches of BypassAll and BypassBitset, one per non-empty address.
+	Calls uint64
+	// Bypassed is the number of those decisions which are true.
+	Bypassed uint64
+	// Hits are the numbers of the addresses matched by the current rules, by the String() of their matcher.
+	// A rule which never fires has no hits.
+	Hits map[string]uint64
+}
+
+// Stats returns the matching statistics of the bypass since it was created or the last ResetStats.
+// The hits of a rule are counted since it was loaded, the decisions served by the result cache are not.
+func (bp *bypasser) Stats() Stats {
+	bp.mux.RLock()
+	defer bp.mux.RUnlock()
+
+	stats := Stats{
+		Name:     bp.name,
+		Calls:    bp.calls.Load(),
+		Bypassed: bp.bypassedCalls.Load(),
+		Hits:     make(map[string]uint64),
+	}
+	for i, m := range bp.matchers {
+		if m == nil {
+			continue
+		}
+		if n := atomic.LoadUint64(&bp.hits[i]); n > 0 {
+			stats.Hits[m.String()] += n
+		}
+	}
+	return stats
+}
+
+// ResetStats zeroes the matching statistics of the bypass, including the hit counters of the rules.
+func (bp *bypasser) ResetStats() {
+	bp.mux.RLock()
+	defer bp.mux.RUnlock()
+
+	bp.calls.Store(0)
+	bp.bypassedCalls.Store(0)
+	for i := range bp.hits {
+		atomic.StoreUint64(&bp.hits[i], 0)
+	}
+}
@@ -0,0 +1,114 @@
+package bypass
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPrunedConfig(t *testing.T) {
+	config := `reload 30s
+reverse false
+192.168.1.1
+10.0.0.0/8
+.example.com
+*.example.org
+2001:db8::/32
+www.example.net
+`
+	bp := NewBypasser(false).(*bypasser)
+	if err := bp.Reload(strings.NewReader(config)); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		bp.Bypass("10.1.2.3")
+		bp.Bypass("www.example.com:443")
+	}
+	bp.Bypass("192.168.1.1")
+	bp.Bypass("[2001:db8::1]:80")
+	bp.Bypass("example.io")
+
+	if s, want := bp.PrunedConfig(2), "reverse false\ndefault proxy\nreload 30s\n10.0.0.0/8\n.example.com\n"; s != want {
+		t.Errorf("expected %q, got %q", want, s)
+	}
+	if s, want := bp.PrunedConfig(1), "reverse false\ndefault proxy\nreload 30s\n192.168.1.1\n10.0.0.0/8\n.example.com\n2001:db8::/32\n"; s != want {
+		t.Errorf("expected %q, got %q", want, s)
+	}
+	if s, want := bp.PrunedConfig(10), "reverse false\ndefault proxy\nreload 30s\n"; s != want {
+		t.Errorf("expected %q, got %q", want, s)
+	}
+
+	// the pruned config can be loaded back
+	pruned := NewBypasser(false).(*bypasser)
+	if err := pruned.Reload(strings.NewReader(bp.PrunedConfig(2))); err != nil {
+		t.Fatal(err)
+	}
+	if !pruned.Bypass("example.com") || !pruned.Bypass("10.0.0.1") || pruned.Bypass("192.168.1.1") {
+		t.Errorf("pruned config should keep the hot rules only")
+	}
+	if pruned.Period() != bp.Period() {
+		t.Errorf("pruned config should keep the options")
+	}
+}
+
+func TestReloadOptionsOnly(t *testing.T) {
+	bp := NewBypasser(false).(*bypasser)
+	if err := bp.Reload(strings.NewReader("reverse false\n*.example.com\n10.0.0.0/8\n")); err != nil {
+		t.Fatal(err)
+	}
+	bp.Bypass("www.example.com")
+	bp.Bypass("www.example.com")
+
+	m0 := bp.matchers[0]
+
+	// only the header changes
+	if err := bp.Reload(strings.NewReader("# tweaked\nreverse true\nreload 10s\n*.example.com   # comment\n10.0.0.0/8\n")); err != nil {
+		t.Fatal(err)
+	}
+	if bp.matchers[0] != m0 {
+		t.Errorf("matchers should be left intact")
+	}
+	if bp.hits[0] != 2 {
+		t.Errorf("hit counters should be preserved, got %d", bp.hits[0])
+	}
+	if !bp.reversed || bp.period != 10*time.Second {
+		t.Errorf("options should be updated")
+	}
+	if bp.Bypass("www.example.com") {
+		t.Errorf("www.example.com should not be bypassed in reversed mode")
+	}
+
+	// the rules change
+	if err := bp.Reload(strings.NewReader("reverse true\n*.example.com\n10.0.0.0/16\n")); err != nil {
+		t.Fatal(err)
+	}
+	if bp.matchers[0] == m0 || bp.hits[0] != 0 {
+		t.Errorf("matchers should be rebuilt when the rules change")
+	}
+}
+
+func TestStats(t *testing.T) {
+	bp := NewBypasserPatterns(false, "*.example.com", "10.0.0.0/8", "192.168.1.1").(*bypasser)
+
+	for _, addr := range []string{"www.example.com", "api.example.com:443", "10.1.2.3", "example.org", ""} {
+		bp.Bypass(addr)
+	}
+	bp.BypassMatch("10.2.3.4")
+
+	stats := bp.Stats()
+	if stats.Calls != 5 || stats.Bypassed != 4 {
+		t.Errorf("expected 5 calls and 4 bypassed, got %d and %d", stats.Calls, stats.Bypassed)
+	}
+	want := map[string]uint64{"domain *.example.com": 2, "cidr 10.0.0.0/8": 2}
+	if !reflect.DeepEqual(stats.Hits, want) {
+		t.Errorf("expected hits %v, got %v", want, stats.Hits)
+	}
+
+	bp.ResetStats()
+	stats = bp.Stats()
+	if stats.Calls != 0 || stats.Bypassed != 0 || len(stats.Hits) != 0 {
+		t.Errorf("the stats should be zeroed, got %+v", stats)
+	}
+}
@@ -0,0 +1,213 @@
+package bypass
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	maxHostnameLen = 253
+	maxLabelLen    = 63
+)
+
+// checkHostnames validates the patterns of the domain matchers as hostnames according to mode.
+// In "strict" mode the invalid patterns are reported as an error,
+// in any other mode parsed as true they are logged as warnings with logf.
+func checkHostnames(matchers []Matcher, mode string, logf func(format string, args ...interface{})) error {
+	strict := mode == "strict"
+	if enabled, _ := strconv.ParseBool(mode); !enabled && !strict {
+		return nil
+	}
+
+	var errs []string
+	for _, matcher := range matchers {
+		m, ok := ruleDomain(matcher)
+		if !ok {
+			continue
+		}
+		if err := validateHostname(m.expr); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", m.pattern, err))
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+
+	if strict {
+		return fmt.Errorf("bypass: invalid hostnames: %s", strings.Join(errs, "; "))
+	}
+	for _, e := range errs {
+		logf("invalid hostname %s", e)
+	}
+	return nil
+}
+
+// ruleDomain returns the domain matcher of the host of a rule,
+// unwrapping the negated, deny, scheme and port rules, false if the rule is not a domain rule.
+func ruleDomain(matcher Matcher) (*domainMatcher, bool) {
+	if nm, ok := matcher.(*negatedMatcher); ok {
+		matcher = nm.Matcher
+	}
+	if dm, ok := matcher.(*deniedMatcher); ok {
+		matcher = dm.Matcher
+	}
+	if sm, ok := matcher.(*schemeMatcher); ok {
+		matcher = sm.Matcher
+	}
+	if pm, ok := matcher.(*portMatcher); ok {
+		matcher = pm.Matcher
+	}
+	m, ok := matcher.(*domainMatcher)
+	return m, ok
+}
+
+var errInvalidIP = errors.New("invalid IP address, CIDR or range")
+
+// checkIPPattern reports an error if the rule of the matcher is a domain rule
+// whose pattern is meant as an IP address, a CIDR or a range of IP addresses, see looksLikeIP.
+func checkIPPattern(matcher Matcher) error {
+	if m, ok := ruleDomain(matcher); ok && looksLikeIP(m.raw) {
+		return errInvalidIP
+	}
+	return nil
+}
+
+// looksLikeIP reports whether the pattern s is made of the characters of an IPv4 address, a CIDR or a range only,
+// such as '300.1.1.1' or '10.0.0.0/33', or of an IPv6 one with a ':', such as '2001:db8::/129'.
+// A top-level domain is never numeric, so such a pattern can not be a domain.
+func looksLikeIP(s string) bool {
+	if s == "" || !strings.ContainsAny(s, ".:") {
+		return false
+	}
+	ipv6 := strings.Contains(s, ":")
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case '0' <= c && c <= '9', c == '.', c == '/', c == '-':
+		case ipv6 && (c == ':' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// validateHostname checks whether the domain pattern is a valid RFC-1123 hostname
+// once its wildcards are replaced by plain characters.
+func validateHostname(pattern string) error {
+	name := strings.TrimPrefix(pattern, ".")
+	name = strings.TrimSuffix(unwildcard(name), ".")
+	if name == "" {
+		return errors.New("empty hostname")
+	}
+	if len(name) > maxHostnameLen {
+		return fmt.Errorf("hostname is longer than %d characters", maxHostnameLen)
+	}
+	for _, label := range strings.Split(name, ".") {
+		if label == "" {
+			return errors.New("empty label")
+		}
+		if len(label) > maxLabelLen {
+			return fmt.Errorf("label %q is longer than %d characters", label, maxLabelLen)
+		}
+		if label[0] == '-' || label[len(label)-1] == '-' {
+			return fmt.Errorf("label %q starts or ends with a hyphen", label)
+		}
+		for i := 0; i < len(label); i++ {
+			if c := label[i]; !isHostnameChar(c) {
+				return fmt.Errorf("illegal character %q in label %q", c, label)
+			}
+		}
+	}
+	return nil
+}
+
+func isHostnameChar(c byte) bool {
+	return 'a' <= c && c <= 'z' ||
+		'A' <= c && c <= 'Z' ||
+		'0' <= c && c <= '9' ||
+		c == '-'
+}
+
+// unwildcard replaces the glob wildcards in pattern by a plain character,
+// character classes by a plain character
+// and alternatives by their first alternative.
+func unwildcard(pattern string) string {
+	var sb strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*':
+			for i+1 < len(pattern) && pattern[i+1] == '*' {
+				i++
+			}
+			sb.WriteByte('x')
+		case '?':
+			sb.WriteByte('x')
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+				sb.WriteByte(pattern[i])
+			}
+		case '[':
+			if n := strings.IndexByte(pattern[i:], ']'); n > 0 {
+				i += n
+			}
+			sb.WriteByte('x')
+		case '{':
+			n := closingBrace(pattern[i:])
+			if n < 0 {
+				sb.WriteByte(c)
+				continue
+			}
+			alt := pattern[i+1 : i+n]
+			if k := topLevelComma(alt); k >= 0 {
+				alt = alt[:k]
+			}
+			sb.WriteString(unwildcard(alt))
+			i += n
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String()
+}
+
+// closingBrace returns the index of the brace closing the one at s[0], or -1.
+func closingBrace(s string) int {
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '{':
+			depth++
+		case '}':
+			if depth--; depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// topLevelComma returns the index of the first comma outside of braces in s, or -1.
+func topLevelComma(s string) int {
+	depth := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '{':
+			depth++
+		case '}':
+			depth--
+		case ',':
+			if depth <= 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
@@ -0,0 +1,91 @@
+package bypass
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+var validateHostnameTests = []struct {
+	pattern string
+	valid   bool
+}{
+	{"example.com", true},
+	{"www.example.com", true},
+	{"example.com.", true},
+	{"*.example.com", true},
+	{"**.example.com", true},
+	{".example.com", true},
+	{"www.*.*.com", true},
+	{"[!0-9]*.example.com", true},
+	{"example.{com,net,org}", true},
+	{"123.example.com", true},
+	{"xn--mnchen-3ya.de", true},
+
+	{strings.Repeat("a", 63) + ".com", true},
+	{strings.Repeat("a", 64) + ".com", false},
+	{strings.Repeat("a.", 126) + "com", false},
+	{"exa_mple.com", false},
+	{"exa mple.com", false},
+	{"example!.com", false},
+	{"-example.com", false},
+	{"example-.com", false},
+	{"www..example.com", false},
+	{"http://www.example.com", false},
+	{"", false},
+}
+
+func TestValidateHostname(t *testing.T) {
+	for i, tc := range validateHostnameTests {
+		tc := tc
+		t.Run(fmt.Sprintf("#%d", i), func(t *testing.T) {
+			err := validateHostname(DomainMatcher(tc.pattern).(*domainMatcher).expr)
+			if (err == nil) != tc.valid {
+				t.Errorf("#%d test failed: %s, %v", i, tc.pattern, err)
+			}
+		})
+	}
+}
+
+func TestReloadValidateHostnames(t *testing.T) {
+	longLabel := strings.Repeat("a", 64) + ".example.com"
+	rules := "*.example.com\n" + longLabel + "\nexa_mple.com\n"
+
+	bp := NewBypasser(false).(*bypasser)
+
+	if err := bp.Reload(strings.NewReader("validate-hostnames strict\n" + rules)); err == nil {
+		t.Errorf("expected an error in strict mode")
+	} else if !strings.Contains(err.Error(), longLabel) || !strings.Contains(err.Error(), "exa_mple.com") {
+		t.Errorf("error should report all invalid patterns: %v", err)
+	}
+	if len(bp.matchers) != 0 {
+		t.Errorf("rules should not be loaded in strict mode on error")
+	}
+
+	if err := bp.Reload(strings.NewReader("validate-hostnames true\n" + rules)); err != nil {
+		t.Errorf("unexpected error in warning mode: %v", err)
+	}
+	if len(bp.matchers) != 3 {
+		t.Errorf("rules should be loaded in warning mode")
+	}
+
+	if err := bp.Reload(strings.NewReader("validate-hostnames strict\n*.example.com\n192.168.1.1\n")); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestReloadValidateHostnamesUnchanged(t *testing.T) {
+	rules := "*.example.com\nbad_host!.example.com\n"
+	bp := NewBypasser(false).(*bypasser)
+	if err := bp.Reload(strings.NewReader(rules)); err != nil {
+		t.Fatal(err)
+	}
+
+	// the same rules, now validated, are rejected as by a fresh bypass
+	if err := bp.Reload(strings.NewReader("validate-hostnames strict\n" + rules)); err == nil {
+		t.Errorf("expected an error for the rules unchanged but validated")
+	}
+	if err := NewBypasser(false).(*bypasser).Reload(strings.NewReader("validate-hostnames strict\n" + rules)); err == nil {
+		t.Errorf("expected an error for a fresh bypass")
+	}
+}
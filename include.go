@@ -0,0 +1,40 @@
+package bypass
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// maxIncludeDepth is the maximum nesting of the included files of a config.
+const maxIncludeDepth = 8
+
+// include parses the file at path into cfg, from is the file including it, empty for a top-level config.
+// A relative path is resolved against the directory of from, or the working directory for a top-level config.
+func (bp *bypasser) include(cfg *reloadConfig, path, from string, depth int) error {
+	if from != "" && !filepath.IsAbs(path) {
+		path = filepath.Join(filepath.Dir(from), path)
+	}
+	if depth >= maxIncludeDepth {
+		return fmt.Errorf("bypass: include %s: too deeply nested", path)
+	}
+
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return fmt.Errorf("bypass: include %s: %w", path, err)
+	}
+	if cfg.visited[abs] {
+		return fmt.Errorf("bypass: include %s: include cycle", path)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("bypass: include %s: %w", path, err)
+	}
+	defer f.Close()
+
+	cfg.visited[abs] = true
+	defer delete(cfg.visited, abs)
+
+	return bp.parseConfig(cfg, f, path, depth+1)
+}
@@ -0,0 +1,116 @@
+package bypass
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReloadInclude(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, filepath.Join(dir, "ads.txt"), "reverse true\n*.ads.example.com\n[bad\n")
+	base := filepath.Join(dir, "base.txt")
+	writeFile(t, base, "*.example.org\ninclude ads.txt\n")
+
+	bp := NewBypasser(false).(*bypasser)
+	err := bp.Reload(strings.NewReader("include " + base + "\n*.example.net\n"))
+
+	var re *RuleError
+	if !errors.As(err, &re) {
+		t.Fatalf("expected a RuleError, got %v", err)
+	}
+	if re.File != filepath.Join(dir, "ads.txt") || re.Line != 3 {
+		t.Errorf("the error should report the included file and line: %v", err)
+	}
+
+	if bp.Len() != 3 {
+		t.Errorf("expected 3 rules, got %d", bp.Len())
+	}
+	if !bp.Reversed() {
+		t.Errorf("the options of the included file should be applied")
+	}
+	for _, addr := range []string{"www.example.org", "www.ads.example.com", "www.example.net"} {
+		if bp.Bypass(addr) {
+			t.Errorf("%s should match an included rule", addr)
+		}
+	}
+}
+
+func TestReloadIncludeErrors(t *testing.T) {
+	dir := t.TempDir()
+	a, b := filepath.Join(dir, "a.txt"), filepath.Join(dir, "b.txt")
+	writeFile(t, a, "*.example.org\ninclude b.txt\n")
+	writeFile(t, b, "include a.txt\n")
+
+	bp := NewBypasser(false, NewMatcher("*.example.com")).(*bypasser)
+
+	if err := bp.Reload(strings.NewReader("include " + a)); err == nil || !strings.Contains(err.Error(), "cycle") {
+		t.Errorf("expected an include cycle error, got %v", err)
+	}
+	if err := bp.Reload(strings.NewReader("include " + filepath.Join(dir, "missing.txt"))); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected a not exist error, got %v", err)
+	}
+	if bp.Len() != 1 || !bp.Bypass("www.example.com") {
+		t.Errorf("the rules should be left intact on error")
+	}
+
+	// the same file can be included more than once if not nested in itself
+	writeFile(t, b, "*.example.net\n")
+	if err := bp.Reload(strings.NewReader("include " + b + "\ninclude " + b)); err != nil {
+		t.Fatal(err)
+	}
+	if bp.Len() != 2 {
+		t.Errorf("expected 2 rules, got %d", bp.Len())
+	}
+}
+
+func TestReloadIncludeDepth(t *testing.T) {
+	dir := t.TempDir()
+	for i := 0; i <= maxIncludeDepth; i++ {
+		writeFile(t, filepath.Join(dir, string(rune('a'+i))+".txt"), "include "+string(rune('a'+i+1))+".txt\n")
+	}
+
+	bp := NewBypasser(false).(*bypasser)
+	if err := bp.Reload(strings.NewReader("include " + filepath.Join(dir, "a.txt"))); err == nil || !strings.Contains(err.Error(), "nested") {
+		t.Errorf("expected a nesting error, got %v", err)
+	}
+}
+
+func TestReloadAll(t *testing.T) {
+	bp := NewBypasser(false).(*bypasser)
+
+	err := bp.ReloadAll(
+		strings.NewReader("reverse true\n*.example.com\n"),
+		nil,
+		strings.NewReader("*.example.org\n[bad\nreload 10s\n"),
+	)
+	var re *RuleError
+	if !errors.As(err, &re) || re.Line != 2 || re.Pattern != "[bad" {
+		t.Errorf("expected a RuleError at line 2, got %v", err)
+	}
+
+	if bp.Len() != 2 {
+		t.Errorf("expected 2 rules, got %d", bp.Len())
+	}
+	if !bp.Reversed() || bp.Period() != 10*time.Second {
+		t.Errorf("the options of all the configs should be applied")
+	}
+	if bp.Bypass("www.example.com") || bp.Bypass("www.example.org") {
+		t.Errorf("the rules of all the configs should be loaded")
+	}
+
+	if err := bp.ReloadAll(); err != nil || bp.Len() != 2 {
+		t.Errorf("reloading no config should leave the bypass intact")
+	}
+}
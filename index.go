@@ -0,0 +1,220 @@
+package bypass
+
+import (
+	"net"
+	"strings"
+	"unsafe"
+)
+
+// indexMin is the number of rules of a kind from which they are looked up in a ruleIndex,
+// a linear scan is faster for fewer rules.
+const indexMin = 16
+
+// ruleIndex indexes the plain IP and CIDR rules of a bypass in binary tries of their prefixes,
+// and its plain domain rules matching a suffix, such as '.example.com' and '*.example.com', or an exact domain
+// in tries of their reversed labels, see labelTrie, so that the first of them matching a host
+// is found in O(host length) whatever the number of rules.
+// The other rules, such as the regex or the prefix wildcard ones, are scanned in order.
+type ruleIndex struct {
+	ip      *ipTrie      // nil if the IP rules are not indexed
+	domains []*labelTrie // by normalization of the domains
+	scan    []int        // the indices of the rules which are not indexed, in order
+}
+
+// newRuleIndex creates the ruleIndex of the matchers, nil if there are too few rules to index.
+func newRuleIndex(matchers []Matcher) *ruleIndex {
+	var ips int
+	domains := make(map[domainNorm]int)
+	for _, matcher := range matchers {
+		if _, _, ok := indexedPrefix(matcher); ok {
+			ips++
+		}
+		if m, ok := matcher.(*domainMatcher); ok {
+			if _, _, ok := indexedDomain(m); ok {
+				domains[domainNorm{m.fold, m.idn}]++
+			}
+		}
+	}
+
+	x := &ruleIndex{}
+	if ips >= indexMin {
+		x.ip = &ipTrie{
+			v4: &ipNode{rule: -1},
+			v6: &ipNode{rule: -1},
+		}
+	}
+	tries := make(map[domainNorm]*labelTrie)
+	for norm, n := range domains {
+		if n >= indexMin {
+			trie := newLabelTrie(norm)
+			tries[norm] = trie
+			x.domains = append(x.domains, trie)
+		}
+	}
+	if x.ip == nil && len(x.domains) == 0 {
+		return nil
+	}
+
+	for i, matcher := range matchers {
+		if ip, ones, ok := indexedPrefix(matcher); ok && x.ip != nil {
+			x.ip.insert(ip, ones, i)
+			continue
+		}
+		if m, ok := matcher.(*domainMatcher); ok {
+			if s, match, ok := indexedDomain(m); ok && tries[domainNorm{m.fold, m.idn}] != nil {
+				tries[domainNorm{m.fold, m.idn}].insert(s, match, i)
+				continue
+			}
+		}
+		x.scan = append(x.scan, i)
+	}
+	return x
+}
+
+// lookup returns the lowest index of the indexed rules of the given kind matching the host, -1 if none.
+func (x *ruleIndex) lookup(host string, kind int) int {
+	rule := -1
+	if x.ip != nil && kind != kindDomain {
+		rule = x.ip.lookup(host)
+	}
+	if kind != kindIP {
+		for _, trie := range x.domains {
+			rule = minRule(rule, trie.lookup(host))
+		}
+	}
+	return rule
+}
+
+// memBytes returns the memory retained by the index.
+func (x *ruleIndex) memBytes() int {
+	n := int(unsafe.Sizeof(*x)) + cap(x.scan)*int(unsafe.Sizeof(0))
+	if x.ip != nil {
+		n += x.ip.v4.memBytes() + x.ip.v6.memBytes()
+	}
+	for _, trie := range x.domains {
+		n += int(unsafe.Sizeof(*trie)) + trie.root.memBytes()
+	}
+	return n
+}
+
+// minRule returns the lowest of the rule indices a and b, -1 meaning none.
+func minRule(a, b int) int {
+	if a < 0 || b >= 0 && b < a {
+		return b
+	}
+	return a
+}
+
+// ipTrie is a pair of binary tries of the IPv4 and IPv6 prefixes.
+type ipTrie struct {
+	v4, v6 *ipNode
+}
+
+type ipNode struct {
+	children [2]*ipNode
+	rule     int // the lowest index of the rules of the prefix ending at the node, -1 if none
+}
+
+func (tr *ipTrie) insert(ip net.IP, ones int, rule int) {
+	node := tr.v6
+	if len(ip) == net.IPv4len {
+		node = tr.v4
+	}
+	for b := 0; b < ones; b++ {
+		bit := ip[b/8] >> (7 - uint(b%8)) & 1
+		if node.children[bit] == nil {
+			node.children[bit] = &ipNode{rule: -1}
+		}
+		node = node.children[bit]
+	}
+	node.rule = minRule(node.rule, rule)
+}
+
+func (tr *ipTrie) lookup(host string) int {
+	ip, _ := parseIPZone(host)
+	if ip == nil {
+		return -1
+	}
+	node := tr.v6
+	if ip4 := ip.To4(); ip4 != nil {
+		ip, node = ip4, tr.v4
+	}
+
+	rule := node.rule
+	for b := 0; b < 8*len(ip); b++ {
+		if node = node.children[ip[b/8]>>(7-uint(b%8))&1]; node == nil {
+			break
+		}
+		rule = minRule(rule, node.rule)
+	}
+	return rule
+}
+
+func (n *ipNode) memBytes() int {
+	size := int(unsafe.Sizeof(*n))
+	for _, child := range n.children {
+		if child != nil {
+			size += child.memBytes()
+		}
+	}
+	return size
+}
+
+// indexedPrefix returns the prefix matched by the matcher m, in its 4-byte form for IPv4,
+// and false if m can not be indexed.
+func indexedPrefix(matcher Matcher) (net.IP, int, bool) {
+	switch m := matcher.(type) {
+	case *ipMatcher:
+		// a zone sensitive rule also depends on the zone
+		if m.zoneSensitive && m.zone != "" {
+			return nil, 0, false
+		}
+		if ip := m.ip.To4(); ip != nil {
+			return ip, 8 * net.IPv4len, true
+		}
+		if len(m.ip) != net.IPv6len {
+			return nil, 0, false
+		}
+		return m.ip, 8 * net.IPv6len, true
+	case *cidrMatcher:
+		if m.ipNet == nil {
+			return nil, 0, false
+		}
+		// the same normalization as net.IPNet.Contains
+		ip, mask := m.ipNet.IP, m.ipNet.Mask
+		if ip4 := ip.To4(); ip4 != nil {
+			ip = ip4
+		}
+		if len(ip) == net.IPv4len && len(mask) == net.IPv6len {
+			mask = mask[12:]
+		}
+		ones, bits := mask.Size()
+		if bits == 0 || bits != 8*len(ip) {
+			return nil, 0, false
+		}
+		return ip, ones, true
+	default:
+		return nil, 0, false
+	}
+}
+
+// indexedDomain returns the domain of the labelTrie node of the domain matcher m and what m matches from it,
+// false if m can not be indexed.
+// The rules whose wildcards are bounded by separators, which compare domains in Unicode form
+// or which match a suffix off label boundaries, such as '*example.com', are not indexed.
+func indexedDomain(m *domainMatcher) (s string, match labelMatch, ok bool) {
+	if m.glob == nil || m.unicode || strings.Contains(m.expr, ":") {
+		return "", 0, false
+	}
+	switch {
+	case m.dot:
+		s, match, ok = m.pattern, labelSuffix, m.expr == suffixExpr(m.pattern)
+	case strings.HasPrefix(m.expr, "**."):
+		s, match, ok = m.expr[3:], labelSubdomains, true
+	case strings.HasPrefix(m.expr, "*.") && !m.separated:
+		s, match, ok = m.expr[2:], labelSubdomains, true
+	default:
+		s, match, ok = m.expr, labelExact, m.expr == m.pattern
+	}
+	return s, match, ok && s != "" && !hasGlobMeta(s)
+}
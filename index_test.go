@@ -0,0 +1,265 @@
+package bypass
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+func randomIPRules(r *rand.Rand, n int) []Matcher {
+	var matchers []Matcher
+	for i := 0; i < n; i++ {
+		a, b, c := r.Intn(4)+10, r.Intn(256), r.Intn(256)
+		var pattern string
+		switch i % 6 {
+		case 0:
+			pattern = fmt.Sprintf("%d.%d.%d.%d", a, b, c, r.Intn(256))
+		case 1:
+			pattern = fmt.Sprintf("%d.%d.0.0/%d", a, b, 12+r.Intn(8))
+		case 2:
+			pattern = fmt.Sprintf("2001:db8:%x::/%d", r.Intn(16), 40+r.Intn(24))
+		case 3:
+			pattern = fmt.Sprintf("2001:db8:%x::%x", r.Intn(16), r.Intn(16))
+		default:
+			pattern = fmt.Sprintf("%d.%d.%d.0/24", a, b, c)
+		}
+		matchers = append(matchers, NewMatcher(pattern))
+	}
+	return matchers
+}
+
+func randomIPAddr(r *rand.Rand) string {
+	switch r.Intn(4) {
+	case 0:
+		return fmt.Sprintf("2001:db8:%x::%x", r.Intn(16), r.Intn(16))
+	case 1:
+		return fmt.Sprintf("[::ffff:%d.%d.%d.%d]:443", r.Intn(4)+10, r.Intn(256), r.Intn(256), r.Intn(256))
+	default:
+		return fmt.Sprintf("%d.%d.%d.%d", r.Intn(4)+10, r.Intn(256), r.Intn(256), r.Intn(256))
+	}
+}
+
+func TestIPIndex(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+
+	matchers := randomIPRules(r, 2000)
+	// the rules which are not indexed keep their order with the indexed ones
+	matchers = append(matchers[:500:500], append([]Matcher{
+		NewMatcher("*.example.com"),
+		NegateMatcher(NewMatcher("10.1.0.0/16")),
+		PortMatcher(NewMatcher("11.0.0.0/8"), 80),
+		NewMatcher("12.0.0.0-12.1.0.0"),
+	}, matchers[500:]...)...)
+
+	bp := NewBypasser(false, matchers...).(*bypasser)
+	if bp.index == nil {
+		t.Fatal("the IP rules should be indexed")
+	}
+	linear := NewBypasser(false, matchers...).(*bypasser)
+	linear.index = nil
+
+	for i := 0; i < 5000; i++ {
+		addr := randomIPAddr(r)
+		if i%100 == 0 {
+			addr = "www.example.com"
+		}
+		got, want := bp.match(newTarget(addr), kindAny), linear.match(newTarget(addr), kindAny)
+		if got != want {
+			t.Fatalf("%s: the index matches rule %d, the linear scan rule %d", addr, got, want)
+		}
+	}
+
+	bp.AddMatcher(NewMatcher("1.2.3.4"))
+	if !bp.Bypass("1.2.3.4") {
+		t.Errorf("the index should be rebuilt on AddMatcher")
+	}
+	bp.RemoveMatcher("1.2.3.4")
+	if bp.Bypass("1.2.3.4") {
+		t.Errorf("the index should be rebuilt on RemoveMatcher")
+	}
+}
+
+func TestIPIndexFirstMatch(t *testing.T) {
+	matchers := []Matcher{NewMatcher("10.0.0.0/8"), NewMatcher("10.1.0.0/16"), NewMatcher("10.1.2.3")}
+	for i := 0; i < indexMin; i++ {
+		matchers = append(matchers, NewMatcher(fmt.Sprintf("192.168.%d.0/24", i)))
+	}
+	bp := NewBypasser(false, append([]Matcher{NewMatcher("*.example.com")}, matchers...)...).(*bypasser)
+
+	if _, m := bp.BypassMatch("10.1.2.3"); m != matchers[0] {
+		t.Errorf("the first matching rule should be reported, got %v", m)
+	}
+	if !bp.Bypass("192.168.3.1") || bp.Bypass("192.168.100.1") || !bp.Bypass("www.example.com") {
+		t.Errorf("unexpected decisions of the indexed rules")
+	}
+}
+
+func BenchmarkIPIndex(b *testing.B) {
+	r := rand.New(rand.NewSource(1))
+	matchers := randomIPRules(r, 10000)
+	addrs := make([]string, 1000)
+	for i := range addrs {
+		addrs[i] = randomIPAddr(r)
+	}
+
+	bp := NewBypasser(false, matchers...).(*bypasser)
+	b.Run("Trie", func(b *testing.B) {
+		for n := 0; n < b.N; n++ {
+			bp.Bypass(addrs[n%len(addrs)])
+		}
+	})
+
+	linear := NewBypasser(false, matchers...).(*bypasser)
+	linear.index = nil
+	b.Run("Linear", func(b *testing.B) {
+		for n := 0; n < b.N; n++ {
+			linear.Bypass(addrs[n%len(addrs)])
+		}
+	})
+}
+
+// indexFillers are the rules padding a rule set to be indexed, which match none of the tested addresses.
+func indexFillers() []string {
+	var patterns []string
+	for i := 0; i < indexMin; i++ {
+		patterns = append(patterns,
+			fmt.Sprintf("240.0.%d.0/24", i),
+			fmt.Sprintf(".filler%d.invalid", i),
+			fmt.Sprintf("*.filler%d.invalid", i),
+			fmt.Sprintf("filler%d.invalid", i),
+		)
+	}
+	return patterns
+}
+
+func TestRuleIndexContain(t *testing.T) {
+	fillers := indexFillers()
+	for i, tc := range bypassContainTests {
+		patterns := append(append([]string(nil), tc.patterns...), fillers...)
+		bp := NewBypasserPatterns(tc.reversed, patterns...).(*bypasser)
+		if bp.index == nil || len(bp.index.domains) == 0 {
+			t.Fatalf("#%d: the rules should be indexed", i)
+		}
+		linear := NewBypasserPatterns(tc.reversed, patterns...).(*bypasser)
+		linear.index = nil
+
+		if got, want := bp.Bypass(tc.addr), linear.Bypass(tc.addr); got != want {
+			t.Errorf("#%d: %v, %s: the index gives %v, the linear scan %v", i, tc.patterns, tc.addr, got, want)
+		}
+	}
+}
+
+func TestRuleIndexLabels(t *testing.T) {
+	patterns := append([]string{".example.com", "*.example.org", "**.example.net", "*example.io", "www.example.de"}, indexFillers()...)
+	bp := NewBypasserPatterns(false, patterns...).(*bypasser)
+	if bp.index == nil || len(bp.index.domains) != 1 {
+		t.Fatal("the domain rules should be indexed")
+	}
+	if n := len(bp.index.scan); n != 1 {
+		t.Errorf("only *example.io should be scanned, got %v", bp.index.scan)
+	}
+	linear := NewBypasserPatterns(false, patterns...).(*bypasser)
+	linear.index = nil
+
+	for _, host := range []string{
+		"example.com", "www.example.com", "wwwexample.com", ".example.com", "example.com.",
+		"example.org", "a.b.example.org", ".example.org", "wwwexample.org",
+		"example.net", "www.example.net", "wwwexample.net",
+		"example.io", "wwwexample.io", "www.example.de", "example.de", "a.www.example.de",
+	} {
+		if got, want := bp.match(newTarget(host), kindAny), linear.match(newTarget(host), kindAny); got != want {
+			t.Errorf("%s: the index matches rule %d, the linear scan rule %d", host, got, want)
+		}
+	}
+}
+
+func TestRuleIndexDomains(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	words := []string{"example", "www", "api", "cdn", "ex", "ample", "a", "Example"}
+	word := func() string { return words[r.Intn(len(words))] }
+
+	var matchers []Matcher
+	for i := 0; i < 1000; i++ {
+		var pattern string
+		switch i % 7 {
+		case 0:
+			pattern = "." + word() + "." + word()
+		case 1:
+			pattern = "*." + word() + ".com"
+		case 2:
+			pattern = word() + "." + word()
+		case 3:
+			pattern = "*" + word() + ".org"
+		case 4:
+			pattern = word() + ".*"
+		case 5:
+			pattern = "^" + word() + "." + word() + "$"
+		default:
+			pattern = word() + "." + word() + "."
+		}
+		matchers = append(matchers, NewMatcher(pattern))
+	}
+	c := &Compiler{IgnoreCase: true}
+	for i := 0; i < 100; i++ {
+		m, _ := c.Compile("." + word() + ".net")
+		matchers = append(matchers, m)
+	}
+
+	bp := NewBypasser(false, matchers...).(*bypasser)
+	if bp.index == nil || len(bp.index.domains) != 2 {
+		t.Fatalf("the domain rules should be indexed by normalization")
+	}
+	linear := NewBypasser(false, matchers...).(*bypasser)
+	linear.index = nil
+
+	tlds := []string{"com", "org", "net", "NET", "io", ""}
+	for i := 0; i < 5000; i++ {
+		host := word()
+		for n := r.Intn(3); n > 0; n-- {
+			host += "." + word()
+		}
+		if tld := tlds[r.Intn(len(tlds))]; tld != "" {
+			host += "." + tld
+		}
+		if r.Intn(10) == 0 {
+			host += "."
+		}
+		got, want := bp.match(newTarget(host), kindAny), linear.match(newTarget(host), kindAny)
+		if got != want {
+			t.Fatalf("%s: the index matches rule %d (%v), the linear scan rule %d", host, got, bp.matchers[max(got, 0)], want)
+		}
+	}
+}
+
+func BenchmarkRuleIndexDomains(b *testing.B) {
+	var patterns []string
+	for i := 0; i < 10000; i++ {
+		switch i % 3 {
+		case 0:
+			patterns = append(patterns, fmt.Sprintf(".example%d.com", i))
+		case 1:
+			patterns = append(patterns, fmt.Sprintf("*.example%d.org", i))
+		default:
+			patterns = append(patterns, fmt.Sprintf("www.example%d.net", i))
+		}
+	}
+	hosts := make([]string, 1000)
+	for i := range hosts {
+		hosts[i] = fmt.Sprintf("api.example%d.com", i*7)
+	}
+
+	bp := NewBypasserPatterns(false, patterns...).(*bypasser)
+	b.Run("Trie", func(b *testing.B) {
+		for n := 0; n < b.N; n++ {
+			bp.Bypass(hosts[n%len(hosts)])
+		}
+	})
+
+	linear := NewBypasserPatterns(false, patterns...).(*bypasser)
+	linear.index = nil
+	b.Run("Linear", func(b *testing.B) {
+		for n := 0; n < b.N; n++ {
+			linear.Bypass(hosts[n%len(hosts)])
+		}
+	})
+}
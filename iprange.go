@@ -0,0 +1,78 @@
+package bypass
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"strings"
+)
+
+var errMixedRange = errors.New("bypass: IP range with mixed address families")
+
+type rangeMatcher struct {
+	low  net.IP
+	high net.IP
+}
+
+// RangeMatcher creates a Matcher for the IP addresses between low and high inclusive,
+// such as '192.168.1.10-192.168.1.50' in the config.
+// Both ends must be of the same family, IPv4 or IPv6, and low must not sort after high,
+// otherwise nil is returned.
+func RangeMatcher(low, high net.IP) Matcher {
+	m, err := newRangeMatcher(low, high)
+	if err != nil {
+		return nil
+	}
+	return m
+}
+
+func newRangeMatcher(low, high net.IP) (*rangeMatcher, error) {
+	if low == nil || high == nil {
+		return nil, errors.New("bypass: invalid IP range")
+	}
+	if (low.To4() != nil) != (high.To4() != nil) {
+		return nil, errMixedRange
+	}
+	low, high = low.To16(), high.To16()
+	if bytes.Compare(low, high) > 0 {
+		return nil, errors.New("bypass: IP range with the low end after the high end")
+	}
+	return &rangeMatcher{
+		low:  low,
+		high: high,
+	}, nil
+}
+
+func (m *rangeMatcher) Match(ip string) bool {
+	if m == nil {
+		return false
+	}
+	addr, _ := parseIPZone(ip)
+	if addr == nil || (addr.To4() != nil) != (m.low.To4() != nil) {
+		return false
+	}
+	addr = addr.To16()
+	return bytes.Compare(addr, m.low) >= 0 && bytes.Compare(addr, m.high) <= 0
+}
+
+func (m *rangeMatcher) String() string {
+	return "range " + m.pattern()
+}
+
+func (m *rangeMatcher) pattern() string {
+	return m.low.String() + "-" + m.high.String()
+}
+
+// parseRange parses an IP range pattern such as '192.168.1.10-192.168.1.50'.
+// It returns false if the pattern is not a pair of IP addresses.
+func parseRange(pattern string) (net.IP, net.IP, bool) {
+	i := strings.IndexByte(pattern, '-')
+	if i < 0 {
+		return nil, nil, false
+	}
+	low, high := parseIP(pattern[:i]), parseIP(pattern[i+1:])
+	if low == nil || high == nil {
+		return nil, nil, false
+	}
+	return low, high, true
+}
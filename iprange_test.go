@@ -0,0 +1,80 @@
+package bypass
+
+import (
+	"fmt"
+	"net"
+	"testing"
+)
+
+var rangeTests = []struct {
+	pattern string
+	addr    string
+	matched bool
+}{
+	{"192.168.1.10-192.168.1.50", "192.168.1.10", true},
+	{"192.168.1.10-192.168.1.50", "192.168.1.30", true},
+	{"192.168.1.10-192.168.1.50", "192.168.1.50", true},
+	{"192.168.1.10-192.168.1.50", "192.168.1.9", false},
+	{"192.168.1.10-192.168.1.50", "192.168.1.51", false},
+	{"192.168.1.10-192.168.1.50", "192.168.2.30", false},
+	{"192.168.1.10-192.168.1.50", "::ffff:192.168.1.30", true},
+	{"192.168.1.10-192.168.1.50", "::1", false},
+	{"192.168.1.10-192.168.1.50", "example.com", false},
+	{"10.0.0.255-10.0.1.0", "10.0.1.0", true},
+	{"10.0.0.255-10.0.1.0", "10.0.0.128", false},
+	{"2001:db8::10-2001:db8::ff", "2001:db8::10", true},
+	{"2001:db8::10-2001:db8::ff", "2001:db8::80", true},
+	{"2001:db8::10-2001:db8::ff", "2001:db8::ff", true},
+	{"2001:db8::10-2001:db8::ff", "2001:db8::100", false},
+	{"2001:db8::10-2001:db8::ff", "2001:db8::f", false},
+	{"2001:db8::10-2001:db8::ff", "192.168.1.1", false},
+	{"fe80::1-fe80::9", "fe80::5%eth0", true},
+}
+
+func TestRangeMatcher(t *testing.T) {
+	for i, tc := range rangeTests {
+		tc := tc
+		t.Run(fmt.Sprintf("#%d", i), func(t *testing.T) {
+			m := NewMatcher(tc.pattern)
+			if _, ok := m.(*rangeMatcher); !ok {
+				t.Fatalf("#%d %s should be a range, got %v", i, tc.pattern, m)
+			}
+			if m.Match(tc.addr) != tc.matched {
+				t.Errorf("#%d test failed: %s, %s", i, tc.pattern, tc.addr)
+			}
+		})
+	}
+}
+
+func TestRangeMatcherErrors(t *testing.T) {
+	for _, pattern := range []string{
+		"192.168.1.10-2001:db8::1",
+		"2001:db8::1-192.168.1.10",
+		"192.168.1.50-192.168.1.10",
+	} {
+		if m := NewMatcher(pattern); m != nil {
+			t.Errorf("NewMatcher should return nil for %s, got %v", pattern, m)
+		}
+	}
+	if m := RangeMatcher(net.ParseIP("10.0.0.1"), net.ParseIP("::1")); m != nil {
+		t.Errorf("RangeMatcher should return nil for a mixed-family range")
+	}
+
+	// not a range of IP addresses
+	if _, ok := NewMatcher("my-host.example.com").(*domainMatcher); !ok {
+		t.Errorf("a domain with a dash should be a domain pattern")
+	}
+}
+
+func TestRangeMatcherBypass(t *testing.T) {
+	bp := NewBypasserPatterns(false, "192.168.1.10-192.168.1.50").(*bypasser)
+	if !bp.Bypass("192.168.1.20:80") || bp.Bypass("192.168.1.60:80") {
+		t.Errorf("the range should match the host without the port")
+	}
+	if s, want := bp.matchers[0].String(), "range 192.168.1.10-192.168.1.50"; s != want {
+		t.Errorf("expected %q, got %q", want, s)
+	}
+	if s, want := bp.PrunedConfig(0), "reverse false\ndefault proxy\n192.168.1.10-192.168.1.50\n"; s != want {
+		t.Errorf("expected the config %q, got %q", want, s)
+	}
+}
@@ -0,0 +1,43 @@
+package bypass
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+// NewBypasserIPSet creates a Bypasser from a set file in the ipset save format,
+// so that the IP sets maintained for a firewall can be reused as bypass rules:
+//
+//	create bypass hash:net family inet hashsize 1024 maxelem 65536
+//	add bypass 10.0.0.0/8
+//	add bypass 192.168.1.1 timeout 0
+//
+// Each 'add <setname> <ip-or-cidr>' line gives a rule, the other lines and the options
+// of the entries are ignored. An entry which is neither an IP address nor a CIDR is reported as an error.
+func NewBypasserIPSet(r io.Reader) (Bypasser, error) {
+	var matchers []Matcher
+
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		ss := splitLine(scanner.Text())
+		if len(ss) < 3 || ss[0] != "add" {
+			continue
+		}
+		entry := ss[2]
+		if ip := parseIP(entry); ip != nil {
+			matchers = append(matchers, IPMatcher(ip))
+			continue
+		}
+		_, inet, err := parseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("bypass: ipset line %d: invalid entry %q", n, entry)
+		}
+		matchers = append(matchers, CIDRMatcher(inet))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return NewBypasser(false, matchers...), nil
+}
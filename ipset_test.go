@@ -0,0 +1,47 @@
+package bypass
+
+import (
+	"strings"
+	"testing"
+)
+
+const ipsetDump = `create bypass hash:net family inet hashsize 1024 maxelem 65536
+add bypass 10.0.0.0/8
+add bypass 192.168.1.1 timeout 0
+add bypass 172.16.0.0/12
+create bypass6 hash:net family inet6 hashsize 1024 maxelem 65536
+add bypass6 2001:db8::/32
+add bypass6 fe80::1
+`
+
+func TestNewBypasserIPSet(t *testing.T) {
+	bp, err := NewBypasserIPSet(strings.NewReader(ipsetDump))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(bp.(*bypasser).matchers); n != 5 {
+		t.Errorf("expected 5 rules, got %d", n)
+	}
+
+	for _, tc := range []struct {
+		addr     string
+		bypassed bool
+	}{
+		{"10.1.2.3", true},
+		{"192.168.1.1:80", true},
+		{"192.168.1.2", false},
+		{"172.20.0.1", true},
+		{"[2001:db8::1]:443", true},
+		{"fe80::1", true},
+		{"fe80::2", false},
+		{"bypass", false},
+	} {
+		if bp.Bypass(tc.addr) != tc.bypassed {
+			t.Errorf("test failed: %s", tc.addr)
+		}
+	}
+
+	if _, err := NewBypasserIPSet(strings.NewReader("add bypass 10.0.0.1,tcp:80\n")); err == nil {
+		t.Errorf("expected an error for an invalid entry")
+	}
+}
@@ -0,0 +1,97 @@
+package bypass
+
+// MatcherKind is the kind of the pattern of a Matcher, see Kind.
+type MatcherKind string
+
+const (
+	KindIP        MatcherKind = "ip"
+	KindCIDR      MatcherKind = "cidr"
+	KindRange     MatcherKind = "range"
+	KindDomain    MatcherKind = "domain" // including the groups of domain rules, see the combine-domains option
+	KindRegex     MatcherKind = "regex"
+	KindPrefix    MatcherKind = "prefix"
+	KindSuffix    MatcherKind = "suffix"
+	KindLabels    MatcherKind = "labels"
+	KindResolve   MatcherKind = "resolve"
+	KindPTR       MatcherKind = "ptr"
+	KindPort      MatcherKind = "port"
+	KindScheme    MatcherKind = "scheme"
+	KindComposite MatcherKind = "composite"
+	KindAny       MatcherKind = "any"
+	KindNone      MatcherKind = "none"
+	// KindCustom is the kind of a custom Matcher not implementing KindMatcher.
+	KindCustom MatcherKind = "custom"
+)
+
+// KindMatcher is a Matcher telling the kind of its pattern.
+// A custom Matcher may implement it, one not implementing it has the kind KindCustom.
+type KindMatcher interface {
+	Matcher
+	Kind() MatcherKind
+}
+
+// Kind returns the kind of the Matcher m, e.g. KindCIDR for '10.0.0.0/8' and KindPort for 'example.com:443'.
+// A negated, deny, expiring or qualified rule has the kind of the Matcher it wraps,
+// while a rule restricted to some ports or to a scheme is of the kind KindPort or KindScheme.
+func Kind(m Matcher) MatcherKind {
+	switch m := m.(type) {
+	case nil:
+		return ""
+	case KindMatcher:
+		return m.Kind()
+	case *ipMatcher:
+		return KindIP
+	case *cidrMatcher:
+		return KindCIDR
+	case *rangeMatcher:
+		return KindRange
+	case *domainMatcher, *domainGroupMatcher, *suffixTrieMatcher:
+		return KindDomain
+	case *regexMatcher:
+		return KindRegex
+	case *prefixMatcher:
+		return KindPrefix
+	case *suffixMatcher:
+		return KindSuffix
+	case *labelCountMatcher:
+		return KindLabels
+	case *resolveMatcher:
+		return KindResolve
+	case *ptrMatcher:
+		return KindPTR
+	case *portMatcher:
+		return KindPort
+	case *schemeMatcher:
+		return KindScheme
+	case *compositeMatcher:
+		return KindComposite
+	case *anyMatcher:
+		return KindAny
+	case *noneMatcher:
+		return KindNone
+	case *negatedMatcher:
+		return Kind(m.Matcher)
+	case *deniedMatcher:
+		return Kind(m.Matcher)
+	case *expiringMatcher:
+		return Kind(m.Matcher)
+	case *qualifiedMatcher:
+		return Kind(m.Matcher)
+	default:
+		return KindCustom
+	}
+}
+
+// MatchersByKind returns the rules of the bypass of the kind k, see Kind, in their evaluation order.
+func (bp *bypasser) MatchersByKind(k MatcherKind) []Matcher {
+	bp.mux.RLock()
+	defer bp.mux.RUnlock()
+
+	var matchers []Matcher
+	for _, m := range bp.matchers {
+		if m != nil && Kind(m) == k {
+			matchers = append(matchers, m)
+		}
+	}
+	return matchers
+}
@@ -0,0 +1,74 @@
+package bypass
+
+import (
+	"net"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// kindMatcher is a custom matcher of its own kind.
+type kindMatcher struct{ Matcher }
+
+func (kindMatcher) Kind() MatcherKind { return "geo" }
+
+func TestKind(t *testing.T) {
+	_, inet, _ := net.ParseCIDR("10.0.0.0/8")
+	for _, tc := range []struct {
+		matcher Matcher
+		kind    MatcherKind
+	}{
+		{NewMatcher("192.168.1.1"), KindIP},
+		{IPMatcher(net.ParseIP("::1")), KindIP},
+		{NewMatcher("10.0.0.0/8"), KindCIDR},
+		{CIDRMatcher(inet), KindCIDR},
+		{NewMatcher("10.0.0.1-10.0.0.9"), KindRange},
+		{NewMatcher("*.example.com"), KindDomain},
+		{NewMatcher(".example.com"), KindDomain},
+		{NewMatcher(`/^api\d+\.example\.com$/`), KindRegex},
+		{RegexMatcher(regexp.MustCompile(`^a`)), KindRegex},
+		{NewMatcher("svc-*"), KindPrefix},
+		{NewMatcher("*-svc"), KindSuffix},
+		{NewMatcher("labels:1"), KindLabels},
+		{ResolveMatcher("example.com", nil), KindResolve},
+		{PTRMatcher("*.example.com", nil), KindPTR},
+		{NewMatcher("example.com:443"), KindPort},
+		{NewMatcher("http://*.example.com"), KindScheme},
+		{AndMatcher(NewMatcher("*.example.com"), NewMatcher("labels:3")), KindComposite},
+		{NewMatcher("*"), KindAny},
+		{NoneMatcher(), KindNone},
+		{NegateMatcher(NewMatcher("10.0.0.0/8")), KindCIDR},
+		{DenyMatcher(NewMatcher("*.example.com")), KindDomain},
+		{ExpiringMatcher(NewMatcher("192.168.1.1"), time.Now().Add(time.Hour)), KindIP},
+		{QualifyMatcher(NewMatcher("192.168.1.1"), []int{443}, nil), KindIP},
+		{kindMatcher{NewMatcher("192.168.1.1")}, "geo"},
+		{NegateMatcher(kindMatcher{NewMatcher("192.168.1.1")}), "geo"},
+		{&countingMatcher{host: "example.com"}, KindCustom},
+		{nil, ""},
+	} {
+		if kind := Kind(tc.matcher); kind != tc.kind {
+			t.Errorf("%v: expected the kind %q, got %q", tc.matcher, tc.kind, kind)
+		}
+	}
+}
+
+func TestMatchersByKind(t *testing.T) {
+	bp := NewBypasser(false).(*bypasser)
+	if err := bp.Reload(strings.NewReader("10.0.0.0/8\n*.example.com\n192.168.0.0/16\n!www.example.com\n")); err != nil {
+		t.Fatal(err)
+	}
+	for kind, expected := range map[MatcherKind][]string{
+		KindCIDR:   {"cidr 10.0.0.0/8", "cidr 192.168.0.0/16"},
+		KindDomain: {"domain *.example.com", "!domain www.example.com"},
+		KindIP:     nil,
+	} {
+		var rules []string
+		for _, m := range bp.MatchersByKind(kind) {
+			rules = append(rules, m.String())
+		}
+		if strings.Join(rules, ", ") != strings.Join(expected, ", ") {
+			t.Errorf("%s: expected %q, got %q", kind, expected, rules)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package bypass
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// labelsPrefix is the prefix of the label count patterns, such as 'labels:1-2'.
+const labelsPrefix = "labels:"
+
+var errInvalidLabels = errors.New("bypass: invalid label count, expected labels:N, labels:N-M or labels:N-")
+
+type labelCountMatcher struct {
+	min, max int // no upper bound if max is zero
+}
+
+// LabelCountMatcher creates a Matcher for the hostnames whose number of labels is between min and max inclusive,
+// e.g. LabelCountMatcher(1, 1) matches the bare single-label hostnames such as 'localhost' or the internal short names.
+// A non-positive max leaves the number of labels unbounded.
+// The scheme and the port of an address are stripped before counting its labels, as is the trailing dot of a FQDN,
+// an IP address is not matched.
+// In the config, such a rule is the pseudo-pattern 'labels:N', 'labels:N-M' or 'labels:N-' for N labels or more.
+// It returns nil if min is not positive or max is lower than min.
+func LabelCountMatcher(min, max int) Matcher {
+	if max <= 0 {
+		max = 0
+	}
+	if min <= 0 || max > 0 && max < min {
+		return nil
+	}
+	return &labelCountMatcher{min: min, max: max}
+}
+
+// parseLabelCount parses the label count pattern s, such as 'labels:1-2'.
+// It returns false if s is not a label count pattern.
+func parseLabelCount(s string) (*labelCountMatcher, bool, error) {
+	v, ok := strings.CutPrefix(s, labelsPrefix)
+	if !ok {
+		return nil, false, nil
+	}
+	low, high, isRange := strings.Cut(v, "-")
+	min, err := strconv.Atoi(low)
+	if err != nil {
+		return nil, true, errInvalidLabels
+	}
+	max := min
+	if isRange {
+		max = 0
+		if high != "" {
+			if max, err = strconv.Atoi(high); err != nil || max <= 0 {
+				return nil, true, errInvalidLabels
+			}
+		}
+	}
+	m, _ := LabelCountMatcher(min, max).(*labelCountMatcher)
+	if m == nil {
+		return nil, true, errInvalidLabels
+	}
+	return m, true, nil
+}
+
+func (m *labelCountMatcher) Match(addr string) bool {
+	if m == nil {
+		return false
+	}
+	host, _ := splitHostPort(targetAddr(addr))
+	host = trimTrailingDot(host)
+	if host == "" {
+		return false
+	}
+	if ip, _ := parseIPZone(host); ip != nil {
+		return false
+	}
+	n := strings.Count(host, ".") + 1
+	return n >= m.min && (m.max == 0 || n <= m.max)
+}
+
+func (m *labelCountMatcher) String() string {
+	return "labels " + m.count()
+}
+
+// count returns the label count of the pattern of the matcher, such as '1-2'.
+func (m *labelCountMatcher) count() string {
+	switch m.max {
+	case m.min:
+		return strconv.Itoa(m.min)
+	case 0:
+		return strconv.Itoa(m.min) + "-"
+	default:
+		return strconv.Itoa(m.min) + "-" + strconv.Itoa(m.max)
+	}
+}
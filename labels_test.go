@@ -0,0 +1,78 @@
+package bypass
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLabelCountMatcher(t *testing.T) {
+	for _, tc := range []struct {
+		pattern string
+		addr    string
+		matched bool
+	}{
+		{"labels:1", "localhost", true},
+		{"labels:1", "intranet:8080", true},
+		{"labels:1", "http://intranet/path", true},
+		{"labels:1", "https://user@intranet:8443/path", true},
+		{"labels:1", "localhost.", true},
+		{"labels:1", "example.com", false},
+		{"labels:1", "www.example.com", false},
+		{"labels:1", "192.168.1.1", false},
+		{"labels:1", "[::1]:80", false},
+		{"labels:1", "", false},
+		{"labels:1-2", "localhost", true},
+		{"labels:1-2", "example.com:443", true},
+		{"labels:1-2", "www.example.com", false},
+		{"labels:3-", "www.example.com.", true},
+		{"labels:3-", "a.b.c.d.example.com", true},
+		{"labels:3-", "example.com", false},
+	} {
+		m, err := NewMatcherStrict(tc.pattern)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.pattern, err)
+		}
+		if m.Match(tc.addr) != tc.matched {
+			t.Errorf("%s %q: expected %v", tc.pattern, tc.addr, tc.matched)
+		}
+	}
+
+	for _, pattern := range []string{"labels:", "labels:0", "labels:-1", "labels:2-1", "labels:one", "labels:1-x", "labels:1-0"} {
+		if m, err := NewMatcherStrict(pattern); err == nil {
+			t.Errorf("%s: expected an error, got %v", pattern, m)
+		}
+	}
+	if LabelCountMatcher(0, 1) != nil || LabelCountMatcher(2, 1) != nil {
+		t.Errorf("expected a nil matcher for an invalid count")
+	}
+	for m, s := range map[Matcher]string{
+		LabelCountMatcher(1, 1):  "labels 1",
+		LabelCountMatcher(1, 2):  "labels 1-2",
+		LabelCountMatcher(3, 0):  "labels 3-",
+		LabelCountMatcher(3, -1): "labels 3-",
+	} {
+		if m.String() != s {
+			t.Errorf("expected %q, got %q", s, m.String())
+		}
+	}
+
+	// the bare hostnames are bypassed, whatever their port and scheme
+	bp := NewBypasser(false).(*bypasser)
+	if err := bp.Reload(strings.NewReader("labels:1\n*.example.com\n")); err != nil {
+		t.Fatal(err)
+	}
+	for addr, bypassed := range map[string]bool{
+		"localhost:8080":       true,
+		"http://intranet/path": true,
+		"www.example.com":      true,
+		"example.org:443":      false,
+		"10.0.0.1":             false,
+	} {
+		if bp.Bypass(addr) != bypassed {
+			t.Errorf("%s: expected %v", addr, bypassed)
+		}
+	}
+	if s, want := bp.PrunedConfig(0), "reverse false\ndefault proxy\nlabels:1\n*.example.com\n"; s != want {
+		t.Errorf("expected the config %q, got %q", want, s)
+	}
+}
@@ -0,0 +1,83 @@
+package bypass
+
+import (
+	"strings"
+)
+
+type prefixMatcher struct {
+	prefix string
+	fold   bool   // case-insensitive
+	raw    string // the pattern the matcher is compiled from, if any
+}
+
+// PrefixMatcher creates a Matcher for the addresses starting with prefix, a literal string,
+// such as the unix socket paths of '/var/run/*' in the config.
+func PrefixMatcher(prefix string) Matcher {
+	return &prefixMatcher{prefix: prefix}
+}
+
+func (m *prefixMatcher) Match(s string) bool {
+	if m == nil {
+		return false
+	}
+	if m.fold {
+		return len(s) >= len(m.prefix) && strings.EqualFold(s[:len(m.prefix)], m.prefix)
+	}
+	return strings.HasPrefix(s, m.prefix)
+}
+
+func (m *prefixMatcher) String() string {
+	return "prefix " + m.prefix
+}
+
+type suffixMatcher struct {
+	suffix string
+	fold   bool   // case-insensitive
+	raw    string // the pattern the matcher is compiled from, if any
+}
+
+// SuffixMatcher creates a Matcher for the addresses ending with suffix, a literal string,
+// such as the service names of '*-svc' in the config.
+func SuffixMatcher(suffix string) Matcher {
+	return &suffixMatcher{suffix: suffix}
+}
+
+func (m *suffixMatcher) Match(s string) bool {
+	if m == nil {
+		return false
+	}
+	if m.fold {
+		return len(s) >= len(m.suffix) && strings.EqualFold(s[len(s)-len(m.suffix):], m.suffix)
+	}
+	return strings.HasSuffix(s, m.suffix)
+}
+
+func (m *suffixMatcher) String() string {
+	return "suffix " + m.suffix
+}
+
+// compileLiteral compiles a pattern with a single wildcard at its start or its end into a SuffixMatcher or a PrefixMatcher,
+// for the tokens which do not look like a domain, without a dot or with a slash, such as 'svc-*' or '/var/run/*'.
+// The dotted patterns, such as '*.example.com', are domain patterns, as are the patterns compiled
+// with separators or a custom wildcard, and the non-ASCII ones, which are subject to the IDN normalization.
+// It returns false if the pattern is not such a literal.
+func (c *Compiler) compileLiteral(pattern string) (Matcher, bool) {
+	if c.Wildcard != 0 && c.Wildcard != '*' || len(c.separators()) > 0 {
+		return nil, false
+	}
+	if strings.Contains(pattern, ".") && !strings.Contains(pattern, "/") || !isASCII(pattern) {
+		return nil, false
+	}
+	prefix, suffix := strings.HasSuffix(pattern, "*"), strings.HasPrefix(pattern, "*")
+	if prefix == suffix {
+		return nil, false
+	}
+	literal := strings.TrimSuffix(strings.TrimPrefix(pattern, "*"), "*")
+	if literal == "" || hasGlobMeta(literal) || strings.ContainsAny(literal, ":^$") {
+		return nil, false
+	}
+	if prefix {
+		return &prefixMatcher{prefix: literal, fold: c.IgnoreCase, raw: pattern}, true
+	}
+	return &suffixMatcher{suffix: literal, fold: c.IgnoreCase, raw: pattern}, true
+}
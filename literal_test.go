@@ -0,0 +1,74 @@
+package bypass
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPrefixSuffixMatcher(t *testing.T) {
+	for _, tc := range []struct {
+		pattern string
+		addr    string
+		matched bool
+	}{
+		{"/var/run/*", "/var/run/docker.sock", true},
+		{"/var/run/*", "/var/run/", true},
+		{"/var/run/*", "/var/lib/docker.sock", false},
+		{"/var/run/*", "/VAR/run/docker.sock", false},
+		{"svc-*", "svc-payments", true},
+		{"svc-*", "svc-", true},
+		{"svc-*", "my-svc-payments", false},
+		{"*-svc", "payments-svc", true},
+		{"*-svc", "payments-svc-2", false},
+		{"*.sock", "/var/run/docker.sock", true},
+		{"*/docker.sock", "/var/run/docker.sock", true},
+	} {
+		m := NewMatcher(tc.pattern)
+		if m.Match(tc.addr) != tc.matched {
+			t.Errorf("%s %s: expected %v", tc.pattern, tc.addr, tc.matched)
+		}
+	}
+
+	for pattern, expected := range map[string]string{
+		"/var/run/*":    "prefix /var/run/",
+		"svc-*":         "prefix svc-",
+		"*-svc":         "suffix -svc",
+		"*/docker.sock": "suffix /docker.sock",
+		"!svc-*":        "!prefix svc-",
+		"svc*:80":       "prefix svc port 80",
+		// the dotted patterns are still domain patterns
+		"*.example.com": "domain *.example.com",
+		"www.example.*": "domain www.example.*",
+		"*.sock":        "domain *.sock",
+		// as are the patterns with other wildcards
+		"*svc*":     "domain *svc*",
+		"svc-?*":    "domain svc-?*",
+		"svc-[ab]*": "domain svc-[ab]*",
+	} {
+		bp := NewBypasser(false)
+		if err := bp.(*bypasser).Reload(strings.NewReader(pattern + "\n")); err != nil {
+			t.Fatalf("%s: %v", pattern, err)
+		}
+		if m := bp.(*bypasser).Matchers(); len(m) != 1 || m[0].String() != expected {
+			t.Errorf("%s: expected %q, got %v", pattern, expected, m)
+		}
+	}
+
+	c := &Compiler{IgnoreCase: true}
+	if m := mustCompile(t, c, "svc-*"); !m.Match("SVC-payments") {
+		t.Errorf("a case-insensitive prefix should match regardless of the case")
+	}
+	if m := mustCompile(t, c, "*-SVC"); !m.Match("payments-svc") {
+		t.Errorf("a case-insensitive suffix should match regardless of the case")
+	}
+	if m := mustCompile(t, &Compiler{Separators: []rune{'/'}}, "/var/run/*"); m.Match("/var/run/docker/docker.sock") {
+		t.Errorf("the wildcard should not match across the separators")
+	}
+
+	if PrefixMatcher("svc-").String() != "prefix svc-" || !SuffixMatcher(".sock").Match("docker.sock") {
+		t.Errorf("unexpected prefix or suffix matcher")
+	}
+	if text, err := NewMatcher("svc-*").(*prefixMatcher).MarshalText(); err != nil || string(text) != "svc-*" {
+		t.Errorf("unexpected text %q, %v", text, err)
+	}
+}
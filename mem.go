@@ -0,0 +1,101 @@
+package bypass
+
+import (
+	"net"
+	"unsafe"
+)
+
+const (
+	// the approximate size of the compiled glob program per byte of the expression.
+	globBytesPerChar = 48
+	// the approximate fixed size of a compiled glob program.
+	globOverhead = 64
+)
+
+// ApproxMemBytes returns an approximation of the memory retained by the rules of the bypass,
+// based on the sizes of the matchers and the length of their patterns.
+// It is intended to help operators size the hosts of very large rule sets.
+func (bp *bypasser) ApproxMemBytes() int {
+	bp.mux.RLock()
+	defer bp.mux.RUnlock()
+
+	n := int(unsafe.Sizeof(*bp))
+	n += cap(bp.matchers) * int(unsafe.Sizeof(Matcher(nil)))
+	for _, m := range bp.matchers {
+		n += matcherMemBytes(m)
+	}
+	if bp.index != nil {
+		n += bp.index.memBytes()
+	}
+	return n
+}
+
+func matcherMemBytes(matcher Matcher) int {
+	switch m := matcher.(type) {
+	case nil:
+		return 0
+	case *ipMatcher:
+		return int(unsafe.Sizeof(*m)) + len(m.ip)
+	case *cidrMatcher:
+		return int(unsafe.Sizeof(*m)) + int(unsafe.Sizeof(net.IPNet{})) + len(m.ipNet.IP) + len(m.ipNet.Mask) + len(m.raw)
+	case *domainMatcher:
+		return int(unsafe.Sizeof(*m)) + len(m.pattern) + len(m.expr) +
+			globOverhead + globBytesPerChar*len(m.expr)
+	case *domainGroupMatcher:
+		n := int(unsafe.Sizeof(*m)) + globOverhead
+		for _, expr := range m.exprs {
+			n += int(unsafe.Sizeof(expr)) + len(expr) + globBytesPerChar*len(expr)
+		}
+		return n
+	case *suffixTrieMatcher:
+		n := int(unsafe.Sizeof(*m)) + int(unsafe.Sizeof(*m.trie)) + m.trie.root.memBytes()
+		for _, expr := range m.exprs {
+			n += int(unsafe.Sizeof(expr)) + len(expr)
+		}
+		return n
+	case *rangeMatcher:
+		return int(unsafe.Sizeof(*m)) + len(m.low) + len(m.high)
+	case *prefixMatcher:
+		return int(unsafe.Sizeof(*m)) + len(m.prefix) + len(m.raw)
+	case *suffixMatcher:
+		return int(unsafe.Sizeof(*m)) + len(m.suffix) + len(m.raw)
+	case *labelCountMatcher:
+		return int(unsafe.Sizeof(*m))
+	case *regexMatcher:
+		return int(unsafe.Sizeof(*m)) + len(m.raw) + globOverhead + globBytesPerChar*len(m.raw)
+	case *resolveMatcher:
+		return int(unsafe.Sizeof(*m)) + len(m.host)
+	case *ptrMatcher:
+		return int(unsafe.Sizeof(*m)) + matcherMemBytes(m.domain)
+	case *anyMatcher:
+		return int(unsafe.Sizeof(*m)) + len(m.raw)
+	case *noneMatcher:
+		return int(unsafe.Sizeof(*m))
+	case *compositeMatcher:
+		n := int(unsafe.Sizeof(*m)) + cap(m.matchers)*int(unsafe.Sizeof(Matcher(nil)))
+		for _, matcher := range m.matchers {
+			n += matcherMemBytes(matcher)
+		}
+		return n
+	case *negatedMatcher:
+		return int(unsafe.Sizeof(*m)) + matcherMemBytes(m.Matcher)
+	case *expiringMatcher:
+		return int(unsafe.Sizeof(*m)) + matcherMemBytes(m.Matcher)
+	case *deniedMatcher:
+		return int(unsafe.Sizeof(*m)) + matcherMemBytes(m.Matcher)
+	case *schemeMatcher:
+		return int(unsafe.Sizeof(*m)) + matcherMemBytes(m.Matcher) + len(m.scheme) + len(m.raw)
+	case *portMatcher:
+		return int(unsafe.Sizeof(*m)) + matcherMemBytes(m.Matcher) + len(m.ports)*int(unsafe.Sizeof(0)) + len(m.raw) +
+			len(m.ranges)*int(unsafe.Sizeof(portRange{}))
+	case *qualifiedMatcher:
+		n := int(unsafe.Sizeof(*m)) + matcherMemBytes(m.Matcher) + len(m.ports)*int(unsafe.Sizeof(0))
+		for _, proto := range m.protos {
+			n += int(unsafe.Sizeof(proto)) + len(proto)
+		}
+		return n
+	default:
+		// unknown matcher, count its textual form only.
+		return len(m.String())
+	}
+}
@@ -0,0 +1,35 @@
+package bypass
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestApproxMemBytes(t *testing.T) {
+	size := func(n int) int {
+		var matchers []Matcher
+		for i := 0; i < n; i++ {
+			matchers = append(matchers,
+				NewMatcher(fmt.Sprintf("*.example%04d.com", i)),
+				NewMatcher(fmt.Sprintf("10.%d.%d.0/24", i/256, i%256)),
+				NewMatcher(fmt.Sprintf("192.%d.%d.1", i/256, i%256)),
+			)
+		}
+		return NewBypasser(false, matchers...).(*bypasser).ApproxMemBytes()
+	}
+
+	empty := size(0)
+	if empty <= 0 {
+		t.Fatalf("empty bypass should have a positive size, got %d", empty)
+	}
+
+	s1, s2, s4 := size(1000)-empty, size(2000)-empty, size(4000)-empty
+	if s1 <= 0 || s2 <= s1 || s4 <= s2 {
+		t.Fatalf("size should grow with the rules: %d, %d, %d", s1, s2, s4)
+	}
+	for _, r := range []float64{float64(s2) / float64(s1), float64(s4) / float64(s2)} {
+		if r < 1.8 || r > 2.2 {
+			t.Errorf("size should grow roughly linearly: %d, %d, %d", s1, s2, s4)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package bypass
+
+type mergedBypasser struct {
+	op        string // and, or
+	bypassers []Bypasser
+}
+
+// Merge creates a Bypasser bypassing an address if any of the bypassers does,
+// such as a global policy composed of independently managed rule sets, each with its own reversed flag.
+// The bypassers are evaluated in order until one bypasses the address.
+// The nil bypassers are skipped, a Bypasser merging none bypasses nothing.
+//
+// The merged Bypasser is read-only, it is not a ReloadableBypasser:
+// the bypassers are reloaded on their own and the merged one follows their changes.
+func Merge(bypassers ...Bypasser) Bypasser {
+	return newMergedBypasser("or", bypassers)
+}
+
+// MergeAnd creates a Bypasser bypassing an address if all of the bypassers do, as Merge does otherwise.
+// The bypassers are evaluated in order until one does not bypass the address.
+// The nil bypassers are skipped, a Bypasser merging none bypasses nothing.
+func MergeAnd(bypassers ...Bypasser) Bypasser {
+	return newMergedBypasser("and", bypassers)
+}
+
+func newMergedBypasser(op string, bypassers []Bypasser) *mergedBypasser {
+	bp := &mergedBypasser{op: op}
+	for _, b := range bypassers {
+		if b != nil {
+			bp.bypassers = append(bp.bypassers, b)
+		}
+	}
+	return bp
+}
+
+func (bp *mergedBypasser) Bypass(addr string) bool {
+	if bp == nil || len(bp.bypassers) == 0 {
+		return false
+	}
+
+	for _, b := range bp.bypassers {
+		bypassed := b.Bypass(addr)
+		if bp.op == "and" && !bypassed {
+			return false
+		}
+		if bp.op == "or" && bypassed {
+			return true
+		}
+	}
+	return bp.op == "and"
+}
@@ -0,0 +1,53 @@
+package bypass
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMerge(t *testing.T) {
+	// bypasses the corp networks
+	corp := NewBypasserPatterns(false, "10.0.0.0/8", "*.corp.example.com")
+	// bypasses all but the public domains
+	public := NewBypasserPatterns(true, "*.example.com", "*.example.org")
+
+	for _, tc := range []struct {
+		addr    string
+		or, and bool
+	}{
+		{"10.1.2.3", true, true},
+		{"www.corp.example.com", true, false},
+		{"www.example.com", false, false},
+		{"www.example.org", false, false},
+		{"192.168.1.1", true, false},
+		{"", false, false},
+	} {
+		if bypassed := Merge(corp, nil, public).Bypass(tc.addr); bypassed != tc.or {
+			t.Errorf("Merge %q: expected %v, got %v", tc.addr, tc.or, bypassed)
+		}
+		if bypassed := MergeAnd(corp, nil, public).Bypass(tc.addr); bypassed != tc.and {
+			t.Errorf("MergeAnd %q: expected %v, got %v", tc.addr, tc.and, bypassed)
+		}
+	}
+
+	for _, bp := range []Bypasser{Merge(), MergeAnd(), Merge(nil), MergeAnd(nil)} {
+		if bp.Bypass("10.1.2.3") {
+			t.Errorf("an empty merge should bypass nothing")
+		}
+	}
+	if _, ok := Merge(corp).(ReloadableBypasser); ok {
+		t.Errorf("a merged bypasser should be read-only")
+	}
+
+	// the merged bypasser follows the reloads of its bypassers
+	merged := Merge(corp, public)
+	if err := corp.(ReloadableBypasser).Reload(strings.NewReader("172.16.0.0/12\n")); err != nil {
+		t.Fatal(err)
+	}
+	if !merged.Bypass("172.16.1.1") || !merged.Bypass("10.1.2.3") {
+		t.Errorf("the merged bypasser should see the reloaded rules")
+	}
+	if merged.Bypass("www.example.com") {
+		t.Errorf("www.example.com should not be bypassed")
+	}
+}
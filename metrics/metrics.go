@@ -0,0 +1,66 @@
+// Package metrics exposes the matching statistics of the bypasses as Prometheus metrics,
+// it keeps the prometheus dependency out of the bypass package.
+package metrics
+
+import (
+	"github.com/go-gost/bypass"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	checksDesc = prometheus.NewDesc(
+		"bypass_checks_total",
+		"The number of the addresses checked by the bypass.",
+		[]string{"name"}, nil,
+	)
+	bypassedDesc = prometheus.NewDesc(
+		"bypass_bypassed_total",
+		"The number of the checked addresses bypassed by the bypass.",
+		[]string{"name"}, nil,
+	)
+	rulesDesc = prometheus.NewDesc(
+		"bypass_rules",
+		"The number of the rules of the bypass.",
+		[]string{"name"}, nil,
+	)
+)
+
+// statser is a bypass with matching statistics, as the ones of the bypass package.
+type statser interface {
+	Stats() bypass.Stats
+	Len() int
+}
+
+type collector struct {
+	bypassers []statser
+}
+
+// Collector returns a prometheus.Collector of the matching statistics of the bypassers, labeled by their name,
+// see the SetName of the bypass package: the number of the checked and of the bypassed addresses,
+// and the number of the rules of each bypass.
+// The statistics are read at each scrape, a ResetStats of a bypass shows as a counter reset.
+// The bypassers without statistics, such as the merged ones, are skipped.
+func Collector(bypassers ...bypass.Bypasser) prometheus.Collector {
+	c := &collector{}
+	for _, bp := range bypassers {
+		if s, ok := bp.(statser); ok {
+			c.bypassers = append(c.bypassers, s)
+		}
+	}
+	return c
+}
+
+func (c *collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- checksDesc
+	ch <- bypassedDesc
+	ch <- rulesDesc
+}
+
+func (c *collector) Collect(ch chan<- prometheus.Metric) {
+	for _, bp := range c.bypassers {
+		stats := bp.Stats()
+		ch <- prometheus.MustNewConstMetric(checksDesc, prometheus.CounterValue, float64(stats.Calls), stats.Name)
+		ch <- prometheus.MustNewConstMetric(bypassedDesc, prometheus.CounterValue, float64(stats.Bypassed), stats.Name)
+		ch <- prometheus.MustNewConstMetric(rulesDesc, prometheus.GaugeValue, float64(bp.Len()), stats.Name)
+	}
+}
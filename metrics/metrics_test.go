@@ -0,0 +1,62 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/go-gost/bypass"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCollector(t *testing.T) {
+	proxy := bypass.NewBypasserPatterns(false, "*.example.com", "10.0.0.0/8")
+	proxy.(interface{ SetName(string) }).SetName("proxy")
+	dns := bypass.NewBypasserPatterns(true, "*.internal")
+	dns.(interface{ SetName(string) }).SetName("dns")
+
+	c := Collector(proxy, dns, bypass.Merge(proxy, dns))
+	registry := prometheus.NewPedanticRegistry()
+	if err := registry.Register(c); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, addr := range []string{"www.example.com", "10.1.2.3:443", "example.org"} {
+		proxy.Bypass(addr)
+	}
+	dns.Bypass("db.internal")
+	dns.Bypass("example.org")
+
+	expected := `
+# HELP bypass_bypassed_total The number of the checked addresses bypassed by the bypass.
+# TYPE bypass_bypassed_total counter
+bypass_bypassed_total{name="dns"} 1
+bypass_bypassed_total{name="proxy"} 2
+# HELP bypass_checks_total The number of the addresses checked by the bypass.
+# TYPE bypass_checks_total counter
+bypass_checks_total{name="dns"} 2
+bypass_checks_total{name="proxy"} 3
+# HELP bypass_rules The number of the rules of the bypass.
+# TYPE bypass_rules gauge
+bypass_rules{name="dns"} 1
+bypass_rules{name="proxy"} 2
+`
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(expected)); err != nil {
+		t.Error(err)
+	}
+
+	// the counters follow the decisions
+	proxy.Bypass("api.example.com")
+	if n := testutil.CollectAndCount(c, "bypass_checks_total"); n != 2 {
+		t.Errorf("expected 2 series, got %d", n)
+	}
+	expected = `
+# HELP bypass_checks_total The number of the addresses checked by the bypass.
+# TYPE bypass_checks_total counter
+bypass_checks_total{name="dns"} 2
+bypass_checks_total{name="proxy"} 4
+`
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(expected), "bypass_checks_total"); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,31 @@
+package bypass
+
+type negatedMatcher struct {
+	Matcher
+}
+
+// NegateMatcher creates a Matcher for an exception to the rules:
+// an address matched by the Matcher m is not bypassed, whatever the reversed flag and the other rules
+// but the deny rules, see DenyMatcher.
+// In the config, such a rule is a pattern with a leading '!', e.g. '!secure.example.com'.
+func NegateMatcher(m Matcher) Matcher {
+	return &negatedMatcher{
+		Matcher: m,
+	}
+}
+
+func (m *negatedMatcher) Match(v string) bool {
+	if m == nil || m.Matcher == nil {
+		return false
+	}
+	return m.Matcher.Match(v)
+}
+
+func (m *negatedMatcher) String() string {
+	return "!" + m.Matcher.String()
+}
+
+func isNegated(m Matcher) bool {
+	_, ok := m.(*negatedMatcher)
+	return ok
+}
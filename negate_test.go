@@ -0,0 +1,64 @@
+package bypass
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNegation(t *testing.T) {
+	for i, tc := range []struct {
+		config   string
+		addr     string
+		bypassed bool
+	}{
+		{"*.example.com\n!secure.example.com", "www.example.com", true},
+		{"*.example.com\n!secure.example.com", "secure.example.com", false},
+		{"*.example.com\n!secure.example.com", "secure.example.com:443", false},
+		{"*.example.com\n!secure.example.com", "example.org", false},
+
+		// the order of the rules does not matter
+		{"!secure.example.com\n*.example.com", "secure.example.com", false},
+		{"!secure.example.com\n*.example.com", "www.example.com", true},
+
+		// the negated rules are not reversed
+		{"reverse true\n*.example.com\n!secure.example.com", "secure.example.com", false},
+		{"reverse true\n*.example.com\n!secure.example.com", "www.example.com", false},
+		{"reverse true\n*.example.com\n!secure.example.com", "example.org", true},
+
+		// any kind of rule can be negated
+		{"10.0.0.0/8\n!10.1.0.0/16", "10.2.3.4", true},
+		{"10.0.0.0/8\n!10.1.0.0/16", "10.1.2.3", false},
+		{"*.example.com\n!*.example.com:443", "www.example.com:443", false},
+		{"*.example.com\n!*.example.com:443", "www.example.com:80", true},
+		{"combine-domains true\n*.example.com\n*.example.org\n!secure.example.com", "secure.example.com", false},
+		{"index-suffixes true\n.example.com\n.example.org\n!secure.example.com", "secure.example.com", false},
+		{"index-suffixes true\n.example.com\n.example.org\n!secure.example.com", "www.example.com", true},
+	} {
+		bp := NewBypasser(false).(*bypasser)
+		if err := bp.Reload(strings.NewReader(tc.config)); err != nil {
+			t.Fatal(err)
+		}
+		if bp.Bypass(tc.addr) != tc.bypassed {
+			t.Errorf("#%d test failed: %q, %s", i, tc.config, tc.addr)
+		}
+	}
+}
+
+func TestNegateMatcher(t *testing.T) {
+	bp := NewBypasser(false, NewMatcher("*.example.com"), NegateMatcher(NewMatcher("secure.example.com"))).(*bypasser)
+
+	bypassed, m := bp.BypassMatch("secure.example.com")
+	if bypassed || m == nil || m.String() != "!domain secure.example.com" {
+		t.Errorf("the negated rule should match, got %v, %v", bypassed, m)
+	}
+	if bp.WouldFlip("secure.example.com") {
+		t.Errorf("the negated rule is not flipped by the reversed flag")
+	}
+	if !bp.WouldFlip("www.example.com") {
+		t.Errorf("the positive rule is flipped by the reversed flag")
+	}
+
+	if s, want := bp.PrunedConfig(0), "reverse false\ndefault proxy\n*.example.com\n!secure.example.com\n"; s != want {
+		t.Errorf("expected the config %q, got %q", want, s)
+	}
+}
@@ -0,0 +1,226 @@
+package bypass
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// the resolved service names, the services file is static.
+// The unknown names are not stored, so that the addresses of the callers do not grow it,
+// it is bounded by the services known to the system.
+var servicePorts sync.Map // map[string]int
+
+// parsePort parses a numeric port or resolves a service name such as 'https' to its port number.
+// It returns zero if the port is invalid or the service is unknown.
+func parsePort(s string) int {
+	if s == "" {
+		return 0
+	}
+	if p, err := strconv.Atoi(s); err == nil {
+		if p > 0 && p <= 65535 {
+			return p
+		}
+		return 0
+	}
+
+	if v, ok := servicePorts.Load(s); ok {
+		return v.(int)
+	}
+	if !isServiceName(s) {
+		return 0
+	}
+	p, err := net.LookupPort("tcp", s)
+	if err != nil {
+		p, err = net.LookupPort("udp", s)
+	}
+	if err != nil || p <= 0 || p > 65535 {
+		return 0
+	}
+	servicePorts.Store(s, p)
+	return p
+}
+
+// isServiceName reports whether s is a valid service name, up to 15 letters, digits and hyphens
+// with at least one letter and no leading, trailing or double hyphen, see RFC 6335.
+func isServiceName(s string) bool {
+	if s == "" || len(s) > 15 || s[0] == '-' || s[len(s)-1] == '-' || strings.Contains(s, "--") {
+		return false
+	}
+	letter := false
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+			letter = true
+		case c >= '0' && c <= '9' || c == '-':
+		default:
+			return false
+		}
+	}
+	return letter
+}
+
+// portRange is an inclusive range of ports, such as 8000-8099.
+type portRange struct {
+	low, high int
+}
+
+type portMatcher struct {
+	Matcher
+	ports  []int  // any port if empty, and ranges as well
+	raw    string // the pattern the matcher is compiled from, if any
+	ranges []portRange
+}
+
+// PortMatcher creates a Matcher restricting the Matcher m of the host to the given ports,
+// or to any port if ports is empty.
+// It matches an address in the host:port form, such as 'example.com:80' or '[::1]:443',
+// an address without port is not matched.
+func PortMatcher(m Matcher, ports ...int) Matcher {
+	return &portMatcher{
+		Matcher: m,
+		ports:   ports,
+	}
+}
+
+func (m *portMatcher) Match(addr string) bool {
+	if m == nil || m.Matcher == nil {
+		return false
+	}
+	host, port := splitHostPort(addr)
+	if port == 0 || !m.matchPort(port) {
+		return false
+	}
+	return m.Matcher.Match(host)
+}
+
+func (m *portMatcher) matchPort(port int) bool {
+	if len(m.ports) == 0 && len(m.ranges) == 0 {
+		return true
+	}
+	for _, p := range m.ports {
+		if p == port {
+			return true
+		}
+	}
+	for _, r := range m.ranges {
+		if r.low <= port && port <= r.high {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *portMatcher) String() string {
+	if len(m.ports) == 0 && len(m.ranges) == 0 {
+		return m.Matcher.String() + " port *"
+	}
+	var ports []string
+	for _, p := range m.ports {
+		ports = append(ports, strconv.Itoa(p))
+	}
+	for _, r := range m.ranges {
+		ports = append(ports, strconv.Itoa(r.low)+"-"+strconv.Itoa(r.high))
+	}
+	return m.Matcher.String() + " port " + strings.Join(ports, ",")
+}
+
+// matchesAddr reports whether the matcher m matches the whole address, with its port, instead of the host.
+// A LabelCountMatcher strips the scheme and the port of the address itself.
+func matchesAddr(matcher Matcher) bool {
+	switch m := matcher.(type) {
+	case *portMatcher, *schemeMatcher, *labelCountMatcher:
+		return true
+	case *qualifiedMatcher:
+		return matchesAddr(m.Matcher)
+	case *negatedMatcher:
+		return matchesAddr(m.Matcher)
+	case *deniedMatcher:
+		return matchesAddr(m.Matcher)
+	case *expiringMatcher:
+		return matchesAddr(m.Matcher)
+	case *compositeMatcher:
+		for _, matcher := range m.matchers {
+			if matchesAddr(matcher) {
+				return true
+			}
+		}
+		return false
+	default:
+		return false
+	}
+}
+
+// splitPatternPort splits a host:port pattern, such as 'example.com:80' or '[::1]:443', into host and port.
+// It returns false if the pattern has no port.
+func splitPatternPort(pattern string) (string, string, bool) {
+	i := strings.LastIndexByte(pattern, ':')
+	if i < 0 {
+		return "", "", false
+	}
+	host, port := pattern[:i], pattern[i+1:]
+	if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") && strings.Contains(host, ":") {
+		host = host[1 : len(host)-1]
+	} else if strings.Contains(host, ":") {
+		return "", "", false
+	}
+	return host, port, host != ""
+}
+
+// parsePortPattern parses the port of a host:port pattern,
+// a numeric port, a service name such as 'https' or '*' for any port,
+// a range of numeric ports such as '8000-8099' or a comma-separated set of them, e.g. '80,443,8000-8099'.
+// It returns false if s is not a port, and an error if s is a malformed set or range,
+// e.g. with a bound out of 1-65535 or the low bound after the high one.
+func parsePortPattern(s string) ([]int, []portRange, bool, error) {
+	if s == "*" {
+		return nil, nil, true, nil
+	}
+	if p := parsePort(s); p > 0 {
+		return []int{p}, nil, true, nil
+	}
+	if !strings.ContainsAny(s, ",-") {
+		return nil, nil, false, nil
+	}
+
+	var ports []int
+	var ranges []portRange
+	for _, v := range strings.Split(s, ",") {
+		if p := parsePort(v); p > 0 {
+			ports = append(ports, p)
+			continue
+		}
+		low, high, ok := strings.Cut(v, "-")
+		if !ok || !isDigits(low) || !isDigits(high) {
+			if !strings.Contains(s, ",") {
+				// not a port, such as the domain of 'www.example.com:*-api'
+				return nil, nil, false, nil
+			}
+			return nil, nil, false, fmt.Errorf("bypass: invalid port %q in %q", v, s)
+		}
+		r := portRange{low: parsePort(low), high: parsePort(high)}
+		if r.low == 0 || r.high == 0 {
+			return nil, nil, false, fmt.Errorf("bypass: port range %q out of 1-65535", v)
+		}
+		if r.low > r.high {
+			return nil, nil, false, fmt.Errorf("bypass: port range %q with the low end after the high end", v)
+		}
+		ranges = append(ranges, r)
+	}
+	return ports, ranges, true, nil
+}
+
+// isDigits reports whether s is a non-empty string of decimal digits.
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,167 @@
+package bypass
+
+import (
+	"fmt"
+	"net"
+	"testing"
+)
+
+var parsePortTests = []struct {
+	s    string
+	port int
+}{
+	{"80", 80},
+	{"443", 443},
+	{"65535", 65535},
+	{"http", 80},
+	{"https", 443},
+	{"ssh", 22},
+	{"domain", 53},
+	{"", 0},
+	{"0", 0},
+	{"-1", 0},
+	{"65536", 0},
+	{"nosuchservice", 0},
+	{"*", 0},
+	{"http-alt-service", 0}, // too long for a service name
+	{"-http", 0},
+	{"ht_tp", 0},
+}
+
+func TestParsePort(t *testing.T) {
+	for i, tc := range parsePortTests {
+		tc := tc
+		t.Run(fmt.Sprintf("#%d", i), func(t *testing.T) {
+			// twice, the second one from the cache
+			for j := 0; j < 2; j++ {
+				if p := parsePort(tc.s); p != tc.port {
+					t.Errorf("#%d test failed: %s, expected %d, got %d", i, tc.s, tc.port, p)
+				}
+			}
+		})
+	}
+}
+
+func TestParsePortUnknownNotCached(t *testing.T) {
+	count := func() int {
+		n := 0
+		servicePorts.Range(func(_, _ any) bool { n++; return true })
+		return n
+	}
+	parsePort("https")
+	before := count()
+	for i := 0; i < 100; i++ {
+		if parsePort(fmt.Sprintf("aaaa%d", i)) != 0 {
+			t.Fatalf("aaaa%d should not be a known service", i)
+		}
+	}
+	if n := count(); n != before {
+		t.Errorf("the unknown service names should not be cached, %d cached names instead of %d", n, before)
+	}
+}
+
+func TestServiceNamePorts(t *testing.T) {
+	bp := NewBypasser(false,
+		QualifyMatcher(NewMatcher("*.example.com"), []int{443}, nil),
+		QualifyMatcher(NewMatcher("dns.example.org"), []int{53}, nil),
+	)
+	for i, tc := range []struct {
+		addr     string
+		bypassed bool
+	}{
+		{"www.example.com:https", true},
+		{"www.example.com:443", true},
+		{"www.example.com:http", false},
+		{"dns.example.org:domain", true},
+		{"dns.example.org:53", true},
+		{"dns.example.org:ssh", false},
+	} {
+		if bp.Bypass(tc.addr) != tc.bypassed {
+			t.Errorf("#%d test failed: %s", i, tc.addr)
+		}
+	}
+}
+
+func TestPortMatcher(t *testing.T) {
+	m := PortMatcher(DomainMatcher("*.example.com"), 80, 443)
+	for _, tc := range []struct {
+		addr    string
+		matched bool
+	}{
+		{"www.example.com:80", true},
+		{"www.example.com:https", true},
+		{"www.example.com:8080", false},
+		{"www.example.com", false},
+		{"www.example.org:80", false},
+	} {
+		if m.Match(tc.addr) != tc.matched {
+			t.Errorf("test failed: %s", tc.addr)
+		}
+	}
+	if s, want := m.String(), "domain *.example.com port 80,443"; s != want {
+		t.Errorf("expected %q, got %q", want, s)
+	}
+	if s, want := PortMatcher(IPMatcher(net.ParseIP("::1"))).String(), "ip ::1 port *"; s != want {
+		t.Errorf("expected %q, got %q", want, s)
+	}
+
+	bp := NewBypasserPatterns(false, "example.com:http", "[::1]:*").(*bypasser)
+	if s, want := bp.PrunedConfig(0), "reverse false\ndefault proxy\nexample.com:http\n[::1]:*\n"; s != want {
+		t.Errorf("expected the config %q, got %q", want, s)
+	}
+}
+
+func TestPortRanges(t *testing.T) {
+	for _, tc := range []struct {
+		pattern string
+		addr    string
+		matched bool
+	}{
+		{"example.com:8000-8099", "example.com:8000", true},
+		{"example.com:8000-8099", "example.com:8050", true},
+		{"example.com:8000-8099", "example.com:8099", true},
+		{"example.com:8000-8099", "example.com:7999", false},
+		{"example.com:8000-8099", "example.com:8100", false},
+		{"example.com:8000-8099", "example.com", false},
+		{"example.com:80,443", "example.com:80", true},
+		{"example.com:80,443", "example.com:https", true},
+		{"example.com:80,443", "example.com:8080", false},
+		{"*.example.com:http,8000-8099", "www.example.com:8080", true},
+		{"*.example.com:http,8000-8099", "www.example.com:80", true},
+		{"*.example.com:http,8000-8099", "www.example.com:8100", false},
+		{"10.0.0.0/8:1-1024", "10.1.2.3:1", true},
+		{"10.0.0.0/8:1-1024", "10.1.2.3:1025", false},
+		{"[::1]:1-65535", "[::1]:65535", true},
+		{"example.com:443-443", "example.com:443", true},
+	} {
+		m, err := NewMatcherStrict(tc.pattern)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.pattern, err)
+		}
+		if m.Match(tc.addr) != tc.matched {
+			t.Errorf("%s %s: expected %v", tc.pattern, tc.addr, tc.matched)
+		}
+	}
+
+	for _, pattern := range []string{
+		"example.com:0-80",
+		"example.com:8000-65536",
+		"example.com:8099-8000",
+		"example.com:80,",
+		"example.com:80,nosuchservice",
+		"example.com:80,8000-",
+	} {
+		if m, err := NewMatcherStrict(pattern); err == nil {
+			t.Errorf("%s: expected an error, got %v", pattern, m)
+		}
+	}
+
+	m := NewMatcher("example.com:80,443,8000-8099")
+	if s, want := m.String(), "domain example.com port 80,443,8000-8099"; s != want {
+		t.Errorf("expected %q, got %q", want, s)
+	}
+	bp := NewBypasserPatterns(false, "example.com:80,443,8000-8099").(*bypasser)
+	if s, want := bp.PrunedConfig(0), "reverse false\ndefault proxy\nexample.com:80,443,8000-8099\n"; s != want {
+		t.Errorf("expected the config %q, got %q", want, s)
+	}
+}
@@ -0,0 +1,140 @@
+package bypass
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+// ptrCacheSize bounds the number of the IP addresses whose names are cached by a PTRMatcher.
+const ptrCacheSize = 1024
+
+// addrResolver looks up the names of an IP address, as net.Resolver does.
+type addrResolver interface {
+	LookupAddr(ctx context.Context, addr string) ([]string, error)
+}
+
+type ptrEntry struct {
+	names   []string
+	expires time.Time
+}
+
+type ptrMatcher struct {
+	domain   *domainMatcher
+	resolver addrResolver
+	ttl      time.Duration
+	timeout  time.Duration
+
+	mux     sync.Mutex
+	entries map[string]ptrEntry // by IP address
+}
+
+// PTRMatcher creates a Matcher for the IP addresses whose reverse DNS names match the domain pattern,
+// e.g. '*.amazonaws.com' matches the addresses of the EC2 instances.
+// The pattern is a case-insensitive domain pattern, see DomainMatcher.
+// The names of an address are looked up by resolver, net.DefaultResolver if nil, when it is matched,
+// and then cached for a short time, a failed lookup as well.
+// As ResolveMatcher, it is never created from a config, is best used with SetMatchCache,
+// and the returned Matcher is a ContextMatcher.
+// It returns nil if the pattern is empty or malformed.
+func PTRMatcher(pattern string, resolver *net.Resolver) Matcher {
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	m := newPTRMatcher(pattern, resolver)
+	if m == nil {
+		return nil
+	}
+	return m
+}
+
+func newPTRMatcher(pattern string, resolver addrResolver) *ptrMatcher {
+	if pattern == "" {
+		return nil
+	}
+	m, err := (&Compiler{IgnoreCase: true}).compileDomain(pattern)
+	if err != nil {
+		return nil
+	}
+	return &ptrMatcher{
+		domain:   m.(*domainMatcher),
+		resolver: resolver,
+		ttl:      resolveTTL,
+		timeout:  resolveTimeout,
+		entries:  make(map[string]ptrEntry),
+	}
+}
+
+func (m *ptrMatcher) Match(ip string) bool {
+	return m.MatchContext(context.Background(), ip)
+}
+
+// MatchContext matches the IP address ip as Match does, the lookup is aborted once ctx is cancelled.
+func (m *ptrMatcher) MatchContext(ctx context.Context, ip string) bool {
+	if m == nil {
+		return false
+	}
+	addr, _ := parseIPZone(ip)
+	if addr == nil {
+		return false
+	}
+	for _, name := range m.lookup(ctx, addr.String()) {
+		if m.domain.Match(name) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *ptrMatcher) prefetch(ip string) {
+	if addr, _ := parseIPZone(ip); addr != nil {
+		m.lookup(context.Background(), addr.String())
+	}
+}
+
+func (m *ptrMatcher) lookupTTL() time.Duration {
+	return m.ttl
+}
+
+// lookup returns the names of the IP address addr, from the cache if they have not expired.
+// A lookup aborted by the cancellation of ctx is not cached, the next match retries it.
+func (m *ptrMatcher) lookup(ctx context.Context, addr string) []string {
+	now := time.Now()
+	m.mux.Lock()
+	entry, ok := m.entries[addr]
+	m.mux.Unlock()
+	if ok && now.Before(entry.expires) {
+		return entry.names
+	}
+
+	lookupCtx, cancel := context.WithTimeout(ctx, m.timeout)
+	defer cancel()
+
+	names, err := m.resolver.LookupAddr(lookupCtx, addr)
+	if ctx.Err() != nil {
+		return nil
+	}
+	if err != nil {
+		names = nil
+	}
+
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if len(m.entries) >= ptrCacheSize {
+		for k, e := range m.entries {
+			if !now.Before(e.expires) {
+				delete(m.entries, k)
+			}
+		}
+		if len(m.entries) >= ptrCacheSize {
+			clear(m.entries)
+		}
+	}
+	m.entries[addr] = ptrEntry{names: names, expires: time.Now().Add(m.ttl)}
+	return names
+}
+
+func (m *ptrMatcher) String() string {
+	return "ptr " + m.domain.raw
+}
@@ -0,0 +1,128 @@
+package bypass
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeAddrResolver resolves the IP addresses to their names in a map, counting the lookups.
+type fakeAddrResolver struct {
+	names   map[string][]string
+	block   bool // blocks until the lookup is cancelled
+	lookups int
+	mux     sync.Mutex
+}
+
+func (r *fakeAddrResolver) LookupAddr(ctx context.Context, addr string) ([]string, error) {
+	r.mux.Lock()
+	r.lookups++
+	block, names := r.block, r.names[addr]
+	r.mux.Unlock()
+
+	if block {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	if names == nil {
+		return nil, errors.New("no such host")
+	}
+	return names, nil
+}
+
+func TestPTRMatcher(t *testing.T) {
+	r := &fakeAddrResolver{names: map[string][]string{
+		"192.0.2.10":   {"ec2-192-0-2-10.compute-1.amazonaws.com."},
+		"192.0.2.20":   {"host.example.com.", "EC2-192-0-2-20.eu-west-1.compute.AMAZONAWS.com."},
+		"2001:db8::10": {"ec2.amazonaws.com.evil.example."},
+	}}
+	m := newPTRMatcher("*.amazonaws.com", r)
+
+	for _, tc := range []struct {
+		addr    string
+		matched bool
+	}{
+		{"192.0.2.10", true},
+		{"::ffff:192.0.2.10", true},
+		{"192.0.2.20", true},
+		{"2001:db8::10", false},
+		{"192.0.2.30", false},
+		{"ec2.amazonaws.com", false},
+		{"", false},
+	} {
+		if matched := m.Match(tc.addr); matched != tc.matched {
+			t.Errorf("%q: expected %v, got %v", tc.addr, tc.matched, matched)
+		}
+	}
+	if r.lookups != 4 {
+		t.Errorf("expected 4 lookups, got %d", r.lookups)
+	}
+
+	// the names and the failed lookups are cached until they expire
+	m.Match("192.0.2.10")
+	m.Match("192.0.2.30")
+	if r.lookups != 4 {
+		t.Errorf("the lookups should be cached, got %d lookups", r.lookups)
+	}
+	m.ttl = time.Millisecond
+	clear(m.entries)
+	m.Match("192.0.2.10")
+	time.Sleep(2 * time.Millisecond)
+	m.Match("192.0.2.10")
+	if r.lookups != 6 {
+		t.Errorf("the expired names should be looked up again, got %d lookups", r.lookups)
+	}
+
+	if s := m.String(); s != "ptr *.amazonaws.com" {
+		t.Errorf("unexpected String %q", s)
+	}
+	for _, pattern := range []string{"", "[unterminated"} {
+		if PTRMatcher(pattern, nil) != nil {
+			t.Errorf("%q: expected a nil matcher", pattern)
+		}
+	}
+}
+
+func TestPTRMatcherTimeout(t *testing.T) {
+	r := &fakeAddrResolver{block: true}
+	m := newPTRMatcher("*.amazonaws.com", r)
+	m.timeout = 10 * time.Millisecond
+
+	start := time.Now()
+	if m.Match("192.0.2.10") {
+		t.Errorf("a timed out lookup should match nothing")
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("the lookup should time out, took %v", d)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r.mux.Lock()
+	r.block = false
+	r.names = map[string][]string{"192.0.2.20": {"ec2.amazonaws.com."}}
+	r.mux.Unlock()
+	if m.MatchContext(ctx, "192.0.2.20") || !m.Match("192.0.2.20") {
+		t.Errorf("a cancelled lookup should not be cached")
+	}
+}
+
+func TestPTRMatcherBypass(t *testing.T) {
+	r := &fakeAddrResolver{names: map[string][]string{"192.0.2.10": {"ec2.amazonaws.com."}}}
+	bp := NewBypasser(false, newPTRMatcher("*.amazonaws.com", r), NewMatcher("*.example.com")).(*bypasser)
+	for addr, bypassed := range map[string]bool{
+		"192.0.2.10:443":      true,
+		"192.0.2.11:443":      false,
+		"www.example.com:443": true,
+		"ec2.amazonaws.com":   false,
+	} {
+		if bp.Bypass(addr) != bypassed {
+			t.Errorf("%s: expected %v", addr, bypassed)
+		}
+	}
+	if r.lookups != 2 {
+		t.Errorf("the domains should not be looked up, got %d lookups", r.lookups)
+	}
+}
@@ -0,0 +1,53 @@
+package bypass
+
+import (
+	"regexp"
+	"strings"
+)
+
+type regexMatcher struct {
+	re  *regexp.Regexp
+	raw string // the pattern the matcher is compiled from, without the slashes
+}
+
+// RegexMatcher creates a Matcher for the regular expression re.
+// In the config, such a rule is a pattern wrapped in slashes, e.g. '/^api-\d+\.example\.com$/'.
+// The expression is unanchored, it matches if it matches any part of the host.
+func RegexMatcher(re *regexp.Regexp) Matcher {
+	return &regexMatcher{
+		re:  re,
+		raw: re.String(),
+	}
+}
+
+func (m *regexMatcher) Match(host string) bool {
+	if m == nil || m.re == nil {
+		return false
+	}
+	return m.re.MatchString(host)
+}
+
+func (m *regexMatcher) String() string {
+	return "regex " + m.raw
+}
+
+// isRegexPattern reports whether the pattern is a regular expression wrapped in slashes.
+func isRegexPattern(pattern string) bool {
+	return len(pattern) >= 2 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/")
+}
+
+func (c *Compiler) compileRegex(pattern string) (Matcher, error) {
+	raw := pattern[1 : len(pattern)-1]
+	expr := raw
+	if c.IgnoreCase {
+		expr = "(?i)" + expr
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	return &regexMatcher{
+		re:  re,
+		raw: raw,
+	}, nil
+}
@@ -0,0 +1,75 @@
+package bypass
+
+import (
+	"fmt"
+	"regexp"
+	"testing"
+)
+
+var regexTests = []struct {
+	pattern string
+	addr    string
+	matched bool
+}{
+	{`/^api-\d+\.example\.com$/`, "api-42.example.com", true},
+	{`/^api-\d+\.example\.com$/`, "api-x.example.com", false},
+	{`/^api-\d+\.example\.com$/`, "www.api-42.example.com", false},
+	{`/^(\d+\.){3}example\.com$/`, "1.2.3.example.com", true},
+	{`/^(\d+\.){3}example\.com$/`, "1.2.example.com", false},
+	{`/example\.(com|net)$/`, "www.example.net", true},
+	{`/example\.(com|net)$/`, "www.example.org", false},
+	{`/example/`, "www.example.org", true},
+	{`//`, "www.example.org", true},
+}
+
+func TestRegexMatcher(t *testing.T) {
+	for i, tc := range regexTests {
+		tc := tc
+		t.Run(fmt.Sprintf("#%d", i), func(t *testing.T) {
+			m := NewMatcher(tc.pattern)
+			if m == nil {
+				t.Fatalf("#%d %s should compile", i, tc.pattern)
+			}
+			if m.Match(tc.addr) != tc.matched {
+				t.Errorf("#%d test failed: %s, %s", i, tc.pattern, tc.addr)
+			}
+		})
+	}
+}
+
+func TestRegexMatcherErrors(t *testing.T) {
+	for _, pattern := range []string{`/api-(\d+/`, `/[a-/`, `/*/`} {
+		if m := NewMatcher(pattern); m != nil {
+			t.Errorf("NewMatcher should return nil for %s", pattern)
+		}
+		if _, err := DefaultCompiler.Compile(pattern); err == nil {
+			t.Errorf("expected an error for %s", pattern)
+		}
+	}
+}
+
+func TestRegexMatcherString(t *testing.T) {
+	m := NewMatcher(`/^api-\d+\.example\.com$/`)
+	if s, want := m.String(), `regex ^api-\d+\.example\.com$`; s != want {
+		t.Errorf("expected %q, got %q", want, s)
+	}
+	if s, want := RegexMatcher(regexp.MustCompile(`^www\.`)).String(), `regex ^www\.`; s != want {
+		t.Errorf("expected %q, got %q", want, s)
+	}
+
+	m, err := (&Compiler{IgnoreCase: true}).Compile(`/^API\.example\.com$/`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !m.Match("api.EXAMPLE.com") || m.String() != `regex ^API\.example\.com$` {
+		t.Errorf("the regex should be case-insensitive: %s", m)
+	}
+
+	bp := NewBypasserPatterns(false, `/^api-\d+\.example\.com$/`).(*bypasser)
+	if !bp.Bypass("api-1.example.com:443") {
+		t.Errorf("the regex should match the host without the port")
+	}
+	if s, want := bp.PrunedConfig(0), "reverse false\ndefault proxy\n/^api-\\d+\\.example\\.com$/\n"; s != want {
+		t.Errorf("expected the config %q, got %q", want, s)
+	}
+}
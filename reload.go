@@ -0,0 +1,244 @@
+package bypass
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// ReloadFromWatcher reads config payloads from ch and live reloads the bypass on each one,
+// until ch is closed, ctx is cancelled or the bypass is stopped.
+// It is a generic bridge for watch mechanisms such as Consul or etcd key watches.
+// An error in a single payload is logged and does not stop the loop.
+func (bp *bypasser) ReloadFromWatcher(ctx context.Context, ch <-chan []byte) error {
+	return reloadLoop(ctx, bp, ch, func(b []byte) bool {
+		if err := bp.Reload(bytes.NewReader(b)); err != nil {
+			bp.logf("reload from watcher: %v", err)
+		}
+		return true
+	})
+}
+
+// ReloadFrom reads configs from ch and live reloads the bypass on each one, as pushed by a control plane stream,
+// until ch is closed, ctx is cancelled or the bypass is stopped. A reader implementing io.Closer is closed after the reload.
+// The error of each reload, nil if it succeeded, is sent to errs, or logged if errs is nil,
+// an error does not stop the loop. The sends to errs block until received, ctx is cancelled or the bypass is stopped.
+// See OnReload to be notified of the rules changed by the reloads instead.
+func (bp *bypasser) ReloadFrom(ctx context.Context, ch <-chan io.Reader, errs chan<- error) error {
+	return reloadLoop(ctx, bp, ch, func(r io.Reader) bool {
+		err := bp.reloadFrom(func() (io.Reader, error) { return r, nil })
+		if errs == nil {
+			if err != nil {
+				bp.logf("reload from channel: %v", err)
+			}
+			return true
+		}
+		select {
+		case errs <- err:
+			return true
+		case <-ctx.Done():
+			return false
+		case <-bp.stopped:
+			return false
+		}
+	})
+}
+
+// reloadLoop calls reload on each value received from ch, the config of a reload of the bypass bp,
+// until ch is closed, ctx is cancelled, bp is stopped or reload returns false.
+// It returns the error of ctx if cancelled, nil otherwise.
+func reloadLoop[T any](ctx context.Context, bp *bypasser, ch <-chan T, reload func(T) bool) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-bp.stopped:
+			return nil
+		case v, ok := <-ch:
+			if !ok {
+				return nil
+			}
+			if !reload(v) {
+				return ctx.Err()
+			}
+		}
+	}
+}
+
+// Start starts live reloading the bypass in a goroutine: it waits for the current Period,
+// reloads the bypass from the config returned by getReader, and repeats
+// until ctx is cancelled or the bypass is stopped.
+// While the period is not positive, it idles until a reload sets a positive period.
+// A reader implementing io.Closer is closed after the reload.
+// The errors of getReader and Reload are logged and do not stop the loop.
+// The context is the lifetime of the live reloading: cancelling it stops the bypass as Stop does,
+// and Stop ends the loop as promptly.
+func (bp *bypasser) Start(ctx context.Context, getReader func() (io.Reader, error)) {
+	go bp.reloadLoop(ctx, getReader)
+}
+
+func (bp *bypasser) reloadLoop(ctx context.Context, getReader func() (io.Reader, error)) {
+	for {
+		period, reloaded := bp.reloadPeriod()
+
+		var timer *time.Timer
+		var tick <-chan time.Time
+		if period > 0 {
+			timer = time.NewTimer(period)
+			tick = timer.C
+			// the period is only changed by a reload,
+			// which is waited for while idle only.
+			reloaded = nil
+		}
+
+		select {
+		case <-ctx.Done():
+			bp.Stop()
+		case <-bp.stopped:
+		case <-reloaded:
+			continue
+		case <-tick:
+			if err := bp.reloadFrom(getReader); err != nil {
+				bp.logf("reload: %v", err)
+			}
+			continue
+		}
+
+		if timer != nil {
+			timer.Stop()
+		}
+		return
+	}
+}
+
+// stopContext returns a copy of ctx which is cancelled as well once the bypass is stopped,
+// so that the requests of a reloader are aborted by Stop.
+func (bp *bypasser) stopContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-bp.stopped:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// reloadPeriod returns the reload period and a channel closed on the next reload.
+func (bp *bypasser) reloadPeriod() (time.Duration, <-chan struct{}) {
+	bp.mux.Lock()
+	defer bp.mux.Unlock()
+
+	if bp.reloaded == nil {
+		bp.reloaded = make(chan struct{})
+	}
+	return bp.period, bp.reloaded
+}
+
+func (bp *bypasser) reloadFrom(getReader func() (io.Reader, error)) error {
+	r, err := getReader()
+	if err != nil {
+		return err
+	}
+	if c, ok := r.(io.Closer); ok {
+		defer c.Close()
+	}
+	return bp.Reload(r)
+}
+
+const (
+	tailMinBackoff = 1 * time.Second
+	tailMaxBackoff = 30 * time.Second
+)
+
+// TailURL live reloads the bypass from the config served at url in long-polling mode:
+// the request is re-issued as soon as a response arrives, passing the ETag of the last body
+// in the If-None-Match header, and each new body is applied as it arrives.
+// The server is expected to hold the request open until the config changes,
+// the requests are at least tailMinBackoff apart nonetheless, so that a server answering at once is not polled in a tight loop.
+// On errors it backs off before retrying. It returns when ctx is cancelled or the bypass is stopped.
+// If client is nil, http.DefaultClient is used.
+func (bp *bypasser) TailURL(ctx context.Context, client *http.Client, url string) error {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	ctx, cancel := bp.stopContext(ctx)
+	defer cancel()
+
+	var etag string
+	backoff := tailMinBackoff
+	for {
+		start := time.Now()
+		body, tag, err := fetchConfig(ctx, client, url, etag)
+		if err == nil && body != nil {
+			err = bp.Reload(bytes.NewReader(body))
+			etag = tag
+		}
+
+		if ctx.Err() != nil {
+			if bp.Stopped() {
+				return nil
+			}
+			return ctx.Err()
+		}
+
+		if err == nil {
+			backoff = tailMinBackoff
+			// the response did not hold the request, such as an immediate 304
+			if wait := tailMinBackoff - time.Since(start); wait > 0 {
+				select {
+				case <-ctx.Done():
+				case <-time.After(wait):
+				}
+			}
+			continue
+		}
+
+		bp.logf("tail %s: %v", url, err)
+		select {
+		case <-ctx.Done():
+			continue
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > tailMaxBackoff {
+			backoff = tailMaxBackoff
+		}
+	}
+}
+
+// fetchConfig requests the config at url, a nil body is returned if it is not modified since etag.
+func fetchConfig(ctx context.Context, client *http.Client, url string, etag string) ([]byte, string, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	req = req.WithContext(ctx)
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotModified:
+		return nil, etag, nil
+	default:
+		return nil, "", fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", err
+	}
+	return body, resp.Header.Get("ETag"), nil
+}
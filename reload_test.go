@@ -0,0 +1,466 @@
+package bypass
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+func TestReloadableBypasser(t *testing.T) {
+	var b Bypasser = NewBypasserPatterns(false, "example.com")
+	bp, ok := b.(ReloadableBypasser)
+	if !ok {
+		t.Fatalf("the bypass should be reloadable")
+	}
+
+	if err := bp.Reload(strings.NewReader("reload 10s\n*.example.org\n")); err != nil {
+		t.Fatal(err)
+	}
+	if bp.Bypass("example.com") || !bp.Bypass("www.example.org") {
+		t.Errorf("the rules should be reloaded")
+	}
+	if p := bp.Period(); p != 10*time.Second {
+		t.Errorf("expected the period 10s, got %v", p)
+	}
+
+	bp.Stop()
+	if !bp.Stopped() || bp.Period() >= 0 {
+		t.Errorf("the bypass should be stopped")
+	}
+}
+
+func TestReloadFromWatcher(t *testing.T) {
+	bp := NewBypasser(false).(*bypasser)
+
+	ch := make(chan []byte)
+	done := make(chan error, 1)
+	go func() {
+		done <- bp.ReloadFromWatcher(context.Background(), ch)
+	}()
+
+	ch <- []byte("example.com\n")
+	ch <- []byte("192.168.1.1\n")
+	close(ch)
+
+	if err := <-done; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if bp.Bypass("example.com") {
+		t.Errorf("example.com should not be bypassed after the second payload")
+	}
+	if !bp.Bypass("192.168.1.1") {
+		t.Errorf("192.168.1.1 should be bypassed after the second payload")
+	}
+}
+
+func TestReloadFromWatcherCancel(t *testing.T) {
+	bp := NewBypasser(false).(*bypasser)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := bp.ReloadFromWatcher(ctx, make(chan []byte)); err != context.Canceled {
+		t.Errorf("expected %v, got %v", context.Canceled, err)
+	}
+}
+
+func TestReloadFrom(t *testing.T) {
+	bp := NewBypasser(false).(*bypasser)
+	var changes [][]Matcher
+	bp.OnReload(func(old, new []Matcher) {
+		changes = append(changes, new)
+	})
+
+	ch := make(chan io.Reader)
+	errs := make(chan error)
+	done := make(chan error, 1)
+	go func() {
+		done <- bp.ReloadFrom(context.Background(), ch, errs)
+	}()
+
+	ch <- strings.NewReader("example.com\n")
+	if err := <-errs; err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	ch <- io.NopCloser(strings.NewReader("192.168.1.1\n"))
+	if err := <-errs; err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	ch <- iotest.ErrReader(errors.New("stream reset"))
+	if err := <-errs; err == nil {
+		t.Errorf("expected the error of the failed reload")
+	}
+	close(ch)
+	if err := <-done; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(changes) != 2 || changes[0][0].String() != "domain example.com" || changes[1][0].String() != "ip 192.168.1.1" {
+		t.Errorf("the rules should change twice, got %v", changes)
+	}
+	if bp.Bypass("example.com") || !bp.Bypass("192.168.1.1") {
+		t.Errorf("the rules of the second config should be loaded")
+	}
+
+	// without an error channel
+	ch = make(chan io.Reader, 1)
+	ch <- strings.NewReader("*.example.org\n")
+	close(ch)
+	if err := bp.ReloadFrom(context.Background(), ch, nil); err != nil || !bp.Bypass("www.example.org") {
+		t.Errorf("the config should be loaded, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := bp.ReloadFrom(ctx, make(chan io.Reader), nil); err != context.Canceled {
+		t.Errorf("expected %v, got %v", context.Canceled, err)
+	}
+}
+
+func TestTailURL(t *testing.T) {
+	changed := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Header.Get("If-None-Match") {
+		case "":
+			w.Header().Set("ETag", "v1")
+			w.Write([]byte("example.com\n"))
+		case "v1":
+			select {
+			case <-changed:
+			case <-r.Context().Done():
+				return
+			}
+			w.Header().Set("ETag", "v2")
+			w.Write([]byte("example.org\n"))
+		default:
+			<-r.Context().Done()
+		}
+	}))
+	defer srv.Close()
+
+	bp := NewBypasser(false).(*bypasser)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- bp.TailURL(ctx, srv.Client(), srv.URL)
+	}()
+
+	waitBypass(t, bp, "example.com")
+	if bp.Bypass("example.org") {
+		t.Errorf("example.org should not be bypassed before the change")
+	}
+
+	close(changed)
+	waitBypass(t, bp, "example.org")
+	if bp.Bypass("example.com") {
+		t.Errorf("example.com should not be bypassed after the change")
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != context.Canceled {
+			t.Errorf("expected %v, got %v", context.Canceled, err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("TailURL did not return after cancel")
+	}
+}
+
+func TestTailURLImmediate(t *testing.T) {
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if r.Header.Get("If-None-Match") == "v1" {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", "v1")
+		w.Write([]byte("example.com\n"))
+	}))
+	defer srv.Close()
+
+	bp := NewBypasser(false).(*bypasser)
+	ctx, cancel := context.WithTimeout(context.Background(), tailMinBackoff+tailMinBackoff/2)
+	defer cancel()
+	if err := bp.TailURL(ctx, srv.Client(), srv.URL); err != context.DeadlineExceeded {
+		t.Errorf("expected %v, got %v", context.DeadlineExceeded, err)
+	}
+	if n := requests.Load(); n > 2 {
+		t.Errorf("the immediate responses should not be polled in a loop, got %d requests", n)
+	}
+	if !bp.Bypass("example.com") {
+		t.Errorf("example.com should be bypassed")
+	}
+}
+
+func TestTailURLStop(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	bp := NewBypasser(false).(*bypasser)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- bp.TailURL(context.Background(), srv.Client(), srv.URL)
+	}()
+
+	bp.Stop()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("TailURL did not return after stop")
+	}
+}
+
+// waitBypass waits until addr is bypassed by bp.
+func waitBypass(t *testing.T, bp Bypasser, addr string) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !bp.Bypass(addr) {
+		if time.Now().After(deadline) {
+			t.Fatalf("%s is not bypassed", addr)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestStart(t *testing.T) {
+	bp := NewBypasser(false).(*bypasser)
+
+	var mux sync.Mutex
+	var calls int
+	getReader := func() (io.Reader, error) {
+		mux.Lock()
+		defer mux.Unlock()
+		calls++
+		return strings.NewReader("reload 10ms\nexample.org\n"), nil
+	}
+	numCalls := func() int {
+		mux.Lock()
+		defer mux.Unlock()
+		return calls
+	}
+
+	bp.Start(context.Background(), getReader)
+
+	// idle without a period
+	time.Sleep(50 * time.Millisecond)
+	if n := numCalls(); n != 0 {
+		t.Fatalf("the config should not be read without a period, read %d times", n)
+	}
+
+	// a reload setting the period wakes the loop up
+	if err := bp.Reload(strings.NewReader("reload 10ms\nexample.com\n")); err != nil {
+		t.Fatal(err)
+	}
+	waitBypass(t, bp, "example.org")
+
+	bp.Stop()
+	if !bp.Stopped() {
+		t.Errorf("the bypass should be stopped")
+	}
+	time.Sleep(30 * time.Millisecond)
+	n := numCalls()
+	time.Sleep(50 * time.Millisecond)
+	if numCalls() != n {
+		t.Errorf("the config should not be read once stopped")
+	}
+}
+
+func TestStartCancel(t *testing.T) {
+	bp := NewBypasser(false).(*bypasser)
+	if err := bp.Reload(strings.NewReader("reload 10ms\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	var mux sync.Mutex
+	var calls int
+	ctx, cancel := context.WithCancel(context.Background())
+	bp.Start(ctx, func() (io.Reader, error) {
+		mux.Lock()
+		defer mux.Unlock()
+		calls++
+		return nil, errors.New("unavailable")
+	})
+
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	select {
+	case <-bp.Done():
+	case <-time.After(time.Second):
+		t.Fatal("cancelling the context should stop the bypass")
+	}
+	if !bp.Stopped() || bp.Period() >= 0 {
+		t.Errorf("the bypass should be stopped")
+	}
+
+	mux.Lock()
+	n := calls
+	mux.Unlock()
+	if n == 0 {
+		t.Errorf("the config should be read periodically despite the errors")
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	mux.Lock()
+	defer mux.Unlock()
+	if calls != n {
+		t.Errorf("the config should not be read once cancelled")
+	}
+}
+
+func TestOnReload(t *testing.T) {
+	bp := NewBypasserPatterns(false, "*.example.com").(*bypasser)
+
+	type change struct{ old, new string }
+	var changes []change
+	bp.OnReload(func(old, new []Matcher) {
+		if bp.Len() != len(new) {
+			t.Errorf("the callback should be called once the rules are replaced")
+		}
+		changes = append(changes, change{fmt.Sprint(old), fmt.Sprint(new)})
+	})
+
+	for _, config := range []string{
+		"*.example.com\n",
+		"*.example.com\n10.0.0.0/8\n",
+		"reverse true\n*.example.com\n10.0.0.0/8\n",
+		"10.0.0.0/8\n*.example.com\n",
+		"*.example.org\n",
+		"example.org\n",
+		".example.org\n",
+	} {
+		if err := bp.Reload(strings.NewReader(config)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := []change{
+		{"[domain *.example.com]", "[domain *.example.com cidr 10.0.0.0/8]"},
+		{"[cidr 10.0.0.0/8 domain *.example.com]", "[domain *.example.org]"},
+		{"[domain *.example.org]", "[domain example.org]"},
+		{"[domain example.org]", "[domain .example.org]"},
+	}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("expected changes %v, got %v", want, changes)
+	}
+}
+
+func TestReloadStrict(t *testing.T) {
+	bp := NewBypasser(false).(*bypasser)
+
+	warnings, err := bp.ReloadStrict(strings.NewReader(
+		"192.168.1.1\n10.0.0.0/33\n*.example.com\n[bad\n2001:db8::/32\n300.1.1.1\n!2001:db8::/129\nexample.org\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"line 2: 10.0.0.0/33",
+		"line 4: [bad",
+		"line 6: 300.1.1.1",
+		"line 7: !2001:db8::/129",
+	}
+	if len(warnings) != len(want) {
+		t.Fatalf("expected %d warnings, got %q", len(want), warnings)
+	}
+	for i, w := range want {
+		if !strings.Contains(warnings[i], w) {
+			t.Errorf("warning %d: expected %q, got %q", i, w, warnings[i])
+		}
+	}
+
+	if bp.Len() != 4 {
+		t.Errorf("expected 4 rules, got %d", bp.Len())
+	}
+	for _, addr := range []string{"192.168.1.1", "www.example.com", "[2001:db8::1]:80", "example.org"} {
+		if !bp.Bypass(addr) {
+			t.Errorf("%s should match a valid rule", addr)
+		}
+	}
+
+	// Reload takes the malformed IP patterns for domain patterns
+	if err := bp.Reload(strings.NewReader("10.0.0.0/33\n300.1.1.1\n")); err != nil {
+		t.Fatal(err)
+	}
+	if bp.Len() != 2 {
+		t.Errorf("expected 2 rules, got %d", bp.Len())
+	}
+
+	if _, err := bp.ReloadStrict(strings.NewReader("include missing.txt\n")); err == nil {
+		t.Errorf("a config error should be returned as an error")
+	}
+	if bp.Len() != 2 {
+		t.Errorf("the rules should be left intact on error")
+	}
+}
+
+func TestReloadAtomic(t *testing.T) {
+	bp := NewBypasser(false).(*bypasser)
+	if err := bp.Reload(strings.NewReader("reverse true\nreload 1m\n[old]\n*.example.com\n10.0.0.0/8\n")); err != nil {
+		t.Fatal(err)
+	}
+	bp.Bypass("www.example.com")
+	old := bp.Matchers()
+
+	check := func(name string) {
+		t.Helper()
+		if got := bp.Matchers(); !reflect.DeepEqual(got, old) {
+			t.Errorf("%s: the rules should be left intact, got %v", name, got)
+		}
+		if !bp.Reversed() || bp.Period() != time.Minute || !reflect.DeepEqual(bp.Sections(), []string{"old"}) {
+			t.Errorf("%s: the options should be left intact", name)
+		}
+		if bp.hits[0] != 1 {
+			t.Errorf("%s: the hit counters should be left intact", name)
+		}
+		if bp.Bypass("www.example.com") || !bp.Bypass("example.org") {
+			t.Errorf("%s: the decisions should be left intact", name)
+		}
+		bp.hits[0] = 1
+	}
+
+	// a line too long for the scanner in the middle of the config
+	config := "reverse false\nreload 1h\n[new]\n192.168.0.0/16\n" + strings.Repeat("a", 128*1024) + "\n*.example.org\n"
+	if err := bp.Reload(strings.NewReader(config)); err == nil {
+		t.Errorf("expected an error for a line too long")
+	}
+	check("line too long")
+
+	// a failed read in the middle of the second config
+	failed := io.MultiReader(strings.NewReader("*.example.org\n"), iotest.ErrReader(errors.New("read failed")))
+	if err := bp.ReloadAll(strings.NewReader("reverse false\n192.168.0.0/16\n"), failed); err == nil {
+		t.Errorf("expected an error for a failed read")
+	}
+	check("failed read")
+
+	// a line transform panicking in the middle of the config
+	bp.SetLineTransform(func(line string) string {
+		if line == "bad" {
+			panic("bad line")
+		}
+		return line
+	})
+	if err := bp.Reload(strings.NewReader("reverse false\n192.168.0.0/16\nbad\n*.example.org\n")); err == nil || !strings.Contains(err.Error(), "bad line") {
+		t.Errorf("expected an error for the panic, got %v", err)
+	}
+	check("panic")
+}
@@ -0,0 +1,180 @@
+package bypass
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// resolveTTL is how long the addresses resolved by a ResolveMatcher are cached.
+	resolveTTL = 30 * time.Second
+	// resolveTimeout bounds a lookup of a ResolveMatcher.
+	resolveTimeout = 2 * time.Second
+)
+
+// ContextMatcher is implemented by the Matchers doing I/O, such as a ResolveMatcher,
+// to match under a context: the I/O of the match is aborted once ctx is cancelled.
+type ContextMatcher interface {
+	Matcher
+	MatchContext(ctx context.Context, v string) bool
+}
+
+// lookupMatcher is implemented by the Matchers doing DNS lookups, such as a ResolveMatcher or a PTRMatcher.
+type lookupMatcher interface {
+	Matcher
+	// prefetch does the lookup needed to match the address addr, unless it is cached,
+	// so that the lookup is done before the bypass takes its lock rather than under it.
+	prefetch(addr string)
+	// lookupTTL returns how long the lookups are cached.
+	lookupTTL() time.Duration
+}
+
+// lookupMatchers returns the matchers doing lookups of the matcher m, m itself or the matchers it wraps.
+func lookupMatchers(matcher Matcher) []lookupMatcher {
+	switch m := matcher.(type) {
+	case lookupMatcher:
+		return []lookupMatcher{m}
+	case *compositeMatcher:
+		var lookups []lookupMatcher
+		for _, matcher := range m.matchers {
+			lookups = append(lookups, lookupMatchers(matcher)...)
+		}
+		return lookups
+	case *qualifiedMatcher:
+		return lookupMatchers(m.Matcher)
+	case *portMatcher:
+		return lookupMatchers(m.Matcher)
+	case *negatedMatcher:
+		return lookupMatchers(m.Matcher)
+	case *deniedMatcher:
+		return lookupMatchers(m.Matcher)
+	case *schemeMatcher:
+		return lookupMatchers(m.Matcher)
+	case *expiringMatcher:
+		return lookupMatchers(m.Matcher)
+	default:
+		return nil
+	}
+}
+
+// prefetch does the lookups of the rules of the bypass needed to match the target t,
+// before the decision takes the lock of the bypass: a pending lookup then does not hold the lock,
+// which would stall the Bypass calls behind a reload waiting for it.
+// A lookup expiring in between is done again by the match.
+func (bp *bypasser) prefetch(t target) {
+	bp.mux.RLock()
+	lookups := bp.lookups
+	bp.mux.RUnlock()
+
+	for _, m := range lookups {
+		m.prefetch(t.host)
+	}
+}
+
+// ipResolver looks up the IP addresses of a host, as net.Resolver does.
+type ipResolver interface {
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+type resolveMatcher struct {
+	host     string
+	resolver ipResolver
+	ttl      time.Duration
+	timeout  time.Duration
+
+	mux     sync.Mutex
+	ips     []net.IP
+	expires time.Time
+}
+
+// ResolveMatcher creates a Matcher for the IP addresses the host resolves to, such as the changing addresses of a service.
+// The host is resolved by resolver, net.DefaultResolver if nil, when an address is matched,
+// and the resolved addresses are then cached for a short time.
+// A failed lookup keeps the addresses resolved before, and is not retried before the cache expires.
+// As it does DNS lookups, it is never created from a config and is best used with SetMatchCache.
+// The returned Matcher is a ContextMatcher, the lookups of Match are bounded by a timeout only.
+// It returns nil if host is empty.
+func ResolveMatcher(host string, resolver *net.Resolver) Matcher {
+	if host == "" {
+		return nil
+	}
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	return newResolveMatcher(host, resolver)
+}
+
+func newResolveMatcher(host string, resolver ipResolver) *resolveMatcher {
+	return &resolveMatcher{
+		host:     host,
+		resolver: resolver,
+		ttl:      resolveTTL,
+		timeout:  resolveTimeout,
+	}
+}
+
+func (m *resolveMatcher) Match(ip string) bool {
+	return m.MatchContext(context.Background(), ip)
+}
+
+// MatchContext matches the IP address ip as Match does, the lookup is aborted once ctx is cancelled.
+func (m *resolveMatcher) MatchContext(ctx context.Context, ip string) bool {
+	if m == nil {
+		return false
+	}
+	addr, _ := parseIPZone(ip)
+	if addr == nil {
+		return false
+	}
+	for _, resolved := range m.resolve(ctx) {
+		if resolved.Equal(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *resolveMatcher) prefetch(ip string) {
+	if addr, _ := parseIPZone(ip); addr != nil {
+		m.resolve(context.Background())
+	}
+}
+
+func (m *resolveMatcher) lookupTTL() time.Duration {
+	return m.ttl
+}
+
+// resolve returns the addresses of the host, from the cache if they have not expired.
+// The concurrent matches wait for a single lookup.
+// A lookup aborted by the cancellation of ctx is not cached, the next match retries it.
+func (m *resolveMatcher) resolve(ctx context.Context) []net.IP {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	if time.Now().Before(m.expires) {
+		return m.ips
+	}
+
+	lookupCtx, cancel := context.WithTimeout(ctx, m.timeout)
+	defer cancel()
+
+	addrs, err := m.resolver.LookupIPAddr(lookupCtx, m.host)
+	if ctx.Err() != nil {
+		return m.ips
+	}
+	if err == nil {
+		ips := make([]net.IP, 0, len(addrs))
+		for _, addr := range addrs {
+			ips = append(ips, addr.IP)
+		}
+		m.ips = ips
+	}
+	m.expires = time.Now().Add(m.ttl)
+	return m.ips
+}
+
+func (m *resolveMatcher) String() string {
+	return "resolve " + m.host
+}
@@ -0,0 +1,184 @@
+package bypass
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeResolver resolves the hosts to their addresses in a map, counting the lookups.
+type fakeResolver struct {
+	addrs   map[string][]string
+	err     error
+	block   bool // blocks until the lookup is cancelled
+	lookups int
+	mux     sync.Mutex
+}
+
+func (r *fakeResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	r.mux.Lock()
+	r.lookups++
+	block, err := r.block, r.err
+	var addrs []net.IPAddr
+	for _, s := range r.addrs[host] {
+		addrs = append(addrs, net.IPAddr{IP: net.ParseIP(s)})
+	}
+	r.mux.Unlock()
+
+	if block {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	return addrs, err
+}
+
+func (r *fakeResolver) set(f func(r *fakeResolver)) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	f(r)
+}
+
+func TestResolveMatcher(t *testing.T) {
+	r := &fakeResolver{addrs: map[string][]string{
+		"api.example.com": {"192.0.2.10", "2001:db8::10"},
+	}}
+	m := newResolveMatcher("api.example.com", r)
+
+	for _, tc := range []struct {
+		addr    string
+		matched bool
+	}{
+		{"192.0.2.10", true},
+		{"::ffff:192.0.2.10", true},
+		{"2001:db8::10", true},
+		{"192.0.2.11", false},
+		{"api.example.com", false},
+		{"", false},
+	} {
+		if matched := m.Match(tc.addr); matched != tc.matched {
+			t.Errorf("%q: expected %v, got %v", tc.addr, tc.matched, matched)
+		}
+	}
+	if r.lookups != 1 {
+		t.Errorf("the resolved addresses should be cached, got %d lookups", r.lookups)
+	}
+	if m.String() != "resolve api.example.com" {
+		t.Errorf("unexpected String %q", m.String())
+	}
+
+	// the addresses are resolved again once expired, and kept if the lookup fails
+	m.ttl = time.Millisecond
+	m.expires = time.Time{}
+	r.set(func(r *fakeResolver) { r.addrs["api.example.com"] = []string{"192.0.2.20"} })
+	if !m.Match("192.0.2.20") || m.Match("192.0.2.10") {
+		t.Errorf("the expired addresses should be resolved again")
+	}
+	time.Sleep(2 * time.Millisecond)
+	r.set(func(r *fakeResolver) { r.err = errors.New("no such host") })
+	if !m.Match("192.0.2.20") {
+		t.Errorf("the addresses should be kept on a failed lookup")
+	}
+
+	if ResolveMatcher("", nil) != nil {
+		t.Errorf("an empty host should give a nil matcher")
+	}
+}
+
+func TestResolveMatcherTimeout(t *testing.T) {
+	r := &fakeResolver{block: true}
+	m := newResolveMatcher("api.example.com", r)
+	m.timeout = 10 * time.Millisecond
+
+	start := time.Now()
+	if m.Match("192.0.2.10") {
+		t.Errorf("a timed out lookup should match nothing")
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("the lookup should time out, took %v", d)
+	}
+}
+
+func TestResolveMatcherBypass(t *testing.T) {
+	r := &fakeResolver{addrs: map[string][]string{"api.example.com": {"192.0.2.10"}}}
+	bp := NewBypasser(false, newResolveMatcher("api.example.com", r))
+	bp.(*bypasser).SetMatchCache(16, time.Minute)
+
+	for i := 0; i < 3; i++ {
+		if !bp.Bypass("192.0.2.10:443") {
+			t.Errorf("the resolved address should be bypassed")
+		}
+	}
+	if bp.Bypass("api.example.com:443") {
+		t.Errorf("the host itself is not an IP address")
+	}
+	if r.lookups != 1 {
+		t.Errorf("expected 1 lookup, got %d", r.lookups)
+	}
+}
+
+func TestResolveMatcherUnlocked(t *testing.T) {
+	r := &fakeResolver{block: true}
+	m := newResolveMatcher("api.example.com", r)
+	m.timeout = time.Second
+	bp := NewBypasser(false, m).(*bypasser)
+
+	done := make(chan bool)
+	go func() { done <- bp.Bypass("192.0.2.10") }()
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(time.Millisecond) {
+		r.mux.Lock()
+		lookups := r.lookups
+		r.mux.Unlock()
+		if lookups > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the lookup should be started")
+		}
+	}
+
+	// the pending lookup does not hold the lock of the bypass
+	bp.AddMatcher(NewMatcher("192.0.2.20"))
+	if bp.Len() != 2 {
+		t.Errorf("the rule should be added")
+	}
+	select {
+	case <-done:
+		t.Errorf("the lookup should still be pending")
+	default:
+	}
+	if <-done {
+		t.Errorf("a timed out lookup should match nothing")
+	}
+}
+
+func TestResolveMatcherContext(t *testing.T) {
+	r := &fakeResolver{block: true}
+	matcher := ResolveMatcher("api.example.com", nil)
+	m := matcher.(*resolveMatcher)
+	m.resolver = r
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	start := time.Now()
+	if matcher.(ContextMatcher).MatchContext(ctx, "192.0.2.10") {
+		t.Errorf("a cancelled lookup should match nothing")
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("the lookup should be aborted on cancel, took %v", d)
+	}
+
+	// the cancelled lookup is not cached
+	r.set(func(r *fakeResolver) {
+		r.block = false
+		r.addrs = map[string][]string{"api.example.com": {"192.0.2.10"}}
+	})
+	if !m.MatchContext(context.Background(), "192.0.2.10") {
+		t.Errorf("the lookup should be retried after a cancelled one")
+	}
+	if r.lookups != 2 {
+		t.Errorf("expected 2 lookups, got %d", r.lookups)
+	}
+}
@@ -0,0 +1,109 @@
+package bypass
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+type resultCacheEntry struct {
+	addr     string
+	bypassed bool
+	matcher  Matcher
+	expires  time.Time // zero if the decision does not expire
+}
+
+// resultCache is a size-bounded LRU cache of the decisions of a bypass by address, safe for concurrent use.
+// A nil resultCache caches nothing.
+type resultCache struct {
+	size    int
+	entries map[string]*list.Element
+	lru     *list.List // front is the most recently used
+	mux     sync.Mutex
+}
+
+func newResultCache(size int) *resultCache {
+	return &resultCache{
+		size:    size,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+func (c *resultCache) get(addr string) (entry *resultCacheEntry, ok bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	e, ok := c.entries[addr]
+	if !ok {
+		return nil, false
+	}
+	entry = e.Value.(*resultCacheEntry)
+	if !entry.expires.IsZero() && !timeNow().Before(entry.expires) {
+		c.lru.Remove(e)
+		delete(c.entries, addr)
+		return nil, false
+	}
+	c.lru.MoveToFront(e)
+	return entry, true
+}
+
+// put caches the decision on addr, for ttl if it is positive, until the cache is emptied otherwise.
+func (c *resultCache) put(addr string, bypassed bool, matcher Matcher, ttl time.Duration) {
+	if c == nil {
+		return
+	}
+
+	c.mux.Lock()
+	defer c.mux.Unlock()
+
+	if _, ok := c.entries[addr]; ok {
+		return
+	}
+	entry := &resultCacheEntry{
+		addr:     addr,
+		bypassed: bypassed,
+		matcher:  matcher,
+	}
+	if ttl > 0 {
+		entry.expires = timeNow().Add(ttl)
+	}
+	c.entries[addr] = c.lru.PushFront(entry)
+	for c.lru.Len() > c.size {
+		e := c.lru.Back()
+		c.lru.Remove(e)
+		delete(c.entries, e.Value.(*resultCacheEntry).addr)
+	}
+}
+
+// SetResultCache enables a cache of the decisions of Bypass and BypassMatch, holding up to size addresses,
+// for the hot paths checking the same addresses repeatedly.
+// The addresses are cached with their port, as the rules may depend on it.
+// The cache is emptied whenever the rules or the options change, by Reload, AddMatcher or RemoveMatcher.
+// The decisions of a bypass with rules doing DNS lookups, such as a ResolveMatcher or a PTRMatcher,
+// are cached no longer than the lookups of these rules, so that the cache does not outlive the DNS records.
+// The cached decisions are not counted by the hit counters of the rules and are not logged.
+// A non-positive size disables the cache.
+func (bp *bypasser) SetResultCache(size int) {
+	var cache *resultCache
+	if size > 0 {
+		cache = newResultCache(size)
+	}
+
+	bp.mux.Lock()
+	defer bp.mux.Unlock()
+
+	bp.results = cache
+}
+
+// resetResults empties the result cache.
+// The caller must hold bp.mux.
+func (bp *bypasser) resetResults() {
+	if bp.results != nil {
+		bp.results = newResultCache(bp.results.size)
+	}
+}
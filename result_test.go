@@ -0,0 +1,169 @@
+package bypass
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestResultCache(t *testing.T) {
+	bp := NewBypasser(false).(*bypasser)
+	bp.SetResultCache(16)
+	if err := bp.Reload(strings.NewReader("*.example.com\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bp.Bypass("www.example.com") {
+		t.Fatal("www.example.com should be bypassed")
+	}
+	if _, ok := bp.results.get("www.example.com"); !ok {
+		t.Errorf("the decision should be cached")
+	}
+	bp.Bypass("www.example.com")
+	if bp.hits[0] != 1 {
+		t.Errorf("a cached decision should not be counted, got %d hits", bp.hits[0])
+	}
+
+	// the rules are unchanged but the options are not
+	if err := bp.Reload(strings.NewReader("reverse true\n*.example.com\n")); err != nil {
+		t.Fatal(err)
+	}
+	if bp.Bypass("www.example.com") {
+		t.Errorf("the cache should be emptied by a reload")
+	}
+
+	if err := bp.Reload(strings.NewReader("*.example.org\n")); err != nil {
+		t.Fatal(err)
+	}
+	if bp.Bypass("www.example.com") || !bp.Bypass("www.example.org") {
+		t.Errorf("the cache should be emptied by a reload")
+	}
+
+	bp.AddMatcher(NewMatcher("*.example.com"))
+	if !bp.Bypass("www.example.com") {
+		t.Errorf("the cache should be emptied by AddMatcher")
+	}
+	bp.RemoveMatcher("*.example.com")
+	if bp.Bypass("www.example.com") {
+		t.Errorf("the cache should be emptied by RemoveMatcher")
+	}
+
+	bp.SetResultCache(0)
+	if bp.results != nil {
+		t.Errorf("the cache should be disabled")
+	}
+}
+
+func TestResultCacheLookupTTL(t *testing.T) {
+	clock := newFakeClock(t)
+	r := &fakeResolver{addrs: map[string][]string{"api.example.com": {"192.0.2.10"}}}
+	m := newResolveMatcher("api.example.com", r)
+	bp := NewBypasser(false, NegateMatcher(NewMatcher("192.0.2.99")), m).(*bypasser)
+	bp.SetResultCache(16)
+	if bp.resultTTL != resolveTTL {
+		t.Errorf("expected a TTL of %v for the cached decisions, got %v", resolveTTL, bp.resultTTL)
+	}
+
+	if !bp.Bypass("192.0.2.10") {
+		t.Fatal("192.0.2.10 should be bypassed")
+	}
+	// the matcher is not asked again until its lookup expires, and the cached decision along with it
+	m.expires = time.Time{}
+	r.set(func(r *fakeResolver) { r.addrs["api.example.com"] = []string{"192.0.2.20"} })
+	if !bp.Bypass("192.0.2.10") {
+		t.Errorf("the decision should be cached")
+	}
+	clock.Advance(resolveTTL)
+	if bp.Bypass("192.0.2.10") {
+		t.Errorf("the cached decision should expire with the lookup")
+	}
+
+	// no TTL without lookups
+	bp.RemoveMatcher(m.String())
+	if bp.resultTTL != 0 {
+		t.Errorf("expected no TTL, got %v", bp.resultTTL)
+	}
+}
+
+func TestResultCacheMatcher(t *testing.T) {
+	m := NewMatcher("*.example.com:443")
+	bp := NewBypasser(false, m).(*bypasser)
+	bp.SetResultCache(16)
+
+	for i := 0; i < 2; i++ {
+		if bypassed, matched := bp.BypassMatch("www.example.com:443"); !bypassed || matched != m {
+			t.Errorf("#%d: unexpected decision %v, %v", i, bypassed, matched)
+		}
+		if bypassed, matched := bp.BypassMatch("www.example.com:80"); bypassed || matched != nil {
+			t.Errorf("#%d: the port should be part of the cache key, got %v, %v", i, bypassed, matched)
+		}
+	}
+}
+
+func TestResultCacheSize(t *testing.T) {
+	c := newResultCache(2)
+	c.put("a", true, nil, 0)
+	c.put("b", false, nil, 0)
+	c.get("a")
+	c.put("c", true, nil, 0)
+
+	if _, ok := c.get("b"); ok {
+		t.Errorf("the least recently used entry should be evicted")
+	}
+	for _, addr := range []string{"a", "c"} {
+		if _, ok := c.get(addr); !ok {
+			t.Errorf("%s should be cached", addr)
+		}
+	}
+}
+
+func TestResultCacheConcurrent(t *testing.T) {
+	bp := NewBypasserPatterns(false, "*.example.com", "10.0.0.0/8").(*bypasser)
+	bp.SetResultCache(8)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				addr := fmt.Sprintf("www%d.example.com", j%16)
+				if !bp.Bypass(addr) {
+					t.Errorf("%s should be bypassed", addr)
+				}
+				if i == 0 && j%50 == 0 {
+					bp.AddMatcher(NewMatcher("192.168.1.1"))
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func BenchmarkResultCache(b *testing.B) {
+	var patterns []string
+	for i := 0; i < 100; i++ {
+		patterns = append(patterns, fmt.Sprintf("api-*.example%d.com", i), fmt.Sprintf("/^cdn-%d\\..*$/", i))
+	}
+	addrs := make([]string, 64)
+	for i := range addrs {
+		addrs[i] = fmt.Sprintf("www%d.example.org:443", i)
+	}
+
+	bp := NewBypasserPatterns(false, patterns...).(*bypasser)
+	b.Run("NoCache", func(b *testing.B) {
+		for n := 0; n < b.N; n++ {
+			bp.Bypass(addrs[n%len(addrs)])
+		}
+	})
+
+	cached := NewBypasserPatterns(false, patterns...).(*bypasser)
+	cached.SetResultCache(1024)
+	b.Run("Cache", func(b *testing.B) {
+		for n := 0; n < b.N; n++ {
+			cached.Bypass(addrs[n%len(addrs)])
+		}
+	})
+}
@@ -0,0 +1,253 @@
+package bypass
+
+import "sync/atomic"
+
+// setMatchers replaces the rules of the bypass and resets their hit counters.
+// The caller must hold bp.mux.
+func (bp *bypasser) setMatchers(matchers []Matcher) {
+	bp.matchers = matchers
+	bp.hits = make([]uint64, len(matchers))
+	bp.negations, bp.denials = 0, 0
+	for _, m := range matchers {
+		bp.countRank(m, 1)
+	}
+	bp.index = newRuleIndex(matchers)
+	bp.updateExpiry()
+	bp.resetResults()
+}
+
+// dedupMatchers drops the matchers whose String() is the one of an earlier matcher, keeping the order.
+func dedupMatchers(matchers []Matcher) []Matcher {
+	seen := make(map[string]bool, len(matchers))
+	deduped := make([]Matcher, 0, len(matchers))
+	for _, m := range matchers {
+		if s := m.String(); !seen[s] {
+			seen[s] = true
+			deduped = append(deduped, m)
+		}
+	}
+	return deduped
+}
+
+// countRank adds delta to the counter of the rank of the matcher m.
+// The caller must hold bp.mux.
+func (bp *bypasser) countRank(m Matcher, delta int) {
+	switch matcherRank(m) {
+	case rankNegated:
+		bp.negations += delta
+	case rankDenied:
+		bp.denials += delta
+	}
+}
+
+// AddMatcher appends the Matcher m to the rules of the bypass.
+// The rules added or removed at runtime are replaced by the next Reload.
+func (bp *bypasser) AddMatcher(m Matcher) {
+	if m == nil {
+		return
+	}
+
+	bp.mux.Lock()
+	defer bp.mux.Unlock()
+
+	n := len(bp.matchers)
+	matchers := make([]Matcher, n, n+1)
+	copy(matchers, bp.matchers)
+	hits := make([]uint64, n, n+1)
+	copy(hits, bp.hits)
+
+	bp.matchers = append(matchers, m)
+	bp.hits = append(hits, 0)
+	bp.countRank(m, 1)
+	bp.index = newRuleIndex(bp.matchers)
+	bp.updateExpiry()
+	bp.resetResults()
+	bp.rulesChanged()
+}
+
+// RemoveMatcher removes the first rule of the bypass whose String() is s,
+// or which is compiled from the pattern s, e.g. 'domain *.example.com' or '*.example.com'.
+// It returns false if there is no such rule.
+func (bp *bypasser) RemoveMatcher(s string) bool {
+	bp.mux.Lock()
+	defer bp.mux.Unlock()
+
+	for i, m := range bp.matchers {
+		if m == nil || !matchesRule(m, s) {
+			continue
+		}
+
+		matchers := make([]Matcher, 0, len(bp.matchers)-1)
+		matchers = append(append(matchers, bp.matchers[:i]...), bp.matchers[i+1:]...)
+		hits := make([]uint64, 0, len(bp.hits)-1)
+		hits = append(append(hits, bp.hits[:i]...), bp.hits[i+1:]...)
+
+		bp.matchers, bp.hits = matchers, hits
+		bp.countRank(m, -1)
+		bp.index = newRuleIndex(bp.matchers)
+		bp.updateExpiry()
+		bp.resetResults()
+		bp.rulesChanged()
+		return true
+	}
+	return false
+}
+
+// matchesRule reports whether the rule of the matcher m is s, either its String() or its pattern.
+func matchesRule(m Matcher, s string) bool {
+	if m.String() == s {
+		return true
+	}
+	patterns, ok := matcherPatterns(m)
+	return ok && len(patterns) == 1 && patterns[0] == s
+}
+
+// rulesChanged records that the rules no longer are the ones loaded by the last Reload.
+// The caller must hold bp.mux.
+func (bp *bypasser) rulesChanged() {
+	bp.fingerprint = ""
+	bp.ruleErrs = nil
+}
+
+// Len returns the number of rules of the bypass.
+func (bp *bypasser) Len() int {
+	bp.mux.RLock()
+	defer bp.mux.RUnlock()
+
+	return len(bp.matchers)
+}
+
+// IsEmpty reports whether the bypass has no rules, that is whether Len is zero.
+func (bp *bypasser) IsEmpty() bool {
+	return bp.Len() == 0
+}
+
+// Clone returns an independent copy of the bypass with the same rules, hit counters and options.
+// The clone has its own live reloading state, stopping one does not stop the other,
+// and the changes to the rules of one are not seen by the other.
+// The match cache and the logger are shared, the result cache is emptied,
+// the shadow and the adaptive ordering are not copied.
+func (bp *bypasser) Clone() Bypasser {
+	bp.mux.RLock()
+	defer bp.mux.RUnlock()
+
+	clone := &bypasser{
+		reversed:        bp.reversed,
+		matchers:        append([]Matcher(nil), bp.matchers...),
+		hits:            make([]uint64, len(bp.hits)),
+		period:          bp.period,
+		stopped:         make(chan struct{}),
+		compiler:        bp.compiler,
+		cache:           bp.cache,
+		negations:       bp.negations,
+		denials:         bp.denials,
+		index:           bp.index,
+		domainsReversed: bp.domainsReversed,
+		splitReverse:    bp.splitReverse,
+		keepPortIP:      bp.keepPortIP,
+		keepPortDomain:  bp.keepPortDomain,
+		reverseEmpty:    bp.reverseEmpty,
+		fingerprint:     bp.fingerprint,
+		ruleErrs:        bp.ruleErrs,
+		sections:        bp.sections,
+		ruleOpts:        bp.ruleOpts,
+		transform:       bp.transform,
+		slogger:         bp.slogger,
+		name:            bp.name,
+	}
+	clone.specificityOrder = bp.specificityOrder
+	clone.expiry = bp.expiry
+	clone.resultTTL = bp.resultTTL
+	clone.lookups = bp.lookups
+	if bp.results != nil {
+		clone.results = newResultCache(bp.results.size)
+	}
+	for i := range bp.hits {
+		clone.hits[i] = atomic.LoadUint64(&bp.hits[i])
+	}
+	return clone
+}
+
+// Equal reports whether the bypass and other have the same rules, by their String(), whatever their order,
+// and the same options deciding on the addresses: the reversed flag, the polarity of the domain rules
+// and the port stripping. A nil bypass is only equal to nil.
+func (bp *bypasser) Equal(other Bypasser) bool {
+	o, ok := other.(*bypasser)
+	if !ok && other != nil {
+		return false
+	}
+	if bp == nil || o == nil {
+		return bp == nil && o == nil
+	}
+	if bp == o {
+		return true
+	}
+
+	a, b := bp.snapshot(), o.snapshot()
+	return a.options == b.options && sameRuleCounts(a.rules, b.rules)
+}
+
+// decisionOptions are the options of a bypass deciding on the addresses along with the rules.
+type decisionOptions struct {
+	reversed        bool
+	domainsReversed bool
+	splitReverse    bool
+	keepPortIP      bool
+	keepPortDomain  bool
+	reverseEmpty    bool
+
+	specificityOrder bool
+}
+
+type rulesSnapshot struct {
+	options decisionOptions
+	rules   map[string]int // the number of rules by their String()
+}
+
+func (bp *bypasser) snapshot() rulesSnapshot {
+	bp.mux.RLock()
+	defer bp.mux.RUnlock()
+
+	s := rulesSnapshot{
+		options: decisionOptions{
+			reversed:        bp.reversed,
+			domainsReversed: bp.domainsReversed,
+			splitReverse:    bp.splitReverse,
+			keepPortIP:      bp.keepPortIP,
+			keepPortDomain:  bp.keepPortDomain,
+			reverseEmpty:    bp.reverseEmpty,
+
+			specificityOrder: bp.specificityOrder,
+		},
+		rules: ruleCounts(bp.matchers),
+	}
+	return s
+}
+
+// ruleCounts returns the number of the matchers by their String().
+func ruleCounts(matchers []Matcher) map[string]int {
+	counts := make(map[string]int, len(matchers))
+	for _, m := range matchers {
+		if m != nil {
+			counts[m.String()]++
+		}
+	}
+	return counts
+}
+
+func sameRuleCounts(a, b map[string]int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for rule, n := range a {
+		if b[rule] != n {
+			return false
+		}
+	}
+	return true
+}
+
+// sameRules reports whether the matchers a and b are the same rules, by their String(), whatever their order.
+func sameRules(a, b []Matcher) bool {
+	return sameRuleCounts(ruleCounts(a), ruleCounts(b))
+}
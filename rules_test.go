@@ -0,0 +1,246 @@
+package bypass
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestAddRemoveMatcher(t *testing.T) {
+	bp := NewBypasserPatterns(false, "10.0.0.0/8").(*bypasser)
+
+	bp.AddMatcher(NewMatcher("*.example.com"))
+	bp.AddMatcher(NegateMatcher(NewMatcher("secure.example.com")))
+	if !bp.Bypass("www.example.com") || bp.Bypass("secure.example.com") {
+		t.Errorf("the added rules should be applied")
+	}
+	if len(bp.hits) != len(bp.matchers) {
+		t.Errorf("the hit counters should follow the rules")
+	}
+
+	if !bp.RemoveMatcher("!domain secure.example.com") {
+		t.Errorf("the negated rule should be removed by its String()")
+	}
+	if !bp.Bypass("secure.example.com") {
+		t.Errorf("the removed rule should not be applied")
+	}
+	if !bp.RemoveMatcher("10.0.0.0/8") {
+		t.Errorf("the CIDR rule should be removed by its pattern")
+	}
+	if bp.Bypass("10.1.2.3") {
+		t.Errorf("the removed rule should not be applied")
+	}
+	if bp.RemoveMatcher("192.168.1.1") {
+		t.Errorf("RemoveMatcher should return false for an unknown rule")
+	}
+	if s := fmt.Sprint(bp.Matchers()); s != "[domain *.example.com]" {
+		t.Errorf("unexpected rules %s", s)
+	}
+}
+
+func TestAddMatcherReload(t *testing.T) {
+	bp := NewBypasser(false).(*bypasser)
+	config := "*.example.com\n"
+	if err := bp.Reload(strings.NewReader(config)); err != nil {
+		t.Fatal(err)
+	}
+
+	bp.AddMatcher(NewMatcher("192.168.1.1"))
+	if err := bp.Reload(strings.NewReader(config)); err != nil {
+		t.Fatal(err)
+	}
+	if bp.Bypass("192.168.1.1") {
+		t.Errorf("the rules added at runtime should be replaced by the reload")
+	}
+}
+
+func TestAddRemoveMatcherConcurrency(t *testing.T) {
+	bp := NewBypasserPatterns(false, "*.example.com").(*bypasser)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				pattern := fmt.Sprintf("192.168.%d.%d", i, j)
+				bp.AddMatcher(NewMatcher(pattern))
+				if !bp.RemoveMatcher(pattern) {
+					t.Errorf("%s should be removed", pattern)
+				}
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				if !bp.Bypass("www.example.com") {
+					t.Errorf("www.example.com should be bypassed")
+				}
+				bp.Bypass(fmt.Sprintf("192.168.0.%d", j))
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := len(bp.Matchers()); n != 1 {
+		t.Errorf("expected 1 rule, got %d", n)
+	}
+}
+
+func TestLen(t *testing.T) {
+	bp := NewBypasser(false).(*bypasser)
+	if bp.Len() != 0 || !bp.IsEmpty() {
+		t.Errorf("the bypass should be empty")
+	}
+
+	bp = NewBypasserPatterns(false, "*.example.com").(*bypasser)
+	if bp.Len() != 1 || bp.IsEmpty() {
+		t.Errorf("the bypass should have 1 rule, got %d", bp.Len())
+	}
+
+	if err := bp.Reload(strings.NewReader("reverse true\n10.0.0.0/8\n192.168.1.1\n*.example.org\n")); err != nil {
+		t.Fatal(err)
+	}
+	if bp.Len() != 3 || bp.IsEmpty() {
+		t.Errorf("the bypass should have 3 rules, got %d", bp.Len())
+	}
+
+	if err := bp.Reload(strings.NewReader("reverse true\n")); err != nil {
+		t.Fatal(err)
+	}
+	if !bp.IsEmpty() {
+		t.Errorf("the bypass should be empty after the reload")
+	}
+}
+
+func TestClone(t *testing.T) {
+	bp := NewBypasserPatterns(true, "*.example.com").(*bypasser)
+	if err := bp.Reload(strings.NewReader("reload 10s\nreverse true\n*.example.com\n")); err != nil {
+		t.Fatal(err)
+	}
+	bp.Bypass("www.example.com")
+
+	clone := bp.Clone().(*bypasser)
+	if clone.Period() != bp.Period() || clone.Reversed() != bp.Reversed() {
+		t.Errorf("the options should be copied")
+	}
+	if fmt.Sprint(clone.Matchers()) != fmt.Sprint(bp.Matchers()) || clone.hits[0] != 1 {
+		t.Errorf("the rules and their hit counters should be copied")
+	}
+
+	clone.AddMatcher(NewMatcher("192.168.1.1"))
+	if bp.Len() != 1 || bp.Bypass("192.168.1.1") == clone.Bypass("192.168.1.1") {
+		t.Errorf("the rules added to the clone should not affect the original")
+	}
+	clone.RemoveMatcher("*.example.com")
+	if bp.Len() != 1 || bp.Bypass("www.example.com") {
+		t.Errorf("the rules removed from the clone should not affect the original")
+	}
+
+	clone.Stop()
+	if bp.Stopped() {
+		t.Errorf("stopping the clone should not stop the original")
+	}
+}
+
+func TestEqual(t *testing.T) {
+	a := NewBypasserPatterns(false, "*.example.com", "10.0.0.0/8", "!secure.example.com").(*bypasser)
+
+	for i, tc := range []struct {
+		other Bypasser
+		equal bool
+	}{
+		{a, true},
+		{NewBypasserPatterns(false, "!secure.example.com", "10.0.0.0/8", "*.example.com"), true},
+		{NewBypasserPatterns(true, "*.example.com", "10.0.0.0/8", "!secure.example.com"), false},
+		{NewBypasserPatterns(false, "*.example.com", "10.0.0.0/8"), false},
+		{NewBypasserPatterns(false, "*.example.com", "10.0.0.0/8", "!secure.example.com", "10.0.0.0/8"), false},
+		{NewBypasserPatterns(false, "*.example.com", "10.0.0.0/16", "!secure.example.com"), false},
+		{nil, false},
+		{(*bypasser)(nil), false},
+	} {
+		if a.Equal(tc.other) != tc.equal {
+			t.Errorf("#%d: expected %v", i, tc.equal)
+		}
+	}
+
+	b := a.Clone().(*bypasser)
+	if err := b.Reload(strings.NewReader("strip-port-domain false\n*.example.com\n10.0.0.0/8\n!secure.example.com\n")); err != nil {
+		t.Fatal(err)
+	}
+	if a.Equal(b) {
+		t.Errorf("the port stripping should be compared")
+	}
+
+	if NewBypasserPatterns(false, "example.org").(*bypasser).Equal(NewBypasserPatterns(false, ".example.org")) {
+		t.Errorf("example.org and .example.org should not be equal rules")
+	}
+
+	var nilBypasser *bypasser
+	if !nilBypasser.Equal(nil) || !nilBypasser.Equal(nilBypasser) || nilBypasser.Equal(a) {
+		t.Errorf("a nil bypass should only be equal to nil")
+	}
+}
+
+func TestDedup(t *testing.T) {
+	patterns := []string{
+		"10.0.0.1", "192.168.0.0/16", "*.example.com",
+		"10.0.0.1", "10.0.0.1-10.0.0.9", "192.168.0.0/16",
+		"*.example.com", "example.org", "10.0.0.1-10.0.0.9",
+		".example.org", "example.org",
+	}
+	want := []string{
+		"ip 10.0.0.1", "cidr 192.168.0.0/16", "domain *.example.com",
+		"range 10.0.0.1-10.0.0.9", "domain example.org", "domain .example.org",
+	}
+	config := strings.Join(patterns, "\n") + "\n"
+
+	checkRules := func(name string, bp Bypasser, n int) {
+		t.Helper()
+		matchers := bp.(*bypasser).Matchers()
+		if len(matchers) != n {
+			t.Fatalf("%s: expected %d rules, got %v", name, n, matchers)
+		}
+		if n != len(want) {
+			return
+		}
+		for i, m := range matchers {
+			if m.String() != want[i] {
+				t.Errorf("%s #%d: expected %q, got %q", name, i, want[i], m.String())
+			}
+		}
+	}
+
+	// disabled by default
+	checkRules("NewBypasserPatterns", NewBypasserPatterns(false, patterns...), len(patterns))
+	bp := NewBypasser(false).(*bypasser)
+	if err := bp.Reload(strings.NewReader(config)); err != nil {
+		t.Fatal(err)
+	}
+	checkRules("Reload", bp, len(patterns))
+
+	if err := bp.Reload(strings.NewReader("dedup true\n" + config)); err != nil {
+		t.Fatal(err)
+	}
+	checkRules("Reload dedup", bp, len(want))
+	if !bp.Bypass("www.example.org") {
+		t.Errorf("the dedup should keep the rule .example.org")
+	}
+
+	c := &Compiler{Dedup: true}
+	bp = c.NewBypasser(false, patterns...).(*bypasser)
+	checkRules("Compiler.NewBypasser", bp, len(want))
+	if err := bp.Reload(strings.NewReader(config)); err != nil {
+		t.Fatal(err)
+	}
+	checkRules("Compiler Reload", bp, len(want))
+	if err := bp.Reload(strings.NewReader("dedup false\n" + config)); err != nil {
+		t.Fatal(err)
+	}
+	checkRules("Compiler Reload without dedup", bp, len(patterns))
+
+	DefaultCompiler.Dedup = true
+	defer func() { DefaultCompiler.Dedup = false }()
+	checkRules("NewBypasserPatterns dedup", NewBypasserPatterns(false, patterns...), len(want))
+}
@@ -0,0 +1,82 @@
+package bypass
+
+import (
+	"strings"
+)
+
+type schemeMatcher struct {
+	Matcher
+	scheme string // in lower case
+	raw    string // the pattern the matcher is compiled from, if any
+}
+
+// SchemeMatcher creates a Matcher restricting the Matcher m of the host to the URLs of the given scheme,
+// e.g. the scheme 'http' and the domain matcher of '*.example.com' match 'http://www.example.com/path'
+// but neither 'https://www.example.com' nor 'www.example.com'.
+// The scheme is compared case-insensitively, the userinfo and the path of the URL are ignored.
+// The port of the URL is matched if m matches the host:port form, such as a PortMatcher.
+func SchemeMatcher(scheme string, m Matcher) Matcher {
+	return &schemeMatcher{
+		Matcher: m,
+		scheme:  strings.ToLower(scheme),
+	}
+}
+
+func (m *schemeMatcher) Match(v string) bool {
+	if m == nil || m.Matcher == nil {
+		return false
+	}
+	n := strings.Index(v, "://")
+	if n < 0 || !strings.EqualFold(v[:n], m.scheme) {
+		return false
+	}
+
+	addr := targetAddr(v)
+	if matchesAddr(m.Matcher) {
+		return m.Matcher.Match(addr)
+	}
+	host, _ := splitHostPort(addr)
+	return m.Matcher.Match(host)
+}
+
+func (m *schemeMatcher) String() string {
+	return m.scheme + "://" + m.Matcher.String()
+}
+
+// splitSchemePattern splits a 'scheme://host' pattern into its scheme and host pattern,
+// a trailing '/' is allowed. It returns false if the pattern has no valid scheme or has a path,
+// the host pattern can be a CIDR though.
+func splitSchemePattern(pattern string) (scheme, host string, ok bool) {
+	n := strings.Index(pattern, "://")
+	if n <= 0 || !isScheme(pattern[:n]) {
+		return "", "", false
+	}
+	host = strings.TrimSuffix(pattern[n+3:], "/")
+	if host == "" {
+		return "", "", false
+	}
+	if _, _, err := parseCIDR(host); err != nil && strings.ContainsAny(host, "/?#") {
+		return "", "", false
+	}
+	// a bracketed IPv6 address without port, such as '[::1]'
+	if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+		if ip, _ := parseIPZone(host[1 : len(host)-1]); ip != nil {
+			host = host[1 : len(host)-1]
+		}
+	}
+	return pattern[:n], host, true
+}
+
+// isScheme reports whether s is a valid URL scheme, see RFC 3986 section 3.1.
+func isScheme(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z':
+		case i > 0 && ('0' <= c && c <= '9' || c == '+' || c == '-' || c == '.'):
+		default:
+			return false
+		}
+	}
+	return s != ""
+}
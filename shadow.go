@@ -0,0 +1,60 @@
+package bypass
+
+import (
+	"context"
+	"log/slog"
+)
+
+// SetShadow sets the shadow bypass, an observe-only rule set for testing new rules before enabling them.
+// Each Bypass call also evaluates the address against other and records a divergence
+// when the decisions differ, the decision of the bypass itself is returned unchanged.
+// The divergences are counted (see ShadowDivergences) and logged at info level
+// to the structured logger if any, with the attributes addr, bypassed and shadow.
+// The shadow of other is not evaluated in turn, so that the bypass may shadow itself
+// or be the shadow of its shadow.
+// A nil Bypasser removes the shadow.
+func (bp *bypasser) SetShadow(other Bypasser) {
+	bp.mux.Lock()
+	defer bp.mux.Unlock()
+
+	bp.shadow = other
+}
+
+// ShadowDivergences returns the number of Bypass calls for which the shadow bypass decided differently.
+func (bp *bypasser) ShadowDivergences() uint64 {
+	return bp.divergences.Load()
+}
+
+// observeShadow evaluates the target t against the shadow and records a divergence
+// from the decision bypassed of the bypass.
+// A shadow other than a bypass of this package is evaluated on the address of t only.
+func (bp *bypasser) observeShadow(shadow Bypasser, t target, bypassed bool) {
+	addr := t.addr
+	var decision bool
+	if s, ok := shadow.(*bypasser); ok {
+		decision, _ = s.bypassTarget(t, false)
+	} else {
+		decision = shadow.Bypass(addr)
+	}
+	if decision == bypassed {
+		return
+	}
+	bp.divergences.Add(1)
+
+	bp.mux.RLock()
+	logger, name := bp.slogger, bp.name
+	bp.mux.RUnlock()
+
+	if logger == nil {
+		return
+	}
+	attrs := []slog.Attr{
+		slog.String("addr", addr),
+		slog.Bool("bypassed", bypassed),
+		slog.Bool("shadow", !bypassed),
+	}
+	if name != "" {
+		attrs = append(attrs, slog.String("name", name))
+	}
+	logger.LogAttrs(context.Background(), slog.LevelInfo, "bypass shadow diverges", attrs...)
+}
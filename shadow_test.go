@@ -0,0 +1,74 @@
+package bypass
+
+import (
+	"log/slog"
+	"testing"
+)
+
+func TestShadow(t *testing.T) {
+	bp := NewBypasserPatterns(false, "10.0.0.0/8", "*.example.com").(*bypasser)
+	shadow := NewBypasserPatterns(false, "10.0.0.0/8", "*.example.org")
+
+	h := &captureHandler{level: slog.LevelInfo}
+	bp.SetSlogger(slog.New(h))
+	bp.SetShadow(shadow)
+
+	for _, tc := range []struct {
+		addr     string
+		bypassed bool
+	}{
+		{"10.1.2.3", true},
+		{"www.example.com", true},
+		{"www.example.org", false},
+		{"192.168.1.1", false},
+	} {
+		if bp.Bypass(tc.addr) != tc.bypassed {
+			t.Errorf("the shadow should not change the decision for %s", tc.addr)
+		}
+	}
+
+	if n := bp.ShadowDivergences(); n != 2 {
+		t.Errorf("expected 2 divergences, got %d", n)
+	}
+	if len(h.records) != 2 {
+		t.Fatalf("expected 2 records, got %d", len(h.records))
+	}
+	for i, want := range []map[string]string{
+		{"addr": "www.example.com", "bypassed": "true", "shadow": "false"},
+		{"addr": "www.example.org", "bypassed": "false", "shadow": "true"},
+	} {
+		attrs := recordAttrs(h.records[i])
+		for k, v := range want {
+			if attrs[k] != v {
+				t.Errorf("#%d expected %s=%s, got %s", i, k, v, attrs[k])
+			}
+		}
+	}
+
+	bp.SetShadow(nil)
+	bp.Bypass("www.example.com")
+	if n := bp.ShadowDivergences(); n != 2 {
+		t.Errorf("divergences should not be recorded without a shadow, got %d", n)
+	}
+}
+
+func TestShadowCycle(t *testing.T) {
+	bp := NewBypasserPatterns(false, "*.example.com").(*bypasser)
+	bp.SetShadow(bp)
+	if !bp.Bypass("www.example.com") || bp.ShadowDivergences() != 0 {
+		t.Errorf("the bypass should shadow itself without divergences")
+	}
+
+	other := NewBypasserPatterns(false, "*.example.org").(*bypasser)
+	bp.SetShadow(other)
+	other.SetShadow(bp)
+	if !bp.Bypass("www.example.com") || other.Bypass("www.example.com") {
+		t.Errorf("the shadows should not change the decisions")
+	}
+	if n := bp.ShadowDivergences(); n != 1 {
+		t.Errorf("expected 1 divergence, got %d", n)
+	}
+	if n := other.ShadowDivergences(); n != 1 {
+		t.Errorf("expected 1 divergence of the other bypass, got %d", n)
+	}
+}
@@ -0,0 +1,130 @@
+package bypass
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+)
+
+// SetSlogger sets the structured logger of the bypass.
+// Each decision is logged at debug level with the attributes addr, bypassed, rule and kind,
+// the attributes are only built when the logger is enabled for that level.
+// The warnings of the reloaders are logged to the logger as well instead of the standard logger.
+// A nil logger disables the structured logging.
+func (bp *bypasser) SetSlogger(logger *slog.Logger) {
+	bp.mux.Lock()
+	defer bp.mux.Unlock()
+
+	bp.slogger = logger
+}
+
+// SetName sets the name of the bypass, such as the upstream it decides for, to tell apart the bypasses of a gateway.
+// A non-empty name is logged with the decisions and the warnings, as the name attribute of the structured logs,
+// and reported in the Stats.
+func (bp *bypasser) SetName(name string) {
+	bp.mux.Lock()
+	defer bp.mux.Unlock()
+
+	bp.name = name
+}
+
+// Name returns the name of the bypass, empty if it has not been named.
+func (bp *bypasser) Name() string {
+	bp.mux.RLock()
+	defer bp.mux.RUnlock()
+
+	return bp.name
+}
+
+// logDecision logs the decision for the target t, i is the index of the matched rule or -1.
+// The caller must hold bp.mux.
+func (bp *bypasser) logDecision(t target, bypassed bool, i int) {
+	logger := bp.slogger
+	if logger == nil {
+		return
+	}
+	ctx := context.Background()
+	if !logger.Enabled(ctx, slog.LevelDebug) {
+		return
+	}
+
+	rule, kind := "", ""
+	if i >= 0 {
+		rule, kind = bp.matchers[i].String(), matcherKindName(bp.matchers[i])
+	}
+	attrs := []slog.Attr{
+		slog.String("addr", t.addr),
+		slog.Bool("bypassed", bypassed),
+		slog.String("rule", rule),
+		slog.String("kind", kind),
+	}
+	if bp.name != "" {
+		attrs = append(attrs, slog.String("name", bp.name))
+	}
+	logger.LogAttrs(ctx, slog.LevelDebug, "bypass", attrs...)
+}
+
+// logf logs a warning to the structured logger if any, or to the standard logger.
+func (bp *bypasser) logf(format string, args ...interface{}) {
+	bp.mux.RLock()
+	logger, name := bp.slogger, bp.name
+	bp.mux.RUnlock()
+
+	if logger != nil {
+		if name != "" {
+			logger.Warn(fmt.Sprintf(format, args...), slog.String("name", name))
+		} else {
+			logger.Warn(fmt.Sprintf(format, args...))
+		}
+		return
+	}
+	if name != "" {
+		log.Printf("bypass %s: "+format, append([]interface{}{name}, args...)...)
+		return
+	}
+	log.Printf("bypass: "+format, args...)
+}
+
+func matcherKindName(matcher Matcher) string {
+	switch m := matcher.(type) {
+	case *ipMatcher:
+		return "ip"
+	case *cidrMatcher:
+		return "cidr"
+	case *domainMatcher, *domainGroupMatcher, *suffixTrieMatcher:
+		return "domain"
+	case *rangeMatcher:
+		return "range"
+	case *regexMatcher:
+		return "regex"
+	case *prefixMatcher:
+		return "prefix"
+	case *suffixMatcher:
+		return "suffix"
+	case *labelCountMatcher:
+		return "labels"
+	case *resolveMatcher:
+		return "resolve"
+	case *ptrMatcher:
+		return "ptr"
+	case *anyMatcher:
+		return "any"
+	case *noneMatcher:
+		return "none"
+	case *qualifiedMatcher:
+		return matcherKindName(m.Matcher)
+	case *portMatcher:
+		return matcherKindName(m.Matcher)
+	case *negatedMatcher:
+		return matcherKindName(m.Matcher)
+	case *deniedMatcher:
+		return matcherKindName(m.Matcher)
+	case *schemeMatcher:
+		return matcherKindName(m.Matcher)
+	case *expiringMatcher:
+		return matcherKindName(m.Matcher)
+	default:
+		return "custom"
+	}
+}
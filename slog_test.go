@@ -0,0 +1,120 @@
+package bypass
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// captureHandler records the log records.
+type captureHandler struct {
+	level   slog.Level
+	records []slog.Record
+	mux     sync.Mutex
+}
+
+func (h *captureHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level
+}
+
+func (h *captureHandler) Handle(_ context.Context, r slog.Record) error {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	h.records = append(h.records, r)
+	return nil
+}
+
+func (h *captureHandler) WithAttrs([]slog.Attr) slog.Handler { return h }
+func (h *captureHandler) WithGroup(string) slog.Handler      { return h }
+
+func recordAttrs(r slog.Record) map[string]string {
+	attrs := map[string]string{}
+	r.Attrs(func(a slog.Attr) bool {
+		attrs[a.Key] = a.Value.String()
+		return true
+	})
+	return attrs
+}
+
+func TestSlogger(t *testing.T) {
+	h := &captureHandler{level: slog.LevelDebug}
+	bp := NewBypasserPatterns(false, "10.0.0.0/8", "*.example.com").(*bypasser)
+	bp.SetSlogger(slog.New(h))
+
+	bp.Bypass("www.example.com:443")
+	bp.Bypass("192.168.1.1")
+
+	if len(h.records) != 2 {
+		t.Fatalf("expected 2 records, got %d", len(h.records))
+	}
+	for i, want := range []map[string]string{
+		{"addr": "www.example.com:443", "bypassed": "true", "rule": "domain *.example.com", "kind": "domain"},
+		{"addr": "192.168.1.1", "bypassed": "false", "rule": "", "kind": ""},
+	} {
+		r := h.records[i]
+		if r.Level != slog.LevelDebug {
+			t.Errorf("#%d expected debug level, got %v", i, r.Level)
+		}
+		attrs := recordAttrs(r)
+		for k, v := range want {
+			if attrs[k] != v {
+				t.Errorf("#%d expected %s=%q, got %q", i, k, v, attrs[k])
+			}
+		}
+	}
+}
+
+func TestSloggerDisabled(t *testing.T) {
+	h := &captureHandler{level: slog.LevelInfo}
+	bp := NewBypasserPatterns(false, "*.example.com").(*bypasser)
+	bp.SetSlogger(slog.New(h))
+
+	bp.Bypass("www.example.com")
+	if len(h.records) != 0 {
+		t.Errorf("decisions should not be logged above debug level")
+	}
+
+	// warnings are logged to the structured logger
+	if err := bp.Reload(strings.NewReader("validate-hostnames true\nexa_mple.com\n")); err != nil {
+		t.Fatal(err)
+	}
+	if len(h.records) != 1 || h.records[0].Level != slog.LevelWarn {
+		t.Errorf("expected a warning record, got %v", h.records)
+	}
+}
+
+func TestName(t *testing.T) {
+	h := &captureHandler{level: slog.LevelDebug}
+	bp := NewBypasserPatterns(false, "*.example.com").(*bypasser)
+	bp.SetSlogger(slog.New(h))
+
+	if bp.Name() != "" || bp.Stats().Name != "" {
+		t.Errorf("a bypass should have no name by default")
+	}
+	bp.Bypass("www.example.com")
+	if _, ok := recordAttrs(h.records[0])["name"]; ok {
+		t.Errorf("an unnamed bypass should not log a name")
+	}
+
+	bp.SetName("upstream-a")
+	if bp.Name() != "upstream-a" || bp.Stats().Name != "upstream-a" {
+		t.Errorf("expected the name upstream-a, got %q", bp.Name())
+	}
+	bp.Bypass("www.example.com")
+	if err := bp.Reload(strings.NewReader("validate-hostnames true\nexa_mple.com\n")); err != nil {
+		t.Fatal(err)
+	}
+	if len(h.records) != 3 {
+		t.Fatalf("expected 3 records, got %d", len(h.records))
+	}
+	for _, r := range h.records[1:] {
+		if name := recordAttrs(r)["name"]; name != "upstream-a" {
+			t.Errorf("%q: expected the name upstream-a, got %q", r.Message, name)
+		}
+	}
+	if bp.Clone().(*bypasser).Name() != "upstream-a" {
+		t.Errorf("the name should be cloned")
+	}
+}
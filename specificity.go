@@ -0,0 +1,174 @@
+package bypass
+
+import (
+	"math/bits"
+	"strings"
+)
+
+// SpecificMatcher is a Matcher telling how specific its pattern is, see SetSpecificityOrder.
+// A custom Matcher may implement it to rank its rules among the built-in ones,
+// whose specificities are the following:
+//
+//	300      an IP address
+//	100-228  a CIDR or an IP range, plus the length of its prefix in the IPv6 form (96 more bits for IPv4)
+//	60       a plain domain, such as 'www.example.com'
+//	20-39    a domain wildcard, plus the number of its literal labels, such as '*.example.com'
+//	30       a prefix or a suffix, such as 'svc-*'
+//	20       a group of domain wildcards
+//	10       a regular expression or a label count
+//	0        the catch-all pattern '*'
+//
+// A Matcher not implementing it has the specificity DefaultSpecificity.
+type SpecificMatcher interface {
+	Matcher
+	// Specificity returns the specificity of the pattern, the higher the more specific.
+	Specificity() int
+}
+
+// DefaultSpecificity is the specificity of a custom Matcher not implementing SpecificMatcher,
+// between the domain wildcards and the plain domains.
+const DefaultSpecificity = 50
+
+// Specificity returns the specificity of the Matcher m, see SpecificMatcher.
+// A wrapped Matcher, such as a negated rule or a rule restricted to some ports,
+// has the specificity of the Matcher it wraps, plus one for each restriction.
+func Specificity(m Matcher) int {
+	switch m := m.(type) {
+	case nil:
+		return 0
+	case SpecificMatcher:
+		return m.Specificity()
+	case *ipMatcher:
+		return 300
+	case *resolveMatcher:
+		return 300
+	case *ptrMatcher:
+		return Specificity(m.domain)
+	case *cidrMatcher:
+		ones, size := m.ipNet.Mask.Size()
+		return 100 + 128 - size + ones
+	case *rangeMatcher:
+		return 100 + commonPrefixLen(m.low.To16(), m.high.To16())
+	case *domainMatcher:
+		if m.glob == nil {
+			return 0
+		}
+		if isPlainDomain(m.pattern) {
+			return 60
+		}
+		return 20 + min(literalLabels(m.pattern), 19)
+	case *domainGroupMatcher, *suffixTrieMatcher:
+		return 20
+	case *prefixMatcher, *suffixMatcher:
+		return 30
+	case *regexMatcher, *labelCountMatcher:
+		return 10
+	case *anyMatcher, *noneMatcher:
+		return 0
+	case *compositeMatcher:
+		// all the matchers of an and rule match, it is as specific as the most specific one,
+		// and the other way around for an or rule
+		n := -1
+		for _, matcher := range m.matchers {
+			s := Specificity(matcher)
+			if n < 0 || m.op == "and" && s > n || m.op == "or" && s < n {
+				n = s
+			}
+		}
+		return max(n, 0)
+	case *negatedMatcher:
+		return Specificity(m.Matcher)
+	case *deniedMatcher:
+		return Specificity(m.Matcher)
+	case *expiringMatcher:
+		return Specificity(m.Matcher)
+	case *schemeMatcher:
+		return Specificity(m.Matcher) + 1
+	case *portMatcher:
+		return Specificity(m.Matcher) + 1
+	case *qualifiedMatcher:
+		n := Specificity(m.Matcher)
+		if len(m.ports) > 0 {
+			n++
+		}
+		if len(m.protos) > 0 {
+			n++
+		}
+		return n
+	default:
+		return DefaultSpecificity
+	}
+}
+
+// commonPrefixLen returns the number of the leading bits a and b have in common.
+func commonPrefixLen(a, b []byte) int {
+	n := 0
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if x := a[i] ^ b[i]; x != 0 {
+			return n + bits.LeadingZeros8(x)
+		}
+		n += 8
+	}
+	return n
+}
+
+// literalLabels returns the number of the labels of the domain pattern without any wildcard.
+func literalLabels(pattern string) int {
+	n := 0
+	for _, label := range strings.Split(strings.Trim(pattern, ".^$"), ".") {
+		if label != "" && !hasGlobMeta(label) {
+			n++
+		}
+	}
+	return n
+}
+
+// SetSpecificityOrder sets whether the most specific rule matching an address decides on it,
+// rather than the first one in the order of the rules.
+// E.g. with the rules '*.example.com' and '!secure.example.com', in any order,
+// 'www.example.com' is bypassed and 'secure.example.com' is not: the plain domain is more specific than the wildcard,
+// so that a narrow exception can be written next to a broad rule, wherever it comes in the config.
+// The negated rules then take precedence over the plain ones only if they are as specific,
+// the deny rules still take precedence over all the others, see Specificity for the order of the rules.
+// The rules of the same specificity and rank are matched in their order.
+//
+// It is disabled by default; the rule index and the adaptive ordering do not apply while it is enabled.
+// The option is kept on reload.
+func (bp *bypasser) SetSpecificityOrder(enabled bool) {
+	bp.mux.Lock()
+	defer bp.mux.Unlock()
+
+	bp.specificityOrder = enabled
+	bp.resetResults()
+}
+
+// matchSpecific returns the index of the matcher deciding on t in the specificity order, or -1 if none matches.
+// The deny rules come first, then the most specific rules, negated ones first.
+// The caller must hold bp.mux.
+func (bp *bypasser) matchSpecific(t target, kind int) int {
+	matched, matchedRank, matchedSpec := -1, -1, -1
+	for i, matcher := range bp.matchers {
+		if matcher == nil {
+			continue
+		}
+		rank, spec := matcherRank(matcher), Specificity(matcher)
+		if matched >= 0 && !specificBefore(rank, spec, matchedRank, matchedSpec) {
+			continue
+		}
+		if bp.matchRule(matcher, t, kind) {
+			matched, matchedRank, matchedSpec = i, rank, spec
+		}
+	}
+	return matched
+}
+
+// specificBefore reports whether a rule of the given rank and specificity comes before the other one.
+func specificBefore(rank, spec, otherRank, otherSpec int) bool {
+	if rank == rankDenied || otherRank == rankDenied {
+		return rank > otherRank
+	}
+	if spec != otherSpec {
+		return spec > otherSpec
+	}
+	return rank > otherRank
+}
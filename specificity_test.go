@@ -0,0 +1,144 @@
+package bypass
+
+import (
+	"net"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// specificMatcher is a custom matcher of a given specificity.
+type specificMatcher struct {
+	Matcher
+	specificity int
+}
+
+func (m *specificMatcher) Specificity() int {
+	return m.specificity
+}
+
+func TestSpecificity(t *testing.T) {
+	_, ipNet, _ := net.ParseCIDR("10.1.0.0/16")
+	ordered := []Matcher{
+		IPMatcher(net.ParseIP("10.1.2.3")),
+		NewMatcher("10.1.2.3/32"),
+		NewMatcher("10.0.0.0-10.0.0.255"),
+		CIDRMatcher(ipNet),
+		NewMatcher("10.0.0.0/8"),
+		NewMatcher("2001:db8::/64"),
+		NewMatcher("www.example.com"),
+		&countingMatcher{host: "example.com"},
+		NewMatcher("*.api.example.com"),
+		NewMatcher("*.example.com"),
+		NewMatcher("*"),
+	}
+	for i := 1; i < len(ordered); i++ {
+		if a, b := Specificity(ordered[i-1]), Specificity(ordered[i]); a <= b {
+			t.Errorf("%v (%d) should be more specific than %v (%d)", ordered[i-1], a, ordered[i], b)
+		}
+	}
+
+	for _, tc := range []struct {
+		m           Matcher
+		specificity int
+	}{
+		{NegateMatcher(NewMatcher("www.example.com")), 60},
+		{DenyMatcher(NewMatcher("www.example.com")), 60},
+		{NewMatcher("www.example.com:443"), 61},
+		{NewMatcher("https://www.example.com"), 61},
+		{QualifyMatcher(NewMatcher("www.example.com"), []int{443}, []string{"tcp"}), 62},
+		{AndMatcher(NewMatcher("*.example.com"), NewMatcher("www.example.com")), 60},
+		{OrMatcher(NewMatcher("*.example.com"), NewMatcher("www.example.com")), 22},
+		{&specificMatcher{NewMatcher("www.example.com"), 500}, 500},
+		{nil, 0},
+	} {
+		if specificity := Specificity(tc.m); specificity != tc.specificity {
+			t.Errorf("%v: expected %d, got %d", tc.m, tc.specificity, specificity)
+		}
+	}
+}
+
+func TestSpecificityOrder(t *testing.T) {
+	rules := []Matcher{
+		NegateMatcher(NewMatcher("*.example.com")),
+		NewMatcher("api.example.com"),
+		NewMatcher("*.internal.example.com"),
+		NegateMatcher(NewMatcher("secure.internal.example.com")),
+		NegateMatcher(NewMatcher("10.0.0.0/8")),
+		NewMatcher("10.1.0.0/16"),
+		NegateMatcher(NewMatcher("10.1.2.3")),
+	}
+	expected := map[string]bool{
+		"www.example.com":                 false,
+		"api.example.com:443":             true,
+		"db.internal.example.com":         true,
+		"secure.internal.example.com:443": false,
+		"example.org":                     false,
+		"10.2.0.1":                        false,
+		"10.1.0.1:80":                     true,
+		"10.1.2.3":                        false,
+	}
+
+	// the order of the rules does not matter
+	reversed := slices.Clone(rules)
+	slices.Reverse(reversed)
+	for _, matchers := range [][]Matcher{rules, reversed} {
+		bp := NewBypasser(false, matchers...).(*bypasser)
+		bp.SetSpecificityOrder(true)
+		for addr, bypassed := range expected {
+			if bp.Bypass(addr) != bypassed {
+				t.Errorf("%s: expected %v", addr, bypassed)
+			}
+		}
+	}
+
+	// the negated rules take precedence otherwise
+	bp := NewBypasser(false, rules...).(*bypasser)
+	if bp.Bypass("api.example.com") || bp.Bypass("10.1.0.1") {
+		t.Errorf("the negated rules should take precedence without the specificity order")
+	}
+	bp.SetSpecificityOrder(true)
+	if !bp.Bypass("api.example.com") || !bp.Bypass("10.1.0.1") {
+		t.Errorf("the specificity order should apply once enabled")
+	}
+
+	// the deny rules still take precedence
+	bp = NewBypasser(false, DenyMatcher(NewMatcher("*.example.com")), NegateMatcher(NewMatcher("www.example.com"))).(*bypasser)
+	bp.SetSpecificityOrder(true)
+	if !bp.Bypass("www.example.com") {
+		t.Errorf("the deny rule should take precedence over a more specific rule")
+	}
+
+	// the rules of the same specificity are matched in their order, negated ones first
+	bp = NewBypasser(false, NewMatcher("www.example.com"), NegateMatcher(NewMatcher("www.example.com"))).(*bypasser)
+	bp.SetSpecificityOrder(true)
+	if bp.Bypass("www.example.com") {
+		t.Errorf("the negated rule should take precedence over a plain rule as specific")
+	}
+}
+
+func TestSpecificityOrderReload(t *testing.T) {
+	bp := NewBypasser(false).(*bypasser)
+	bp.SetSpecificityOrder(true)
+	config := "*.com\n" +
+		"!*.example.com\n" +
+		"api.example.com\n"
+	if err := bp.Reload(strings.NewReader(config)); err != nil {
+		t.Fatal(err)
+	}
+
+	for addr, bypassed := range map[string]bool{
+		"api.example.com": true,
+		"www.example.com": false,
+		"www.example.net": false,
+		"golang.com":      true,
+	} {
+		if bp.Bypass(addr) != bypassed {
+			t.Errorf("%s: expected %v", addr, bypassed)
+		}
+	}
+
+	if !bp.Clone().(*bypasser).specificityOrder {
+		t.Errorf("the specificity order should be cloned")
+	}
+}
@@ -0,0 +1,149 @@
+package bypass
+
+import (
+	"strings"
+	"unsafe"
+)
+
+// suffixTrieMatcher matches a set of '.suffix' domain patterns with a labelTrie,
+// a domain is looked up in O(labels) whatever the number of patterns.
+// As the glob of a '.suffix' pattern, see suffixExpr, it matches on label boundaries only,
+// e.g. '.example.com' matches 'www.example.com' but not 'wwwexample.com'.
+// It can not tell which of the patterns matched.
+type suffixTrieMatcher struct {
+	trie  *labelTrie
+	exprs []string // the raw patterns
+}
+
+// labelTrie is a trie of the reversed labels of the domain rules of a normalization,
+// e.g. '.example.com' is stored as 'com' -> 'example'.
+// It is the trie of the index-suffixes option, see suffixTrieMatcher, and of the domain rules of a ruleIndex.
+type labelTrie struct {
+	root *labelNode
+	norm domainNorm
+}
+
+// domainNorm is the normalization of the domains matched by a domain rule.
+type domainNorm struct {
+	fold bool
+	idn  bool
+}
+
+// labelMatch is what a domain rule stored at a node of a labelTrie matches.
+type labelMatch int
+
+const (
+	labelSuffix     labelMatch = iota // the domain of the node and its subdomains, such as '.example.com'
+	labelSubdomains                   // the subdomains of the domain of the node, such as '*.example.com'
+	labelExact                        // the domain of the node only, such as 'example.com'
+)
+
+type labelNode struct {
+	children map[string]*labelNode
+	rules    [3]int // the lowest index of the rules ending at the node by labelMatch, -1 if none
+}
+
+func newLabelTrie(norm domainNorm) *labelTrie {
+	return &labelTrie{
+		root: newLabelNode(),
+		norm: norm,
+	}
+}
+
+func newLabelNode() *labelNode {
+	return &labelNode{rules: [3]int{-1, -1, -1}}
+}
+
+func (tr *labelTrie) insert(domain string, match labelMatch, rule int) {
+	node := tr.root
+	for end := len(domain); ; {
+		i := strings.LastIndexByte(domain[:end], '.')
+		label := domain[i+1 : end]
+		child := node.children[label]
+		if child == nil {
+			if node.children == nil {
+				node.children = make(map[string]*labelNode)
+			}
+			child = newLabelNode()
+			node.children[label] = child
+		}
+		node = child
+		if i < 0 {
+			break
+		}
+		end = i
+	}
+	node.rules[match] = minRule(node.rules[match], rule)
+}
+
+// lookup returns the lowest index of the rules of the trie matching the domain, -1 if none.
+func (tr *labelTrie) lookup(domain string) int {
+	if tr.norm.fold || tr.norm.idn {
+		domain = (&domainMatcher{fold: tr.norm.fold, idn: tr.norm.idn}).normalize(domain)
+	}
+	domain = trimTrailingDot(domain)
+
+	rule := -1
+	node := tr.root
+	for end := len(domain); ; {
+		i := strings.LastIndexByte(domain[:end], '.')
+		if node = node.children[domain[i+1:end]]; node == nil {
+			return rule
+		}
+		rule = minRule(rule, node.rules[labelSuffix])
+		if i < 0 {
+			return minRule(rule, node.rules[labelExact])
+		}
+		// some labels are left, the domain is a subdomain of the node
+		rule = minRule(rule, node.rules[labelSubdomains])
+		end = i
+	}
+}
+
+func (n *labelNode) memBytes() int {
+	size := int(unsafe.Sizeof(*n))
+	for label, child := range n.children {
+		size += int(unsafe.Sizeof(label)) + len(label) + int(unsafe.Sizeof(child)) + child.memBytes()
+	}
+	return size
+}
+
+func (m *suffixTrieMatcher) Match(domain string) bool {
+	if m == nil || m.trie == nil || domain == "" {
+		return false
+	}
+	return m.trie.lookup(domain) >= 0
+}
+
+func (m *suffixTrieMatcher) String() string {
+	return "domain suffixes {" + strings.Join(m.exprs, ",") + "}"
+}
+
+// indexSuffixMatchers replaces the '.suffix' domain matchers in matchers,
+// the ones without any other wildcard, with a single matcher looking them up in a trie.
+// The other matchers are kept as they are.
+func indexSuffixMatchers(matchers []Matcher) []Matcher {
+	var trie *suffixTrieMatcher
+	var others []Matcher
+	for _, matcher := range matchers {
+		m, ok := matcher.(*domainMatcher)
+		if !ok || trie != nil && (domainNorm{m.fold, m.idn}) != trie.trie.norm {
+			others = append(others, matcher)
+			continue
+		}
+		suffix, match, ok := indexedDomain(m)
+		if !ok || match != labelSuffix {
+			others = append(others, matcher)
+			continue
+		}
+		if trie == nil {
+			trie = &suffixTrieMatcher{trie: newLabelTrie(domainNorm{m.fold, m.idn})}
+		}
+		trie.trie.insert(suffix, labelSuffix, 0)
+		trie.exprs = append(trie.exprs, m.raw)
+	}
+	if trie == nil || len(trie.exprs) < 2 {
+		return matchers
+	}
+	return append(others, trie)
+}
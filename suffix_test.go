@@ -0,0 +1,152 @@
+package bypass
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestIndexSuffixes(t *testing.T) {
+	patterns := []string{
+		"192.168.1.1",
+		".example.com",
+		".example.org",
+		".a.b.example.net",
+		"*.example.io",
+		".example.{de,fr}",
+		"^.example.info$",
+	}
+	config := "index-suffixes true\n" + strings.Join(patterns, "\n")
+
+	bp := NewBypasser(false).(*bypasser)
+	if err := bp.Reload(strings.NewReader(config)); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(bp.matchers); n != 5 {
+		t.Errorf("expected 5 matchers after indexing, got %d", n)
+	}
+
+	plain := NewBypasserPatterns(false, patterns...)
+	for i, addr := range []string{
+		"192.168.1.1",
+		"example.com",
+		"www.example.com:443",
+		"a.b.example.com",
+		"example.com.cn",
+		"example.org",
+		"www.example.org",
+		"example.net",
+		"b.example.net",
+		"a.b.example.net",
+		"www.a.b.example.net",
+		"www.example.io",
+		"example.de",
+		"www.example.fr",
+		".example.info",
+		"www.example.info",
+		"com",
+	} {
+		if bp.Bypass(addr) != plain.Bypass(addr) {
+			t.Errorf("#%d indexed and plain rules differ for %s", i, addr)
+		}
+	}
+
+	// the suffixes match on label boundaries, indexed or not
+	for _, addr := range []string{"wwwexample.com", "notexample.org"} {
+		if bp.Bypass(addr) || plain.Bypass(addr) {
+			t.Errorf("%s should not be bypassed", addr)
+		}
+	}
+
+	if s := bp.PrunedConfig(0); !strings.Contains(s, ".example.com\n.example.org\n.a.b.example.net\n") {
+		t.Errorf("the indexed rules should be written back as patterns, got %q", s)
+	}
+}
+
+func TestIndexSuffixesDifferential(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	words := []string{"example", "www", "wwwexample", "ex", "ample", "a"}
+	word := func() string { return words[r.Intn(len(words))] }
+
+	for n := 0; n < 20; n++ {
+		var sb strings.Builder
+		for i := 0; i < 8; i++ {
+			switch i % 4 {
+			case 0:
+				fmt.Fprintf(&sb, ".%s.com\n", word())
+			case 1:
+				fmt.Fprintf(&sb, ".%s.%s\n", word(), word())
+			case 2:
+				fmt.Fprintf(&sb, ".%s\n", word())
+			default:
+				fmt.Fprintf(&sb, "*.%s.org\n", word())
+			}
+		}
+		indexed := NewBypasser(false).(*bypasser)
+		if err := indexed.Reload(strings.NewReader("index-suffixes true\n" + sb.String())); err != nil {
+			t.Fatal(err)
+		}
+		plain := NewBypasser(false).(*bypasser)
+		if err := plain.Reload(strings.NewReader(sb.String())); err != nil {
+			t.Fatal(err)
+		}
+		if indexed.Len() >= plain.Len() {
+			t.Fatalf("the suffixes should be indexed")
+		}
+
+		for i := 0; i < 500; i++ {
+			host := word()
+			for n := r.Intn(3); n > 0; n-- {
+				host += "." + word()
+			}
+			if tld := []string{"com", "org", ""}[r.Intn(3)]; tld != "" {
+				host += "." + tld
+			}
+			if r.Intn(10) == 0 {
+				host += "."
+			}
+			if got, want := indexed.Bypass(host), plain.Bypass(host); got != want {
+				t.Fatalf("%v, %s: the indexed suffixes give %v, the plain rules %v", plain.Matchers(), host, got, want)
+			}
+		}
+	}
+}
+
+func TestIndexSuffixesIgnoreCase(t *testing.T) {
+	bp := (&Compiler{IgnoreCase: true}).NewBypasser(false).(*bypasser)
+	if err := bp.Reload(strings.NewReader("index-suffixes true\n.Example.com\n.example.ORG\n")); err != nil {
+		t.Fatal(err)
+	}
+	if !bp.Bypass("WWW.EXAMPLE.COM") || !bp.Bypass("www.example.org") {
+		t.Errorf("the indexed rules should honor the compiler options")
+	}
+}
+
+func benchmarkSuffixPatterns(n int) string {
+	var sb strings.Builder
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&sb, ".example%d.com\n", i)
+	}
+	return sb.String()
+}
+
+func BenchmarkSuffixMatchers(b *testing.B) {
+	bp := NewBypasser(false).(*bypasser)
+	bp.Reload(strings.NewReader(benchmarkSuffixPatterns(10000)))
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		bp.Bypass("www.example9999.com")
+	}
+}
+
+func BenchmarkIndexedSuffixMatchers(b *testing.B) {
+	bp := NewBypasser(false).(*bypasser)
+	bp.Reload(strings.NewReader("index-suffixes true\n" + benchmarkSuffixPatterns(10000)))
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		bp.Bypass("www.example9999.com")
+	}
+}
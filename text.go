@@ -0,0 +1,207 @@
+package bypass
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// The matchers created from a pattern are encoding.TextMarshalers and encoding.TextUnmarshalers,
+// so that the rules can be embedded in JSON or YAML configs. Their text form is their pattern,
+// as given to NewMatcher or in a config, such as '192.168.1.0/24', '*.example.com' or '!secure.example.com'.
+
+func (m *ipMatcher) MarshalText() ([]byte, error)    { return marshalMatcherText(m) }
+func (m *ipMatcher) UnmarshalText(text []byte) error { return unmarshalMatcherText(m, text) }
+
+func (m *cidrMatcher) MarshalText() ([]byte, error)    { return marshalMatcherText(m) }
+func (m *cidrMatcher) UnmarshalText(text []byte) error { return unmarshalMatcherText(m, text) }
+
+func (m *domainMatcher) MarshalText() ([]byte, error)    { return marshalMatcherText(m) }
+func (m *domainMatcher) UnmarshalText(text []byte) error { return unmarshalMatcherText(m, text) }
+
+func (m *rangeMatcher) MarshalText() ([]byte, error)    { return marshalMatcherText(m) }
+func (m *rangeMatcher) UnmarshalText(text []byte) error { return unmarshalMatcherText(m, text) }
+
+func (m *regexMatcher) MarshalText() ([]byte, error)    { return marshalMatcherText(m) }
+func (m *regexMatcher) UnmarshalText(text []byte) error { return unmarshalMatcherText(m, text) }
+
+func (m *prefixMatcher) MarshalText() ([]byte, error)    { return marshalMatcherText(m) }
+func (m *prefixMatcher) UnmarshalText(text []byte) error { return unmarshalMatcherText(m, text) }
+
+func (m *suffixMatcher) MarshalText() ([]byte, error)    { return marshalMatcherText(m) }
+func (m *suffixMatcher) UnmarshalText(text []byte) error { return unmarshalMatcherText(m, text) }
+
+func (m *labelCountMatcher) MarshalText() ([]byte, error)    { return marshalMatcherText(m) }
+func (m *labelCountMatcher) UnmarshalText(text []byte) error { return unmarshalMatcherText(m, text) }
+
+func (m *anyMatcher) MarshalText() ([]byte, error)    { return marshalMatcherText(m) }
+func (m *anyMatcher) UnmarshalText(text []byte) error { return unmarshalMatcherText(m, text) }
+
+func (m *portMatcher) MarshalText() ([]byte, error)    { return marshalMatcherText(m) }
+func (m *portMatcher) UnmarshalText(text []byte) error { return unmarshalMatcherText(m, text) }
+
+func (m *schemeMatcher) MarshalText() ([]byte, error)    { return marshalMatcherText(m) }
+func (m *schemeMatcher) UnmarshalText(text []byte) error { return unmarshalMatcherText(m, text) }
+
+func (m *negatedMatcher) MarshalText() ([]byte, error)    { return marshalMatcherText(m) }
+func (m *negatedMatcher) UnmarshalText(text []byte) error { return unmarshalMatcherText(m, text) }
+
+func (m *deniedMatcher) MarshalText() ([]byte, error)    { return marshalMatcherText(m) }
+func (m *deniedMatcher) UnmarshalText(text []byte) error { return unmarshalMatcherText(m, text) }
+
+// marshalMatcherText returns the pattern of the matcher m,
+// or an error if m has no single pattern, such as a PortMatcher of ports 80 and 443.
+func marshalMatcherText(m Matcher) ([]byte, error) {
+	patterns, ok := matcherPatterns(m)
+	if !ok || len(patterns) != 1 {
+		return nil, fmt.Errorf("bypass: %v has no pattern", m)
+	}
+	return []byte(patterns[0]), nil
+}
+
+// unmarshalMatcherText compiles the pattern text by DefaultCompiler and sets m to the compiled matcher,
+// which must be of the type of m.
+func unmarshalMatcherText[T any, PT interface {
+	*T
+	Matcher
+}](m PT, text []byte) error {
+	matcher, err := compilePattern(string(text))
+	if err != nil {
+		return err
+	}
+	compiled, ok := matcher.(PT)
+	if !ok {
+		return fmt.Errorf("bypass: pattern %q gives another kind of rule: %v", text, matcher)
+	}
+	*m = *compiled
+	return nil
+}
+
+// compilePattern compiles the pattern of a rule by DefaultCompiler,
+// with the '!', 'allow ' and 'deny ' prefixes of the config.
+func compilePattern(pattern string) (Matcher, error) {
+	return new(bypasser).compile(pattern)
+}
+
+// MatcherList is a list of Matchers marshaled to JSON as an array of their patterns,
+// such as ["192.168.1.0/24", "*.example.com"], so that a []Matcher can be stored in a JSON config.
+// Each pattern is compiled by DefaultCompiler on unmarshaling, with the '!', 'allow ' and 'deny ' prefixes;
+// a matcher which is not an encoding.TextMarshaler can not be marshaled.
+type MatcherList []Matcher
+
+func (l MatcherList) MarshalJSON() ([]byte, error) {
+	if l == nil {
+		return []byte("null"), nil
+	}
+	patterns := make([]string, 0, len(l))
+	for _, m := range l {
+		tm, ok := m.(encoding.TextMarshaler)
+		if !ok {
+			return nil, fmt.Errorf("bypass: %v has no pattern", m)
+		}
+		text, err := tm.MarshalText()
+		if err != nil {
+			return nil, err
+		}
+		patterns = append(patterns, string(text))
+	}
+	return json.Marshal(patterns)
+}
+
+func (l *MatcherList) UnmarshalJSON(data []byte) error {
+	var patterns []string
+	if err := json.Unmarshal(data, &patterns); err != nil {
+		return err
+	}
+	if patterns == nil {
+		*l = nil
+		return nil
+	}
+
+	list := make(MatcherList, 0, len(patterns))
+	for i, pattern := range patterns {
+		m, err := compilePattern(pattern)
+		if err != nil {
+			return fmt.Errorf("bypass: pattern #%d %q: %w", i, pattern, err)
+		}
+		list = append(list, m)
+	}
+	*l = list
+	return nil
+}
+
+// bypasserJSON is the JSON form of a bypass.
+type bypasserJSON struct {
+	Reversed bool     `json:"reversed"`
+	Period   string   `json:"period,omitempty"`
+	Patterns []string `json:"patterns"`
+}
+
+// MarshalJSON encodes the bypass as its reverse and reload options and the patterns of its rules,
+// such as {"reversed": false, "period": "30s", "patterns": ["10.0.0.0/8", "*.internal"]},
+// to store it as JSON rather than in the config format.
+// A rule without a pattern form, such as an AndMatcher, can not be marshaled,
+// and the other options, such as reverse-domains, are not kept.
+func (bp *bypasser) MarshalJSON() ([]byte, error) {
+	bp.mux.RLock()
+	v := bypasserJSON{
+		Reversed: bp.reversed,
+		Patterns: []string{},
+	}
+	if bp.period != 0 {
+		v.Period = bp.period.String()
+	}
+	for _, m := range bp.matchers {
+		patterns, ok := matcherPatterns(m)
+		if !ok {
+			bp.mux.RUnlock()
+			return nil, fmt.Errorf("bypass: %v has no pattern", m)
+		}
+		v.Patterns = append(v.Patterns, patterns...)
+	}
+	bp.mux.RUnlock()
+
+	return json.Marshal(v)
+}
+
+// UnmarshalJSON replaces the options and the rules of the bypass by the ones of its JSON form, see MarshalJSON.
+// The patterns are compiled as the ones of a config, the bypass is left intact on error.
+func (bp *bypasser) UnmarshalJSON(data []byte) error {
+	var v bypasserJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	var period time.Duration
+	if v.Period != "" {
+		var err error
+		if period, err = time.ParseDuration(v.Period); err != nil {
+			return fmt.Errorf("bypass: period %q: %w", v.Period, err)
+		}
+	}
+	matchers := make([]Matcher, 0, len(v.Patterns))
+	for i, pattern := range v.Patterns {
+		m, err := bp.compile(pattern)
+		if err != nil {
+			return fmt.Errorf("bypass: pattern #%d %q: %w", i, pattern, err)
+		}
+		matchers = append(matchers, m)
+	}
+
+	bp.mux.Lock()
+	defer bp.mux.Unlock()
+
+	if bp.stopped == nil {
+		bp.stopped = make(chan struct{})
+	}
+	bp.setMatchers(matchers)
+	bp.rulesChanged()
+	bp.sections = nil
+	bp.reversed = v.Reversed
+	bp.period = period
+	if bp.reloaded != nil {
+		close(bp.reloaded)
+		bp.reloaded = nil
+	}
+	return nil
+}
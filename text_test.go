@@ -0,0 +1,169 @@
+package bypass
+
+import (
+	"encoding"
+	"encoding/json"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+var textTests = []struct {
+	pattern string
+	text    string
+}{
+	{"192.168.1.1", "192.168.1.1"},
+	{"fe80::1%eth0", "fe80::1%eth0"},
+	{"192.168.1.0/24", "192.168.1.0/24"},
+	{"*.example.com", "*.example.com"},
+	{".example.org", ".example.org"},
+	{"10.0.0.1-10.0.0.9", "10.0.0.1-10.0.0.9"},
+	{`/^api-\d+\.example\.com$/`, `/^api-\d+\.example\.com$/`},
+	{"example.com:443", "example.com:443"},
+	{"https://*.example.com", "https://*.example.com"},
+	{"!secure.example.com", "!secure.example.com"},
+	{"allow www.example.com", "!www.example.com"},
+	{"deny ads.example.com", "deny ads.example.com"},
+}
+
+func TestMatcherText(t *testing.T) {
+	for _, tc := range textTests {
+		m, err := compilePattern(tc.pattern)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.pattern, err)
+		}
+		text, err := m.(encoding.TextMarshaler).MarshalText()
+		if err != nil {
+			t.Errorf("%s: %v", tc.pattern, err)
+			continue
+		}
+		if string(text) != tc.text {
+			t.Errorf("%s: expected the text %q, got %q", tc.pattern, tc.text, text)
+		}
+
+		u := reflect.New(reflect.TypeOf(m).Elem()).Interface().(encoding.TextUnmarshaler)
+		if err := u.UnmarshalText(text); err != nil {
+			t.Errorf("%s: %v", tc.pattern, err)
+			continue
+		}
+		if u.(Matcher).String() != m.String() {
+			t.Errorf("%s: expected %q, got %q", tc.pattern, m.String(), u.(Matcher).String())
+		}
+	}
+
+	var ip ipMatcher
+	if err := ip.UnmarshalText([]byte("*.example.com")); err == nil {
+		t.Errorf("a domain pattern should not be unmarshaled into an IP matcher")
+	}
+	var domain domainMatcher
+	if err := domain.UnmarshalText([]byte("[bad")); err == nil {
+		t.Errorf("a malformed glob should not be unmarshaled")
+	}
+	if _, err := PortMatcher(NewMatcher("example.com"), 80, 443).(encoding.TextMarshaler).MarshalText(); err == nil {
+		t.Errorf("a port matcher created from ports has no pattern")
+	}
+}
+
+func TestMatcherListJSON(t *testing.T) {
+	var config struct {
+		Rules MatcherList `json:"rules"`
+	}
+	data := `{"rules":["192.168.1.0/24","*.example.com","!secure.example.com","example.org:443"]}`
+	if err := json.Unmarshal([]byte(data), &config); err != nil {
+		t.Fatal(err)
+	}
+	if len(config.Rules) != 4 {
+		t.Fatalf("expected 4 rules, got %v", config.Rules)
+	}
+
+	bp := NewBypasser(false, config.Rules...)
+	for addr, bypassed := range map[string]bool{
+		"192.168.1.10":       true,
+		"www.example.com":    true,
+		"secure.example.com": false,
+		"example.org:443":    true,
+		"example.org:80":     false,
+	} {
+		if bp.Bypass(addr) != bypassed {
+			t.Errorf("%s: expected %v", addr, bypassed)
+		}
+	}
+
+	b, err := json.Marshal(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != data {
+		t.Errorf("expected %s, got %s", data, b)
+	}
+
+	if err := json.Unmarshal([]byte(`{"rules":["*.example.com","[bad"]}`), &config); err == nil {
+		t.Errorf("a malformed glob should be reported")
+	}
+	if err := json.Unmarshal([]byte(`{"rules":null}`), &config); err != nil || config.Rules != nil {
+		t.Errorf("null should give a nil list, got %v, %v", config.Rules, err)
+	}
+	if b, err := json.Marshal(MatcherList{ResolveMatcher("example.com", nil)}); err == nil {
+		t.Errorf("a matcher without pattern should not be marshaled, got %s", b)
+	}
+	if b, err := json.Marshal(MatcherList{IPMatcher(net.ParseIP("10.0.0.1"))}); err != nil || string(b) != `["10.0.0.1"]` {
+		t.Errorf("unexpected JSON %s, %v", b, err)
+	}
+}
+
+func TestBypasserJSON(t *testing.T) {
+	bp := NewBypasser(false).(*bypasser)
+	if err := bp.Reload(strings.NewReader("reload 30s\n10.0.0.0/8\n*.internal\n!secure.internal\ndeny ads.example.com\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := json.Marshal(bp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"reversed":false,"period":"30s","patterns":["10.0.0.0/8","*.internal","!secure.internal","deny ads.example.com"]}`
+	if string(data) != want {
+		t.Errorf("expected %s, got %s", want, data)
+	}
+
+	other := &bypasser{}
+	if err := json.Unmarshal(data, other); err != nil {
+		t.Fatal(err)
+	}
+	if !other.Equal(bp) || other.Period() != 30*time.Second {
+		t.Errorf("expected a bypass equivalent to %v, got %v", bp.Matchers(), other.Matchers())
+	}
+	if other.Stopped() || !other.Bypass("db.internal") || other.Bypass("secure.internal") {
+		t.Errorf("the unmarshaled bypass should be usable")
+	}
+	other.Stop()
+	if !other.Stopped() {
+		t.Errorf("the unmarshaled bypass should be stoppable")
+	}
+
+	if err := json.Unmarshal([]byte(`{"reversed":true,"patterns":["*.example.com"]}`), bp); err != nil {
+		t.Fatal(err)
+	}
+	if !bp.Reversed() || bp.Period() != 0 || bp.Len() != 1 {
+		t.Errorf("the options and the rules should be replaced")
+	}
+	for _, data := range []string{
+		`{"period":"often","patterns":["10.0.0.0/8"]}`,
+		`{"patterns":["10.0.0.0/8","[bad"]}`,
+		`{"patterns":[""]}`,
+		`[]`,
+	} {
+		if err := json.Unmarshal([]byte(data), bp); err == nil {
+			t.Errorf("%s: expected an error", data)
+		}
+	}
+	if !bp.Reversed() || bp.Len() != 1 {
+		t.Errorf("the bypass should be left intact on error")
+	}
+
+	if _, err := json.Marshal(NewBypasser(false, AndMatcher(NewMatcher("*.example.com"), NewMatcher("*.example.org")))); err == nil {
+		t.Errorf("a rule without pattern should not be marshaled")
+	}
+}
@@ -0,0 +1,153 @@
+package bypass
+
+import (
+	"net"
+	"strconv"
+	"strings"
+)
+
+// PortQualifier is implemented by the Matchers applying to specific ports only.
+// The port is zero if it is unknown.
+type PortQualifier interface {
+	MatchPort(port int) bool
+}
+
+// ProtoQualifier is implemented by the Matchers applying to specific protocols only.
+// The proto is empty if it is unknown.
+type ProtoQualifier interface {
+	MatchProto(proto string) bool
+}
+
+// matchQualifiers reports whether the port and proto satisfy the qualifiers of the matcher m, if any.
+func matchQualifiers(m Matcher, port int, proto string) bool {
+	if q, ok := m.(PortQualifier); ok && !q.MatchPort(port) {
+		return false
+	}
+	if q, ok := m.(ProtoQualifier); ok && !q.MatchProto(proto) {
+		return false
+	}
+	return true
+}
+
+type qualifiedMatcher struct {
+	Matcher
+	ports  []int
+	protos []string
+}
+
+// QualifyMatcher restricts the Matcher m to the given ports and protocols (e.g. 'tcp', 'udp').
+// An empty list leaves the corresponding component unrestricted,
+// otherwise an unknown port or protocol does not satisfy the restriction.
+func QualifyMatcher(m Matcher, ports []int, protos []string) Matcher {
+	return &qualifiedMatcher{
+		Matcher: m,
+		ports:   ports,
+		protos:  protos,
+	}
+}
+
+func (m *qualifiedMatcher) Match(host string) bool {
+	if m == nil || m.Matcher == nil {
+		return false
+	}
+	return m.Matcher.Match(host)
+}
+
+func (m *qualifiedMatcher) MatchPort(port int) bool {
+	if len(m.ports) == 0 {
+		return true
+	}
+	for _, p := range m.ports {
+		if p == port {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *qualifiedMatcher) MatchProto(proto string) bool {
+	if len(m.protos) == 0 {
+		return true
+	}
+	for _, p := range m.protos {
+		if strings.EqualFold(p, proto) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *qualifiedMatcher) String() string {
+	s := m.Matcher.String()
+	if len(m.ports) > 0 {
+		var ports []string
+		for _, p := range m.ports {
+			ports = append(ports, strconv.Itoa(p))
+		}
+		s += " port " + strings.Join(ports, ",")
+	}
+	if len(m.protos) > 0 {
+		s += " proto " + strings.Join(m.protos, ",")
+	}
+	return s
+}
+
+// BypassTuple reports whether the connection to host and port over the protocol proto should be bypassed.
+// A rule matches when its host matcher matches the host,
+// AND, if the rule is qualified by ports (see PortQualifier), one of its ports is the port,
+// AND, if the rule is qualified by protocols (see ProtoQualifier), one of its protocols is proto.
+// The rules are ORed, then the reversed flag applies as for Bypass.
+// Bypass evaluates the same rules with the port of the address (zero if there is none) and an unknown protocol.
+// The decision is counted in Stats and evaluated against the shadow, as the one of Bypass.
+func (bp *bypasser) BypassTuple(host string, port int, proto string) bool {
+	if bp == nil || host == "" {
+		return false
+	}
+
+	addr := host
+	if port > 0 {
+		addr = net.JoinHostPort(host, strconv.Itoa(port))
+	}
+	bypassed, _ := bp.bypassTarget(target{
+		addr:  addr,
+		host:  trimTrailingDot(host),
+		port:  port,
+		proto: proto,
+	}, true)
+	return bypassed
+}
+
+// BypassAddr reports whether the connection to the address addr should be bypassed, as BypassTuple does
+// with the IP, the zone and the port of a TCP, UDP or IP address, and its network as the protocol,
+// so that the net.Addr of a connection is matched without formatting and parsing it.
+// A Unix socket address is matched by its path, as a domain, with no port.
+// Any other address is matched by its String() form, as Bypass does.
+// Either way the decision is counted in Stats and evaluated against the shadow.
+func (bp *bypasser) BypassAddr(addr net.Addr) bool {
+	switch a := addr.(type) {
+	case nil:
+		return false
+	case *net.TCPAddr:
+		return a != nil && bp.BypassTuple(ipZoneString(a.IP, a.Zone), a.Port, a.Network())
+	case *net.UDPAddr:
+		return a != nil && bp.BypassTuple(ipZoneString(a.IP, a.Zone), a.Port, a.Network())
+	case *net.IPAddr:
+		return a != nil && bp.BypassTuple(ipZoneString(a.IP, a.Zone), 0, a.Network())
+	case *net.UnixAddr:
+		return a != nil && bp.BypassTuple(a.Name, 0, a.Network())
+	default:
+		return bp.Bypass(addr.String())
+	}
+}
+
+// ipZoneString returns the IP address ip with its IPv6 zone, if any, such as 'fe80::1%eth0',
+// empty if ip is not set.
+func ipZoneString(ip net.IP, zone string) string {
+	if len(ip) == 0 {
+		return ""
+	}
+	if zone != "" {
+		return ip.String() + "%" + zone
+	}
+	return ip.String()
+}
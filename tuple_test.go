@@ -0,0 +1,157 @@
+package bypass
+
+import (
+	"fmt"
+	"net"
+	"testing"
+)
+
+func newTupleBypasser(reversed bool) *bypasser {
+	return NewBypasser(reversed,
+		NewMatcher("10.0.0.0/8"),
+		QualifyMatcher(NewMatcher("*.example.com"), []int{443}, nil),
+		QualifyMatcher(NewMatcher("dns.example.org"), []int{53}, []string{"udp"}),
+		QualifyMatcher(NewMatcher("192.168.1.1"), nil, []string{"tcp"}),
+	).(*bypasser)
+}
+
+var bypassTupleTests = []struct {
+	host     string
+	port     int
+	proto    string
+	bypassed bool
+}{
+	// unqualified rule: any port, any proto
+	{"10.1.2.3", 80, "tcp", true},
+	{"10.1.2.3", 0, "", true},
+	{"11.1.2.3", 80, "tcp", false},
+
+	// port qualified rule
+	{"www.example.com", 443, "tcp", true},
+	{"www.example.com", 443, "", true},
+	{"www.example.com", 80, "tcp", false},
+	{"www.example.com", 0, "tcp", false},
+	{"www.example.net", 443, "tcp", false},
+
+	// port and proto qualified rule
+	{"dns.example.org", 53, "udp", true},
+	{"dns.example.org", 53, "UDP", true},
+	{"dns.example.org", 53, "tcp", false},
+	{"dns.example.org", 53, "", false},
+	{"dns.example.org", 5353, "udp", false},
+
+	// proto qualified rule
+	{"192.168.1.1", 22, "tcp", true},
+	{"192.168.1.1", 0, "tcp", true},
+	{"192.168.1.1", 22, "udp", false},
+	{"192.168.1.1", 22, "", false},
+
+	{"", 80, "tcp", false},
+}
+
+func TestBypassTuple(t *testing.T) {
+	for i, tc := range bypassTupleTests {
+		tc := tc
+		t.Run(fmt.Sprintf("#%d", i), func(t *testing.T) {
+			if newTupleBypasser(false).BypassTuple(tc.host, tc.port, tc.proto) != tc.bypassed {
+				t.Errorf("#%d test failed: %s, %d, %s", i, tc.host, tc.port, tc.proto)
+			}
+			if tc.host != "" && newTupleBypasser(true).BypassTuple(tc.host, tc.port, tc.proto) == tc.bypassed {
+				t.Errorf("#%d reversed test failed: %s, %d, %s", i, tc.host, tc.port, tc.proto)
+			}
+		})
+	}
+}
+
+func TestBypassTupleStats(t *testing.T) {
+	bp := newTupleBypasser(false)
+	bp.SetShadow(NewBypasserPatterns(false, "10.0.0.0/8"))
+
+	if !bp.BypassTuple("www.example.com", 443, "tcp") || bp.BypassTuple("www.example.com", 80, "tcp") {
+		t.Fatalf("the tuple decisions are wrong")
+	}
+	stats := bp.Stats()
+	if stats.Calls != 2 || stats.Bypassed != 1 {
+		t.Errorf("expected 2 calls and 1 bypassed, got %d and %d", stats.Calls, stats.Bypassed)
+	}
+	if n := stats.Hits["domain *.example.com port 443"]; n != 1 {
+		t.Errorf("expected 1 hit of the qualified rule, got %v", stats.Hits)
+	}
+	if n := bp.ShadowDivergences(); n != 1 {
+		t.Errorf("expected 1 divergence, got %d", n)
+	}
+}
+
+func TestBypassQualified(t *testing.T) {
+	bp := newTupleBypasser(false)
+	for i, tc := range []struct {
+		addr     string
+		bypassed bool
+	}{
+		{"www.example.com:443", true},
+		{"www.example.com:80", false},
+		{"www.example.com", false},
+		{"dns.example.org:53", false},
+		{"192.168.1.1:22", false},
+		{"10.1.2.3:22", true},
+	} {
+		if bp.Bypass(tc.addr) != tc.bypassed {
+			t.Errorf("#%d test failed: %s", i, tc.addr)
+		}
+	}
+}
+
+func TestQualifiedMatcherString(t *testing.T) {
+	m := QualifyMatcher(NewMatcher("*.example.com"), []int{80, 443}, []string{"tcp"})
+	if s, want := m.String(), "domain *.example.com port 80,443 proto tcp"; s != want {
+		t.Errorf("expected %q, got %q", want, s)
+	}
+}
+
+// stringAddr is a net.Addr of another type.
+type stringAddr string
+
+func (a stringAddr) Network() string { return "custom" }
+func (a stringAddr) String() string  { return string(a) }
+
+func TestBypassAddr(t *testing.T) {
+	bp := NewBypasser(false,
+		NewMatcher("10.0.0.0/8"),
+		NewMatcher("fe80::1%eth0"),
+		NewMatcher("192.168.1.1:53"),
+		QualifyMatcher(NewMatcher("172.16.0.1"), nil, []string{"udp"}),
+		NewMatcher("/var/run/*.sock"),
+		NewMatcher("*.example.com"),
+	).(*bypasser)
+
+	for i, tc := range []struct {
+		addr     net.Addr
+		bypassed bool
+	}{
+		{&net.TCPAddr{IP: net.ParseIP("10.1.2.3"), Port: 443}, true},
+		{&net.TCPAddr{IP: net.ParseIP("11.1.2.3"), Port: 443}, false},
+		{&net.TCPAddr{IP: net.ParseIP("fe80::1"), Port: 22, Zone: "eth0"}, true},
+		{&net.TCPAddr{Port: 8080}, false},
+		{&net.UDPAddr{IP: net.ParseIP("192.168.1.1"), Port: 53}, true},
+		{&net.UDPAddr{IP: net.ParseIP("192.168.1.1"), Port: 5353}, false},
+		{&net.UDPAddr{IP: net.ParseIP("172.16.0.1"), Port: 53}, true},
+		{&net.TCPAddr{IP: net.ParseIP("172.16.0.1"), Port: 53}, false},
+		{&net.IPAddr{IP: net.ParseIP("10.0.0.1")}, true},
+		{&net.UnixAddr{Name: "/var/run/docker.sock", Net: "unix"}, true},
+		{&net.UnixAddr{Name: "/tmp/app.sock", Net: "unix"}, false},
+		{stringAddr("www.example.com:443"), true},
+		{(*net.TCPAddr)(nil), false},
+		{nil, false},
+	} {
+		if bypassed := bp.BypassAddr(tc.addr); bypassed != tc.bypassed {
+			t.Errorf("#%d %v: expected %v, got %v", i, tc.addr, tc.bypassed, bypassed)
+		}
+	}
+
+	bp.ResetStats()
+	bp.BypassAddr(&net.TCPAddr{IP: net.ParseIP("10.1.2.3"), Port: 443})
+	bp.BypassAddr(&net.UDPAddr{IP: net.ParseIP("192.168.1.1"), Port: 5353})
+	if stats := bp.Stats(); stats.Calls != 2 || stats.Bypassed != 1 {
+		t.Errorf("expected 2 calls and 1 bypassed, got %d and %d", stats.Calls, stats.Bypassed)
+	}
+}
@@ -0,0 +1,116 @@
+// Package yamlconfig loads bypass rules from YAML documents,
+// it keeps the YAML dependency out of the bypass package.
+package yamlconfig
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/go-gost/bypass"
+	yaml "gopkg.in/yaml.v3"
+)
+
+// Group is a named set of rules sharing the same reverse semantics.
+type Group struct {
+	Name     string   `yaml:"name"`
+	Reverse  bool     `yaml:"reverse"`
+	Patterns []string `yaml:"patterns"`
+}
+
+type groupsConfig struct {
+	Groups []Group `yaml:"groups"`
+}
+
+// NewBypasserYAML creates a Bypasser from a YAML document with rule groups, such as:
+//
+//	groups:
+//	- name: corp
+//	  reverse: false
+//	  patterns: ["10.0.0.0/8", "*.corp.example.com"]
+//
+// Each group applies its own reverse semantics, an address is bypassed if any group bypasses it.
+// Unknown keys are ignored and groups without patterns are skipped.
+func NewBypasserYAML(r io.Reader) (bypass.Bypasser, error) {
+	var config groupsConfig
+	if err := yaml.NewDecoder(r).Decode(&config); err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	var bps []bypass.Bypasser
+	for _, group := range config.Groups {
+		if len(group.Patterns) == 0 {
+			continue
+		}
+		bps = append(bps, bypass.NewBypasserPatterns(group.Reverse, group.Patterns...))
+	}
+	return bypass.Merge(bps...), nil
+}
+
+type config struct {
+	Reversed bool     `yaml:"reversed"`
+	Reload   string   `yaml:"reload"`
+	Rules    []string `yaml:"rules"`
+}
+
+// LoadYAML creates a Bypasser from a YAML document with the options and the rules of a config, such as:
+//
+//	reversed: false
+//	reload: 30s
+//	rules:
+//	- 10.0.0.0/8
+//	- "*.example.com"
+//	- "!www.example.com"
+//
+// The rules are the patterns of the line format, prefixes such as '!' or 'deny' included,
+// and the reload period is only recorded, see bypass.ReloadableBypasser.
+// A rule is a single pattern, never a directive of the line format such as 'include' or 'reverse',
+// a rule with white space other than after its prefix, a '#' or a quote is an error.
+// The returned Bypasser is a bypass.ReloadableBypasser.
+func LoadYAML(r io.Reader) (bypass.Bypasser, error) {
+	var cfg config
+	if err := yaml.NewDecoder(r).Decode(&cfg); err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "reverse %v\n", cfg.Reversed)
+	if cfg.Reload != "" {
+		period, err := time.ParseDuration(cfg.Reload)
+		if err != nil {
+			return nil, fmt.Errorf("yamlconfig: invalid reload period %q", cfg.Reload)
+		}
+		fmt.Fprintf(&b, "reload %v\n", period)
+	}
+	for _, rule := range cfg.Rules {
+		line, ok := ruleLine(rule)
+		if !ok {
+			return nil, fmt.Errorf("yamlconfig: invalid rule %q", rule)
+		}
+		b.WriteString(line + "\n")
+	}
+
+	bp := bypass.NewBypasser(false).(bypass.ReloadableBypasser)
+	if err := bp.Reload(strings.NewReader(b.String())); err != nil {
+		return nil, err
+	}
+	return bp, nil
+}
+
+// ruleLine returns the config line of the rule, its pattern quoted so that it is not read as a directive.
+// It returns false if the rule is not a single pattern, with its optional allow or deny prefix.
+func ruleLine(rule string) (string, bool) {
+	prefix, pattern := "", rule
+	for _, p := range []string{"allow ", "deny "} {
+		if v, ok := strings.CutPrefix(rule, p); ok {
+			prefix, pattern = p, v
+			break
+		}
+	}
+	if pattern == "" || strings.ContainsFunc(pattern, unicode.IsSpace) || strings.ContainsAny(pattern, `#"`) {
+		return "", false
+	}
+	return prefix + `"` + pattern + `"`, true
+}
@@ -0,0 +1,169 @@
+package yamlconfig
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-gost/bypass"
+)
+
+const groupsYAML = `
+version: 2
+groups:
+- name: corp
+  reverse: false
+  patterns:
+  - 10.0.0.0/8
+  - "*.corp.example.com"
+- name: empty
+  reverse: true
+- name: public
+  reverse: true
+  owner: network-team
+  patterns:
+  - "*.example.com"
+`
+
+func TestNewBypasserYAML(t *testing.T) {
+	bp, err := NewBypasserYAML(strings.NewReader(groupsYAML))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, tc := range []struct {
+		addr     string
+		bypassed bool
+	}{
+		{"10.1.2.3", true},             // corp
+		{"www.corp.example.com", true}, // corp, in spite of public
+		{"www.example.com", false},     // neither
+		{"example.org", true},          // public (reversed)
+		{"10.1.2.3:80", true},
+	} {
+		t.Run(fmt.Sprintf("#%d", i), func(t *testing.T) {
+			if bp.Bypass(tc.addr) != tc.bypassed {
+				t.Errorf("#%d test failed: %s", i, tc.addr)
+			}
+		})
+	}
+}
+
+func TestNewBypasserYAMLEmpty(t *testing.T) {
+	bp, err := NewBypasserYAML(strings.NewReader(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bp.Bypass("example.com") {
+		t.Errorf("empty document should bypass nothing")
+	}
+
+	if _, err := NewBypasserYAML(strings.NewReader("groups: [")); err == nil {
+		t.Errorf("expected an error for a malformed document")
+	}
+}
+
+const configYAML = `
+reversed: true
+reload: 1m30s
+rules:
+- 10.0.0.0/8
+- "*.example.com"
+- "!www.example.com"
+- deny ads.example.com
+`
+
+func TestLoadYAML(t *testing.T) {
+	v, err := LoadYAML(strings.NewReader(configYAML))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bp := v.(interface {
+		bypass.ReloadableBypasser
+		Reversed() bool
+		Matchers() []bypass.Matcher
+	})
+	if !bp.Reversed() {
+		t.Errorf("expected a reversed bypass")
+	}
+	if p := bp.Period(); p != 90*time.Second {
+		t.Errorf("expected a period of 1m30s, got %v", p)
+	}
+
+	var rules []string
+	for _, m := range bp.Matchers() {
+		rules = append(rules, m.String())
+	}
+	expected := []string{
+		"cidr 10.0.0.0/8",
+		"domain *.example.com",
+		"!domain www.example.com",
+		"deny domain ads.example.com",
+	}
+	if !slices.Equal(rules, expected) {
+		t.Errorf("expected the rules %q, got %q", expected, rules)
+	}
+
+	for addr, bypassed := range map[string]bool{
+		"10.1.2.3":        false,
+		"api.example.com": false,
+		"www.example.com": false,
+		"ads.example.com": true,
+		"example.org":     true,
+	} {
+		if bp.Bypass(addr) != bypassed {
+			t.Errorf("%s: expected %v", addr, bypassed)
+		}
+	}
+}
+
+func TestLoadYAMLDirectives(t *testing.T) {
+	v, err := LoadYAML(strings.NewReader("reload: 30s\nrules:\n- reverse\n- reload\n- \"[corp]\"\n- deny include\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bp := v.(interface {
+		bypass.ReloadableBypasser
+		Reversed() bool
+		Matchers() []bypass.Matcher
+		Sections() []string
+	})
+	if bp.Reversed() || bp.Period() != 30*time.Second || len(bp.Sections()) != 0 {
+		t.Errorf("the rules should not change the options")
+	}
+	var rules []string
+	for _, m := range bp.Matchers() {
+		rules = append(rules, m.String())
+	}
+	expected := []string{"domain reverse", "domain reload", "domain [corp]", "deny domain include"}
+	if !slices.Equal(rules, expected) {
+		t.Errorf("the rules should be patterns, expected %q, got %q", expected, rules)
+	}
+}
+
+func TestLoadYAMLErrors(t *testing.T) {
+	for _, doc := range []string{
+		"rules: [",
+		"reload: soon",
+		"rules: [\"a.example.com\\nreverse true\"]",
+		"rules: [\"a.example.com # x\"]",
+		"rules: [\"a.example.com b.example.com\"]",
+		"rules: [\"include /etc/passwd\"]",
+		"rules: [\"deny \"]",
+		"rules: ['\"quoted\"']",
+	} {
+		if _, err := LoadYAML(strings.NewReader(doc)); err == nil {
+			t.Errorf("%q: expected an error", doc)
+		}
+	}
+
+	bp, err := LoadYAML(strings.NewReader(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bp.Bypass("example.com") {
+		t.Errorf("an empty document should bypass nothing")
+	}
+}